@@ -0,0 +1,515 @@
+// Package config reads the environment the server needs into one struct at
+// startup, so missing credentials are reported at boot rather than as empty
+// results on the first request
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abadojack/whatlanggo"
+)
+
+const (
+	defaultBedrockRegion  = "us-east-1"
+	defaultBedrockModelID = "anthropic.claude-3-5-sonnet-20240620-v1:0"
+	// google custom search won't return more than this per request
+	maxResultCount = 10
+)
+
+type Config struct {
+	// MockMode swaps google, reddit and bedrock for canned responses
+	MockMode      bool
+	RedisEndpoint string
+	// AdminToken enables admin-only features, which are off when it's empty
+	AdminToken string
+
+	Search  Search
+	Reddit  Reddit
+	Bedrock Bedrock
+	Stats   Stats
+
+	CacheTTL time.Duration
+	// matchups that produced no advice are retried after this plus up to
+	// NegativeCacheJitter, rather than waiting out CacheTTL
+	NegativeCacheTTL    time.Duration
+	NegativeCacheJitter time.Duration
+	// gzip cached matchups, entries are read either way
+	CacheCompression bool
+	// in process cache in front of redis for hot matchups, 0 entries turns it
+	// off. Kept short since other instances can regenerate a matchup.
+	L1CacheSize int
+	L1CacheTTL  time.Duration
+	// longest advice that's cached and returned, 0 for no limit
+	MaxAdviceChars int
+	// compute budget for a single matchup across search, scrape and summarize
+	MatchupTimeout time.Duration
+	SourceTimeout  time.Duration
+
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+
+	// origins allowed to call the api from a browser, the production site
+	// when empty
+	AllowedOrigins []string
+	// token bucket per client ip for cache misses, an RPS of 0 turns it off
+	RateLimitRPS   float64
+	RateLimitBurst int
+	// take the client ip from X-Forwarded-For, only safe behind a load balancer
+	TrustProxy bool
+	// matchups a batch warms at once
+	BatchConcurrency int
+	// most names suggested for a partly typed champion
+	ChampionSuggestionLimit int
+	// summarize every source in one bedrock call instead of one per source
+	SummarizeBatch bool
+	// scrape and summarize pipelines in flight at once across every matchup
+	MaxSourceConcurrency int
+	// overrides for how authoritative each subreddit is, see
+	// models.SubredditWeight
+	SubredditWeights map[string]float64
+	// rewrite cached advice for the non canonical champion's side instead of
+	// serving it from the canonical champion's view
+	RewriteReversed bool
+}
+
+type Search struct {
+	MockMode bool
+	// "google" or "brave", the other is used as a fallback when its key is set
+	Provider     string
+	GoogleAPIKey string
+	GoogleCSEID  string
+	BraveAPIKey  string
+
+	// results asked for per search, google won't return more than 10
+	ResultCount int
+	// usable results below which a broader second search is run
+	MinResults int
+	// subreddits that are never used, the search package's defaults when nil
+	Blocklist []string
+}
+
+type Reddit struct {
+	MockMode     bool
+	ClientID     string
+	ClientSecret string
+	Username     string
+	Password     string
+	UserAgent    string
+
+	// reddit calls in flight at once across every scrape
+	MaxConcurrency int
+	// how many comments reddit returns per thread, how deep it nests replies
+	// and what it ranks them by
+	CommentLimit int
+	CommentDepth int
+	CommentSort  string
+	// collapsed comments fetched per thread, 0 skips the follow-up request
+	MoreCommentsLimit int
+	// comments by these authors or with exactly these bodies are dropped, the
+	// scrape package's defaults when nil
+	FilteredAuthors []string
+	FilteredBodies  []string
+	// comments shorter than this many characters are dropped, 0 keeps them all
+	MinCommentLength int
+}
+
+// Stats is the optional win rate source blended in with the reddit advice
+type Stats struct {
+	MockMode bool
+	Enabled  bool
+	// queried with champion, opponent and role parameters
+	APIURL string
+}
+
+type Bedrock struct {
+	MockMode bool
+	Region   string
+	// used for the per-source summary
+	ModelID string
+	// used for quality control, can be a cheaper model like haiku
+	QCModelID string
+	// tried in order for the summary when ModelID (or the fallback before)
+	// isn't available, e.g. not enabled in the region or out of quota
+	FallbackModelIDs []string
+
+	// sampling for both calls. The defaults are deterministic so regenerating
+	// a matchup gives (nearly) the same advice as what's cached.
+	Temperature float64
+	TopP        float64
+	// summary temperature for matchups with few sources when the client asks
+	// for exploratory advice, more willing to synthesize from thin content
+	ExploratoryTemperature float64
+
+	// how much of each thread is sent to the model: top level comments,
+	// replies under each, levels of replies and points per summary.
+	// Comments scored at or below MinCommentScore are dropped.
+	TopComments      int
+	TopReplies       int
+	MaxReplyDepth    int
+	MaxSummaryPoints int
+	MinCommentScore  int
+	// comment scores halve every CommentHalfLife so recent advice outranks
+	// upvoted advice from old patches
+	CommentHalfLife time.Duration
+	// send post flair and awards, which help spot meme threads
+	IncludeFlair bool
+	// post bodies longer than this are cut, 0 sends them whole. Write-ups, a
+	// body of at least WriteupMinChars on a post scored WriteupMinScore, are
+	// flagged to the model and sent whole anyway while KeepWriteups is on.
+	MaxPostChars    int
+	KeepWriteups    bool
+	WriteupMinChars int
+	WriteupMinScore int
+
+	// drop threads confidently detected as some language other than
+	// TargetLang, off by default since short jargon heavy threads can fool it
+	LanguageFilter bool
+	TargetLang     whatlanggo.Lang
+
+	// attempts at a throttled or failing bedrock call before giving up
+	MaxAttempts int
+
+	// USD per 1k tokens, for estimating what a matchup cost
+	InputPricePer1K  float64
+	OutputPricePer1K float64
+}
+
+// Load reads the config from the environment. Every missing or invalid value
+// is collected so the error lists all of them at once.
+func Load() (*Config, error) {
+	var problems []string
+
+	required := func(key string) string {
+		v := os.Getenv(key)
+		if v == "" {
+			problems = append(problems, fmt.Sprintf("%s is not set", key))
+		}
+		return v
+	}
+
+	seconds := func(key string, fallback time.Duration) time.Duration {
+		v := os.Getenv(key)
+		if v == "" {
+			return fallback
+		}
+
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			problems = append(problems, fmt.Sprintf("%s %q is not a positive number of seconds", key, v))
+			return fallback
+		}
+		return time.Duration(n) * time.Second
+	}
+
+	count := func(key string, fallback int) int {
+		v := os.Getenv(key)
+		if v == "" {
+			return fallback
+		}
+
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			problems = append(problems, fmt.Sprintf("%s %q is not a non-negative number", key, v))
+			return fallback
+		}
+		return n
+	}
+
+	unit := func(key string, fallback float64) float64 {
+		v := os.Getenv(key)
+		if v == "" {
+			return fallback
+		}
+
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			problems = append(problems, fmt.Sprintf("%s %q is not a number between 0 and 1", key, v))
+			return fallback
+		}
+		return f
+	}
+
+	positive := func(key string, fallback int) int {
+		v := os.Getenv(key)
+		if v == "" {
+			return fallback
+		}
+
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			problems = append(problems, fmt.Sprintf("%s %q is not a positive number", key, v))
+			return fallback
+		}
+		return n
+	}
+
+	amount := func(key string, fallback float64) float64 {
+		v := os.Getenv(key)
+		if v == "" {
+			return fallback
+		}
+
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			problems = append(problems, fmt.Sprintf("%s %q is not a non-negative number", key, v))
+			return fallback
+		}
+		return f
+	}
+
+	// comma separated name:weight pairs like "summonerschool:2,mains:0.5"
+	weights := func(key string) map[string]float64 {
+		values := make(map[string]float64)
+		for _, pair := range strings.Split(os.Getenv(key), ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+
+			name, value, _ := strings.Cut(pair, ":")
+			name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "r/"))
+			weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if name == "" || err != nil || weight < 0 {
+				problems = append(problems, fmt.Sprintf("%s entry %q is not a subreddit:weight pair", key, pair))
+				continue
+			}
+			values[name] = weight
+		}
+		return values
+	}
+
+	list := func(key string) []string {
+		var values []string
+		for _, v := range strings.Split(os.Getenv(key), ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		return values
+	}
+
+	cfg := &Config{
+		MockMode:      os.Getenv("MOCK_MODE") == "true",
+		RedisEndpoint: required("REDIS_ENDPOINT"),
+		AdminToken:    os.Getenv("ADMIN_TOKEN"),
+
+		Search: Search{
+			Provider:     envOr("SEARCH_PROVIDER", "google"),
+			GoogleAPIKey: os.Getenv("CUSTOM_SEARCH_API_KEY"),
+			GoogleCSEID:  os.Getenv("CUSTOM_SEARCH_CSE_ID"),
+			BraveAPIKey:  os.Getenv("BRAVE_SEARCH_API_KEY"),
+
+			ResultCount: positive("SEARCH_RESULT_COUNT", 4),
+			MinResults:  count("SEARCH_MIN_RESULTS", 2),
+		},
+
+		Reddit: Reddit{
+			ClientID:     os.Getenv("REDDIT_CLIENT_ID"),
+			ClientSecret: os.Getenv("REDDIT_CLIENT_SECRET"),
+			Username:     os.Getenv("REDDIT_CLIENT_USERNAME"),
+			Password:     os.Getenv("REDDIT_CLIENT_PASSWORD"),
+
+			MaxConcurrency:    positive("REDDIT_MAX_CONCURRENCY", 2),
+			CommentLimit:      positive("REDDIT_COMMENT_LIMIT", 100),
+			CommentDepth:      positive("REDDIT_COMMENT_DEPTH", 5),
+			CommentSort:       envOr("REDDIT_COMMENT_SORT", "top"),
+			MoreCommentsLimit: count("MORE_COMMENTS_LIMIT", 20),
+			FilteredAuthors:   list("FILTERED_AUTHORS"),
+			FilteredBodies:    list("FILTERED_BODIES"),
+			// about the shortest comment that can explain anything, "ban him"
+			// and "stat check" don't
+			MinCommentLength: count("MIN_COMMENT_LENGTH", 20),
+		},
+
+		Bedrock: Bedrock{
+			Region:  envOr("BEDROCK_REGION", defaultBedrockRegion),
+			ModelID: envOr("BEDROCK_MODEL_ID", defaultBedrockModelID),
+
+			FallbackModelIDs: list("BEDROCK_FALLBACK_MODEL_IDS"),
+
+			Temperature:            unit("BEDROCK_TEMPERATURE", 0),
+			TopP:                   unit("BEDROCK_TOP_P", 0.5),
+			ExploratoryTemperature: unit("BEDROCK_EXPLORATORY_TEMPERATURE", 0.7),
+
+			TopComments:      count("TOP_COMMENTS", 5),
+			TopReplies:       count("TOP_REPLIES", 2),
+			MaxReplyDepth:    count("MAX_REPLY_DEPTH", 1),
+			MaxSummaryPoints: positive("MAX_SUMMARY_POINTS", 3),
+			MinCommentScore:  count("MIN_COMMENT_SCORE", 1),
+			CommentHalfLife:  time.Duration(positive("COMMENT_HALF_LIFE_DAYS", 90)) * 24 * time.Hour,
+			IncludeFlair:     os.Getenv("INCLUDE_POST_FLAIR") == "true",
+			MaxPostChars:     count("MAX_POST_CHARS", 0),
+			KeepWriteups:     os.Getenv("KEEP_LONG_WRITEUPS") != "false",
+			WriteupMinChars:  count("WRITEUP_MIN_CHARS", 1500),
+			WriteupMinScore:  count("WRITEUP_MIN_SCORE", 50),
+
+			MaxAttempts: positive("BEDROCK_MAX_ATTEMPTS", 4),
+
+			LanguageFilter: os.Getenv("LANGUAGE_FILTER") == "true",
+			TargetLang:     whatlanggo.Eng,
+
+			// claude 3.5 sonnet's prices, should match the configured model
+			InputPricePer1K:  amount("BEDROCK_INPUT_PRICE_PER_1K", 0.003),
+			OutputPricePer1K: amount("BEDROCK_OUTPUT_PRICE_PER_1K", 0.015),
+		},
+
+		Stats: Stats{
+			Enabled: os.Getenv("STATS_ENABLED") == "true",
+			APIURL:  os.Getenv("STATS_API_URL"),
+		},
+
+		CacheTTL:         seconds("CACHE_TTL", 30*24*time.Hour),
+		CacheCompression: os.Getenv("CACHE_COMPRESSION") == "true",
+		L1CacheSize:      count("L1_CACHE_SIZE", 1000),
+		L1CacheTTL:       seconds("L1_CACHE_TTL", 10*time.Second),
+		MaxAdviceChars:   count("MAX_ADVICE_CHARS", 6000),
+		MatchupTimeout:   seconds("MATCHUP_TIMEOUT", 3*time.Minute),
+		SourceTimeout:    seconds("SOURCE_TIMEOUT", 45*time.Second),
+
+		NegativeCacheTTL: seconds("NEGATIVE_CACHE_TTL", 12*time.Hour),
+		// no jitter is fine, so it's a count rather than positive seconds
+		NegativeCacheJitter: time.Duration(count("NEGATIVE_CACHE_JITTER", 3600)) * time.Second,
+
+		ReadTimeout:     seconds("READ_TIMEOUT", 10*time.Second),
+		WriteTimeout:    seconds("WRITE_TIMEOUT", 200*time.Second),
+		IdleTimeout:     seconds("IDLE_TIMEOUT", 120*time.Second),
+		ShutdownTimeout: seconds("SHUTDOWN_TIMEOUT", 30*time.Second),
+
+		AllowedOrigins:          list("CORS_ALLOWED_ORIGINS"),
+		RateLimitRPS:            amount("RATE_LIMIT_RPS", 0.2),
+		RateLimitBurst:          positive("RATE_LIMIT_BURST", 3),
+		TrustProxy:              os.Getenv("TRUST_PROXY") == "true",
+		BatchConcurrency:        positive("BATCH_CONCURRENCY", 2),
+		ChampionSuggestionLimit: positive("CHAMPION_SUGGESTION_LIMIT", 10),
+		SummarizeBatch:          os.Getenv("SUMMARIZE_MODE") == "batch",
+		MaxSourceConcurrency:    positive("MAX_SOURCE_CONCURRENCY", 4),
+		RewriteReversed:         os.Getenv("REWRITE_REVERSED") == "true",
+		SubredditWeights:        weights("SUBREDDIT_WEIGHTS"),
+	}
+	cfg.Bedrock.QCModelID = envOr("BEDROCK_QC_MODEL_ID", cfg.Bedrock.ModelID)
+
+	if v := os.Getenv("TARGET_LANG"); v != "" {
+		lang, ok := language(v)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("TARGET_LANG %q is not a known language", v))
+		}
+		cfg.Bedrock.TargetLang = lang
+	}
+
+	// each stage package only sees its own section
+	cfg.Search.MockMode = cfg.MockMode
+	cfg.Reddit.MockMode = cfg.MockMode
+	cfg.Bedrock.MockMode = cfg.MockMode
+	cfg.Stats.MockMode = cfg.MockMode
+
+	// reddit doesn't return more than 500 comments per listing
+	if cfg.Reddit.CommentLimit > 500 {
+		problems = append(problems, fmt.Sprintf("REDDIT_COMMENT_LIMIT %d is above reddit's limit of 500", cfg.Reddit.CommentLimit))
+	}
+	switch cfg.Reddit.CommentSort {
+	case "top", "best", "confidence", "controversial", "new", "old", "qa":
+	default:
+		problems = append(problems, fmt.Sprintf("REDDIT_COMMENT_SORT %q is not a sort reddit accepts", cfg.Reddit.CommentSort))
+	}
+
+	// asking for more results than google returns is clamped, not refused
+	if cfg.Search.ResultCount > maxResultCount {
+		slog.Warn("SEARCH_RESULT_COUNT is above google's limit, clamping", "count", cfg.Search.ResultCount, "limit", maxResultCount)
+		cfg.Search.ResultCount = maxResultCount
+	}
+
+	// SUBREDDIT_BLOCKLIST wins over a json array in blocklist.json
+	cfg.Search.Blocklist = list("SUBREDDIT_BLOCKLIST")
+	if cfg.Search.Blocklist == nil {
+		blocklist, err := readBlocklist("blocklist.json")
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		cfg.Search.Blocklist = blocklist
+	}
+
+	// reddit asks for "<app> by /u/<account>" when no agent is configured
+	cfg.Reddit.UserAgent = envOr("REDDIT_USER_AGENT",
+		fmt.Sprintf("%s by /u/%s", os.Getenv("REDDIT_APP_NAME"), cfg.Reddit.Username))
+
+	switch cfg.Search.Provider {
+	case "google", "brave":
+	default:
+		problems = append(problems, fmt.Sprintf("SEARCH_PROVIDER %q must be google or brave", cfg.Search.Provider))
+	}
+
+	// credentials for the real services only matter outside mock mode
+	if !cfg.MockMode {
+		if cfg.Search.Provider == "brave" {
+			cfg.Search.BraveAPIKey = required("BRAVE_SEARCH_API_KEY")
+		} else {
+			cfg.Search.GoogleAPIKey = required("CUSTOM_SEARCH_API_KEY")
+			cfg.Search.GoogleCSEID = required("CUSTOM_SEARCH_CSE_ID")
+		}
+
+		cfg.Reddit.ClientID = required("REDDIT_CLIENT_ID")
+		cfg.Reddit.ClientSecret = required("REDDIT_CLIENT_SECRET")
+		cfg.Reddit.Username = required("REDDIT_CLIENT_USERNAME")
+		cfg.Reddit.Password = required("REDDIT_CLIENT_PASSWORD")
+
+		if cfg.Stats.Enabled {
+			cfg.Stats.APIURL = required("STATS_API_URL")
+		}
+	}
+
+	// anything shorter gets cut off by the server before it can respond
+	if cfg.WriteTimeout <= cfg.MatchupTimeout {
+		problems = append(problems, fmt.Sprintf("WRITE_TIMEOUT (%s) must be longer than MATCHUP_TIMEOUT (%s)", cfg.WriteTimeout, cfg.MatchupTimeout))
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+
+	return cfg, nil
+}
+
+// language looks up an ISO 639-1 or 639-3 code or a language name, english
+// if it isn't one
+func language(v string) (whatlanggo.Lang, bool) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	for lang, name := range whatlanggo.Langs {
+		if v == lang.Iso6391() || v == lang.Iso6393() || v == strings.ToLower(name) {
+			return lang, true
+		}
+	}
+	return whatlanggo.Eng, false
+}
+
+// readBlocklist reads a json array of subreddit names, nil if the file
+// doesn't exist
+func readBlocklist(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read %s: %s", path, err)
+	}
+
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %s", path, err)
+	}
+	return names, nil
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
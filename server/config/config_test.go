@@ -0,0 +1,29 @@
+package config
+
+import "testing"
+
+func TestLoadClampsSearchResultCount(t *testing.T) {
+	t.Setenv("MOCK_MODE", "true")
+	t.Setenv("REDIS_ENDPOINT", "localhost:6379")
+
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", 4},
+		{"7", 7},
+		{"10", 10},
+		{"25", 10},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("SEARCH_RESULT_COUNT", tt.value)
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("SEARCH_RESULT_COUNT=%q: %v", tt.value, err)
+		}
+		if cfg.Search.ResultCount != tt.want {
+			t.Errorf("SEARCH_RESULT_COUNT=%q gives %d results, want %d", tt.value, cfg.Search.ResultCount, tt.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"server/logging"
+)
+
+const adminTokenHeader = "X-Admin-Token"
+
+// isAdmin reports whether the request carries the configured ADMIN_TOKEN.
+// Admin-only features are disabled entirely when no token is configured.
+func isAdmin(r *http.Request) bool {
+	token := cfg.AdminToken
+	if token == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(adminTokenHeader)), []byte(token)) == 1
+}
+
+// DeleteMatchupHandler purges a single cached matchup, e.g. after a patch
+// makes its advice wrong
+func DeleteMatchupHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		jsonResponse(w, http.StatusForbidden, map[string]string{"error": "deleting a matchup requires a valid admin token"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		jsonResponse(w, http.StatusServiceUnavailable, map[string]string{"error": "Redis client not initialized"})
+		return
+	}
+
+	req, ok := matchupQuery(w, r)
+	if !ok {
+		return
+	}
+
+	keys := []string{req.key, req.key + reversedSuffix}
+	forgetCachedMatchups(keys...)
+
+	deleted, err := rdb.Del(ctx, keys...).Result()
+	if err != nil {
+		jsonResponse(w, http.StatusBadGateway, map[string]string{"error": fmt.Sprintf("Redis error: %s", err)})
+		return
+	}
+
+	if deleted == 0 {
+		jsonResponse(w, http.StatusNotFound, map[string]interface{}{"deleted": false, "error": "Matchup not cached"})
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]bool{"deleted": true})
+}
+
+// far enough out that a pinned matchup is only replaced by a refresh
+const pinTTL = 10 * 365 * 24 * time.Hour
+
+// MatchupTTLHandler shows how long a cached matchup has left, GET
+// /api/matchup/ttl?champ=&opp=&role=, and changes it with PUT and a ttl of
+// seconds or "pin", e.g. to keep advice that's been reviewed by hand. A
+// rewritten reverse entry, if there is one, gets the same TTL.
+func MatchupTTLHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		jsonResponse(w, http.StatusMethodNotAllowed, map[string]string{"error": fmt.Sprintf("Method %s not allowed", r.Method)})
+		return
+	}
+	if !isAdmin(r) {
+		jsonResponse(w, http.StatusForbidden, map[string]string{"error": "matchup ttls require a valid admin token"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		jsonResponse(w, http.StatusServiceUnavailable, map[string]string{"error": "Redis client not initialized"})
+		return
+	}
+
+	req, ok := matchupQuery(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		var ttl time.Duration
+		switch v := r.URL.Query().Get("ttl"); v {
+		case "pin":
+			ttl = pinTTL
+		default:
+			seconds, err := strconv.Atoi(v)
+			if err != nil || seconds <= 0 {
+				jsonResponse(w, http.StatusBadRequest, map[string]string{"error": "ttl must be a positive number of seconds or pin"})
+				return
+			}
+			ttl = time.Duration(seconds) * time.Second
+		}
+
+		set, err := setCachedTTL(ctx, rdb, req.key, ttl)
+		if err != nil {
+			jsonResponse(w, http.StatusBadGateway, map[string]string{"error": fmt.Sprintf("Redis error: %s", err)})
+			return
+		}
+		if !set {
+			jsonResponse(w, http.StatusNotFound, map[string]string{"error": "Matchup not cached"})
+			return
+		}
+		// there's usually no reverse entry, so whether this finds one doesn't matter
+		if _, err := setCachedTTL(ctx, rdb, req.key+reversedSuffix, ttl); err != nil {
+			logging.FromContext(ctx).Warn("couldn't set reversed matchup ttl", "key", req.key+reversedSuffix, "error", err)
+		}
+	}
+
+	ttl, err := rdb.TTL(ctx, req.key).Result()
+	if err != nil {
+		jsonResponse(w, http.StatusBadGateway, map[string]string{"error": fmt.Sprintf("Redis error: %s", err)})
+		return
+	}
+
+	// redis answers -2 for a missing key and -1 for one without an expiry
+	switch {
+	case ttl == -2:
+		jsonResponse(w, http.StatusNotFound, map[string]string{"error": "Matchup not cached"})
+	case ttl < 0:
+		jsonResponse(w, http.StatusOK, map[string]interface{}{"key": req.key, "expires": false})
+	default:
+		jsonResponse(w, http.StatusOK, map[string]interface{}{"key": req.key, "expires": true, "ttl_seconds": int64(ttl.Seconds())})
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"server/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// cacheLuxZed caches advice for luxZed with ttl and returns its key
+func cacheLuxZed(t *testing.T, ttl time.Duration) string {
+	t.Helper()
+	key := newMatchupRequest(context.Background(), luxZed).key
+	matchup := models.CachedMatchup{Advice: "• Lux should respect Zed's level 2 [Sources: [a]]", Sources: []string{"a"}, GeneratedAt: time.Now().Unix()}
+	if ttl > 0 {
+		matchup.ExpiresAt = time.Now().Add(ttl).Unix()
+	}
+
+	value, err := encodeCachedMatchup(matchup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRedis.Set(key, string(value))
+	testRedis.SetTTL(key, ttl)
+	return key
+}
+
+func serveTTL(method string, query string, admin bool) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/api/matchup/ttl?"+query, nil)
+	if admin {
+		r.Header.Set(adminTokenHeader, testAdminToken)
+	}
+	w := httptest.NewRecorder()
+	MatchupTTLHandler(w, r)
+	return w
+}
+
+func TestMatchupTTLHandler(t *testing.T) {
+	const query = "champ=lux&opp=zed&role=mid"
+
+	tests := []struct {
+		name     string
+		method   string
+		query    string
+		admin    bool
+		cached   bool
+		wantCode int
+		// the key's TTL afterwards, 0 to leave it unchecked
+		wantTTL time.Duration
+	}{
+		{name: "no admin token", method: http.MethodGet, query: query, cached: true, wantCode: http.StatusForbidden},
+		{name: "no admin token put", method: http.MethodPut, query: query + "&ttl=60", cached: true, wantCode: http.StatusForbidden, wantTTL: time.Hour},
+		{name: "get", method: http.MethodGet, query: query, admin: true, cached: true, wantCode: http.StatusOK, wantTTL: time.Hour},
+		{name: "put seconds", method: http.MethodPut, query: query + "&ttl=60", admin: true, cached: true, wantCode: http.StatusOK, wantTTL: time.Minute},
+		{name: "pin", method: http.MethodPut, query: query + "&ttl=pin", admin: true, cached: true, wantCode: http.StatusOK, wantTTL: pinTTL},
+		{name: "get missing key", method: http.MethodGet, query: query, admin: true, wantCode: http.StatusNotFound},
+		{name: "put missing key", method: http.MethodPut, query: query + "&ttl=60", admin: true, wantCode: http.StatusNotFound},
+		{name: "ttl not a number", method: http.MethodPut, query: query + "&ttl=soon", admin: true, cached: true, wantCode: http.StatusBadRequest, wantTTL: time.Hour},
+		{name: "ttl zero", method: http.MethodPut, query: query + "&ttl=0", admin: true, cached: true, wantCode: http.StatusBadRequest, wantTTL: time.Hour},
+		{name: "ttl negative", method: http.MethodPut, query: query + "&ttl=-30", admin: true, cached: true, wantCode: http.StatusBadRequest, wantTTL: time.Hour},
+		{name: "ttl missing", method: http.MethodPut, query: query, admin: true, cached: true, wantCode: http.StatusBadRequest, wantTTL: time.Hour},
+		{name: "bad matchup", method: http.MethodGet, query: "champ=lux&opp=notachampion&role=mid", admin: true, wantCode: http.StatusBadRequest},
+		{name: "wrong method", method: http.MethodPost, query: query, admin: true, wantCode: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withTestConfig(t)
+			key := newMatchupRequest(context.Background(), luxZed).key
+			if tt.cached {
+				cacheLuxZed(t, time.Hour)
+			}
+
+			w := serveTTL(tt.method, tt.query, tt.admin)
+			if w.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantTTL != 0 {
+				if ttl := testRedis.TTL(key); ttl != tt.wantTTL {
+					t.Errorf("ttl = %s, want %s", ttl, tt.wantTTL)
+				}
+			}
+
+			if w.Code != http.StatusOK {
+				return
+			}
+			var body struct {
+				Key        string `json:"key"`
+				Expires    bool   `json:"expires"`
+				TTLSeconds int64  `json:"ttl_seconds"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Key != key || !body.Expires || body.TTLSeconds != int64(tt.wantTTL.Seconds()) {
+				t.Errorf("body = %+v, want %s expiring in %s", body, key, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestMatchupTTLHandlerSetsReversedTTL(t *testing.T) {
+	withTestConfig(t)
+	key := cacheLuxZed(t, time.Hour)
+	testRedis.Set(key+reversedSuffix, "{}")
+	testRedis.SetTTL(key+reversedSuffix, time.Hour)
+
+	if w := serveTTL(http.MethodPut, "champ=lux&opp=zed&role=mid&ttl=pin", true); w.Code != http.StatusOK {
+		t.Fatalf("code = %d: %s", w.Code, w.Body)
+	}
+	if ttl := testRedis.TTL(key + reversedSuffix); ttl != pinTTL {
+		t.Errorf("reversed ttl = %s, want it pinned too", ttl)
+	}
+}
+
+func TestMatchupCacheControlFollowsTTL(t *testing.T) {
+	withTestConfig(t)
+	s, searcher, _ := newTestService()
+
+	tests := []struct {
+		name string
+		ttl  string
+		want time.Duration
+	}{
+		{"default", "", 24 * time.Hour},
+		{"custom", "90", 90 * time.Second},
+		{"pinned", "pin", pinTTL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cacheLuxZed(t, 24*time.Hour)
+			if tt.ttl != "" {
+				if w := serveTTL(http.MethodPut, "champ=lux&opp=zed&role=mid&ttl="+tt.ttl, true); w.Code != http.StatusOK {
+					t.Fatalf("setting the ttl: %d %s", w.Code, w.Body)
+				}
+			}
+
+			w := httptest.NewRecorder()
+			s.MatchupHandler(w, httptest.NewRequest(http.MethodGet, "/api/matchup?champ=lux&opp=zed&role=mid", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("code = %d: %s", w.Code, w.Body)
+			}
+			assertMaxAge(t, w.Header().Get("Cache-Control"), tt.want)
+		})
+	}
+
+	if searcher.calls.Load() != 0 {
+		t.Errorf("searched %d times, want every response served from the cache", searcher.calls.Load())
+	}
+}
+
+// assertMaxAge checks cacheControl lets the response be kept for want, or a
+// second less if the clock ticked over since it was cached
+func assertMaxAge(t *testing.T, cacheControl string, want time.Duration) {
+	t.Helper()
+	seconds := int64(want.Seconds())
+	if cacheControl != fmt.Sprintf("public, max-age=%d", seconds) && cacheControl != fmt.Sprintf("public, max-age=%d", seconds-1) {
+		t.Errorf("Cache-Control = %q, want a max-age of %d", cacheControl, seconds)
+	}
+}
+
+func TestMatchupCacheControlWithoutExpiry(t *testing.T) {
+	withTestConfig(t)
+	s, _, _ := newTestService()
+	cacheLuxZed(t, 0)
+
+	w := httptest.NewRecorder()
+	s.MatchupHandler(w, httptest.NewRequest(http.MethodGet, "/api/matchup?champ=lux&opp=zed&role=mid", nil))
+	if got := w.Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Cache-Control = %q, want no-cache for a key with no expiry", got)
+	}
+}
+
+// commandLog is a redis hook that records the name of every command sent
+type commandLog struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (c *commandLog) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	c.mu.Lock()
+	c.names = append(c.names, cmd.Name())
+	c.mu.Unlock()
+	return ctx, nil
+}
+
+func (c *commandLog) AfterProcess(ctx context.Context, cmd redis.Cmder) error { return nil }
+
+// sent is the names of the commands recorded so far
+func (c *commandLog) sent() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.names)
+}
+
+func (c *commandLog) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	for _, cmd := range cmds {
+		c.BeforeProcess(ctx, cmd)
+	}
+	return ctx, nil
+}
+
+func (c *commandLog) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error { return nil }
+
+func TestMatchupCacheControlFromL1(t *testing.T) {
+	c := withTestConfig(t)
+	s, searcher, _ := newTestService(thread("aaa111"))
+
+	client, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.MatchupHandler(w, httptest.NewRequest(http.MethodGet, "/api/matchup?champ=lux&opp=zed&role=mid", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("code = %d: %s", w.Code, w.Body)
+		}
+		return w
+	}
+
+	assertMaxAge(t, serve().Header().Get("Cache-Control"), c.CacheTTL)
+
+	commands := &commandLog{}
+	client.AddHook(commands)
+	assertMaxAge(t, serve().Header().Get("Cache-Control"), c.CacheTTL)
+	if searcher.calls.Load() != 1 {
+		t.Errorf("searched %d times, want the second response served from l1", searcher.calls.Load())
+	}
+	sent := commands.sent()
+	for _, name := range sent {
+		if name == "get" || name == "ttl" {
+			t.Errorf("an l1 hit sent %q to redis, commands: %q", name, sent)
+		}
+	}
+}
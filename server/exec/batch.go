@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"server/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// most matchups a single warm up request can ask for
+const maxBatchSize = 200
+
+// batchResult reports what happened to one matchup in a batch
+type batchResult struct {
+	Champion string `json:"champ"`
+	Opponent string `json:"opp"`
+	Role     string `json:"role"`
+	Status   string `json:"status"` // "cached", "generated" or "error"
+	Error    string `json:"error,omitempty"`
+}
+
+// BatchHandler warms the cache for a JSON array of matchups, generating any
+// that aren't cached yet with BATCH_CONCURRENCY (default 2) workers. Every
+// generation is a full pipeline run, so it's admin only.
+func (s *matchupService) BatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonResponse(w, http.StatusMethodNotAllowed, map[string]string{"error": fmt.Sprintf("Method %s not allowed", r.Method)})
+		return
+	}
+
+	if !isAdmin(r) {
+		jsonResponse(w, http.StatusForbidden, map[string]string{"error": "batch requires a valid admin token"})
+		return
+	}
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		jsonResponse(w, http.StatusServiceUnavailable, map[string]string{"error": "Redis client not initialized"})
+		return
+	}
+
+	var queries []models.Query
+	if err := json.NewDecoder(r.Body).Decode(&queries); err != nil {
+		jsonResponse(w, bodyErrorCode(err), map[string]string{"error": fmt.Sprintf("Invalid JSON body: %s", err)})
+		return
+	}
+	if len(queries) > maxBatchSize {
+		jsonResponse(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("At most %d matchups per batch", maxBatchSize)})
+		return
+	}
+
+	results := make([]batchResult, len(queries))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.BatchConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = s.warmMatchup(r.Context(), rdb, queries[i])
+			}
+		}()
+	}
+
+	for i := range queries {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	jsonResponse(w, http.StatusOK, results)
+}
+
+// warmMatchup makes sure a single matchup is cached
+func (s *matchupService) warmMatchup(ctx context.Context, rdb *redis.Client, q models.Query) batchResult {
+	result := batchResult{Champion: q.Champion, Opponent: q.Opponent, Role: q.Role}
+
+	if err := ctx.Err(); err != nil {
+		result.Status, result.Error = "error", err.Error()
+		return result
+	}
+
+	q, invalid := validateQuery(q)
+	switch invalid := invalid.(type) {
+	case map[string]string:
+		result.Status, result.Error = "error", invalid["error"]
+		return result
+	case map[string]interface{}:
+		result.Status, result.Error = "error", fmt.Sprint(invalid["error"])
+		return result
+	}
+
+	req := newMatchupRequest(ctx, q)
+	req.unlimited = true
+
+	if _, err := getCachedMatchup(ctx, rdb, req.key); err == nil {
+		result.Status = "cached"
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.MatchupTimeout)
+	defer cancel()
+
+	if _, _, err := s.getAdvice(ctx, rdb, req, nil); err != nil {
+		result.Status, result.Error = "error", err.Error()
+		return result
+	}
+
+	result.Status = "generated"
+	return result
+}
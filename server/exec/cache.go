@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/logging"
+	"server/models"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+// gzip's own header, which neither JSON nor the old plain string entries can
+// start with
+const gzipMagic = "\x1f\x8b"
+
+// l1 keeps matchups read from or written to redis in process for
+// L1_CACHE_TTL, so hot ones skip the round trip. nil when L1_CACHE_SIZE is 0.
+var l1 *expirable.LRU[string, models.CachedMatchup]
+
+func newL1Cache() *expirable.LRU[string, models.CachedMatchup] {
+	if cfg.L1CacheSize == 0 {
+		return nil
+	}
+	return expirable.NewLRU[string, models.CachedMatchup](cfg.L1CacheSize, nil, cfg.L1CacheTTL)
+}
+
+// getCachedMatchup reads and decodes a cached matchup, returning redis.Nil if
+// the key doesn't exist. Entries cached before advice was stored as JSON are
+// plain strings and come back as advice with no metadata.
+func getCachedMatchup(ctx context.Context, rdb *redis.Client, key string) (models.CachedMatchup, error) {
+	if l1 != nil {
+		if matchup, ok := l1.Get(key); ok {
+			return matchup, nil
+		}
+	}
+
+	value, err := rdb.Get(ctx, key).Result()
+	if err != nil {
+		return models.CachedMatchup{}, err
+	}
+
+	matchup, err := decodeCachedMatchup(value)
+	if err != nil {
+		return models.CachedMatchup{}, err
+	}
+	if l1 != nil {
+		l1.Add(key, matchup)
+	}
+	return matchup, nil
+}
+
+func decodeCachedMatchup(value string) (models.CachedMatchup, error) {
+	// compressed entries are read whether or not compression is still on
+	if strings.HasPrefix(value, gzipMagic) {
+		var err error
+		value, err = decompress(value)
+		if err != nil {
+			return models.CachedMatchup{}, fmt.Errorf("couldn't decompress cached matchup: %s", err)
+		}
+	}
+
+	var matchup models.CachedMatchup
+	if strings.HasPrefix(value, "{") && json.Unmarshal([]byte(value), &matchup) == nil && matchup.Advice != "" {
+		if matchup.Sources == nil {
+			matchup.Sources = []string{}
+		}
+		return matchup, nil
+	}
+
+	return models.CachedMatchup{Advice: value, Sources: []string{}}, nil
+}
+
+func encodeCachedMatchup(matchup models.CachedMatchup) ([]byte, error) {
+	value, err := json.Marshal(matchup)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.CacheCompression {
+		return compress(value)
+	}
+	return value, nil
+}
+
+// setCachedMatchup caches matchup at key, replacing whatever is there, and
+// returns it as stored, with its expiry. Only a refresh should do that, new
+// advice goes through addCachedMatchup. On error matchup is returned as is.
+func setCachedMatchup(ctx context.Context, rdb *redis.Client, key string, matchup models.CachedMatchup) (models.CachedMatchup, error) {
+	ttl := cacheTTL(matchup)
+	stored := matchup
+	stored.ExpiresAt = time.Now().Add(ttl).Unix()
+
+	value, err := encodeCachedMatchup(stored)
+	if err != nil {
+		return matchup, err
+	}
+
+	if err := rdb.Set(ctx, key, value, ttl).Err(); err != nil {
+		return matchup, err
+	}
+	if l1 != nil {
+		l1.Add(key, stored)
+	}
+	return stored, nil
+}
+
+// addCachedMatchup caches matchup at key unless something already is, with
+// SET NX so that when two instances generate the same matchup the first to
+// finish wins. It returns whichever matchup ended up cached, so every request
+// serves the same advice. On error matchup is returned as is.
+func addCachedMatchup(ctx context.Context, rdb *redis.Client, key string, matchup models.CachedMatchup) (models.CachedMatchup, error) {
+	ttl := cacheTTL(matchup)
+	stored := matchup
+	stored.ExpiresAt = time.Now().Add(ttl).Unix()
+
+	value, err := encodeCachedMatchup(stored)
+	if err != nil {
+		return matchup, err
+	}
+
+	added, err := rdb.SetNX(ctx, key, value, ttl).Result()
+	if err != nil {
+		return matchup, err
+	}
+
+	if !added {
+		// straight from redis, l1 can't know about another instance's write
+		cached, err := rdb.Get(ctx, key).Result()
+		if err == redis.Nil {
+			// expired or deleted in between, nothing to be consistent with
+			return matchup, nil
+		}
+		if err != nil {
+			return matchup, err
+		}
+		if stored, err = decodeCachedMatchup(cached); err != nil {
+			return matchup, err
+		}
+	}
+
+	if l1 != nil {
+		l1.Add(key, stored)
+	}
+	return stored, nil
+}
+
+// setCachedTTL gives the matchup cached at key ttl left to live, keeping the
+// expiry stored with it in step. It reports false if nothing is cached there.
+func setCachedTTL(ctx context.Context, rdb *redis.Client, key string, ttl time.Duration) (bool, error) {
+	value, err := rdb.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	matchup, err := decodeCachedMatchup(value)
+	if err != nil {
+		return false, err
+	}
+	matchup.ExpiresAt = time.Now().Add(ttl).Unix()
+	encoded, err := encodeCachedMatchup(matchup)
+	if err != nil {
+		return false, err
+	}
+
+	// XX so a matchup that expired or was purged in between stays gone
+	set, err := rdb.SetXX(ctx, key, encoded, ttl).Result()
+	if err != nil || !set {
+		return false, err
+	}
+	if l1 != nil {
+		l1.Add(key, matchup)
+	}
+	return true, nil
+}
+
+// isNegative reports whether matchup is the placeholder for a matchup nobody
+// had discussed when it was generated
+func isNegative(matchup models.CachedMatchup) bool {
+	return matchup.Advice == noAdviceMessage
+}
+
+// cacheTTL is how long matchup stays cached. Advice lasts CACHE_TTL, while a
+// negative result is retried after NEGATIVE_CACHE_TTL in case threads have
+// turned up since, jittered so a batch cached together doesn't all expire
+// (and get regenerated) together.
+func cacheTTL(matchup models.CachedMatchup) time.Duration {
+	if !isNegative(matchup) {
+		return cfg.CacheTTL
+	}
+	if cfg.NegativeCacheJitter <= 0 {
+		return cfg.NegativeCacheTTL
+	}
+	return cfg.NegativeCacheTTL + time.Duration(rand.Int63n(int64(cfg.NegativeCacheJitter)))
+}
+
+// cacheGenerated caches freshly generated advice at key, replacing the entry
+// on a refresh and otherwise keeping whichever was cached first. It returns
+// the matchup to respond with.
+func cacheGenerated(ctx context.Context, rdb *redis.Client, key string, matchup models.CachedMatchup, refresh bool) models.CachedMatchup {
+	var err error
+	if refresh {
+		matchup, err = setCachedMatchup(ctx, rdb, key, matchup)
+	} else {
+		matchup, err = addCachedMatchup(ctx, rdb, key, matchup)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to set redis key", "key", key, "error", err)
+	}
+	return matchup
+}
+
+// forgetCachedMatchups drops keys from l1, redis has to be cleared separately
+func forgetCachedMatchups(keys ...string) {
+	if l1 == nil {
+		return
+	}
+	for _, key := range keys {
+		l1.Remove(key)
+	}
+}
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(value string) (string, error) {
+	zr, err := gzip.NewReader(strings.NewReader(value))
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// generatedAt formats when a matchup was generated for responses, empty if
+// it was cached before that was recorded
+func generatedAt(matchup models.CachedMatchup) string {
+	if matchup.GeneratedAt == 0 {
+		return ""
+	}
+	return time.Unix(matchup.GeneratedAt, 0).UTC().Format(time.RFC3339)
+}
+
+// setCacheControl lets browsers and CDNs keep the response for as long as
+// matchup has left in the cache. That's the expiry stored with it rather than
+// CACHE_TTL, since a matchup's TTL can be pinned or changed by hand (see
+// MatchupTTLHandler) and negative results are jittered, and reading it from
+// the matchup keeps l1 hits from needing a redis round trip.
+func setCacheControl(w http.ResponseWriter, matchup models.CachedMatchup) {
+	// no expiry was stored with entries cached before it was recorded
+	remaining := matchup.ExpiresAt - time.Now().Unix()
+	if matchup.ExpiresAt == 0 || remaining < 1 {
+		w.Header().Set("Cache-Control", "no-cache")
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.FormatInt(remaining, 10))
+}
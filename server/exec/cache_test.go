@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"server/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// typicalMatchup is about what a matchup looks like once summarized: five
+// points, each citing a couple of the threads
+func typicalMatchup() models.CachedMatchup {
+	sources := make([]string, 8)
+	for i := range sources {
+		sources[i] = fmt.Sprintf("https://www.reddit.com/r/summonerschool/comments/%06x/lux_vs_zed_mid/", 0xa1b2c3+i*977)
+	}
+
+	texts := []string{
+		"Zed's all in comes at level 6, so shove the wave and sit behind it once he has ult rather than trading in the open",
+		"Hold E for his W shadow instead of throwing it at him first, he has to commit to reach you and that's when it lands",
+		"Seeker's Armguard into Zhonya's lets you time out Death Mark, buy the armguard on your first back if he's ahead",
+		"Ask your jungler to play around your side after his first back, he roams hard and you can follow with ult",
+		"Don't stand in the line between him and his shadow, Q through minions to poke when he walks up for a last hit",
+	}
+
+	matchup := models.CachedMatchup{
+		Sources:     sources,
+		GeneratedAt: time.Date(2024, 5, 29, 0, 0, 0, 0, time.UTC).Unix(),
+		Patch:       "14.11",
+		Models:      []string{"anthropic.claude-3-haiku-20240307-v1:0"},
+	}
+	var advice strings.Builder
+	for i, text := range texts {
+		point := models.AdvicePoint{Text: text, Sources: []string{sources[i], sources[(i+3)%len(sources)]}}
+		matchup.Points = append(matchup.Points, point)
+		fmt.Fprintf(&advice, "• %s [Sources: %s]\n", point.Text, strings.Join(point.Sources, ", "))
+	}
+	matchup.Advice = advice.String()
+	return matchup
+}
+
+func TestCachedMatchupRoundTrip(t *testing.T) {
+	matchup := typicalMatchup()
+
+	for _, compression := range []string{"false", "true"} {
+		t.Run("compression "+compression, func(t *testing.T) {
+			withTestConfig(t, "CACHE_COMPRESSION", compression)
+
+			value, err := encodeCachedMatchup(matchup)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if compressed := strings.HasPrefix(string(value), gzipMagic); compressed != cfg.CacheCompression {
+				t.Errorf("compressed = %v with CACHE_COMPRESSION=%s", compressed, compression)
+			}
+
+			got, err := decodeCachedMatchup(string(value))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Advice != matchup.Advice || len(got.Points) != len(matchup.Points) || len(got.Sources) != len(matchup.Sources) {
+				t.Errorf("decoded %+v, want %+v", got, matchup)
+			}
+		})
+	}
+}
+
+func TestDecodeCachedMatchupPlainString(t *testing.T) {
+	got, err := decodeCachedMatchup("• Lux should respect Zed's level 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Advice != "• Lux should respect Zed's level 2" || got.Sources == nil {
+		t.Errorf("decoded %+v, want the string as advice with no sources", got)
+	}
+}
+
+// BenchmarkEncodeCachedMatchup reports what a typical matchup costs to store
+// in redis with and without CACHE_COMPRESSION, as stored-bytes/op
+func BenchmarkEncodeCachedMatchup(b *testing.B) {
+	matchup := typicalMatchup()
+
+	for _, compression := range []string{"false", "true"} {
+		b.Run("compression="+compression, func(b *testing.B) {
+			withTestConfig(b, "CACHE_COMPRESSION", compression)
+			b.ReportAllocs()
+
+			var size int
+			for range b.N {
+				value, err := encodeCachedMatchup(matchup)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(value)
+			}
+			b.ReportMetric(float64(size), "stored-bytes/op")
+		})
+	}
+}
+
+func TestGetCachedMatchupL1(t *testing.T) {
+	withTestConfig(t)
+	client, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	matchup := typicalMatchup()
+	if _, err := setCachedMatchup(ctx, client, "l1-key", matchup); err != nil {
+		t.Fatal(err)
+	}
+
+	// served from l1 even once redis has lost it
+	testRedis.Del("l1-key")
+	if got, err := getCachedMatchup(ctx, client, "l1-key"); err != nil || got.Advice != matchup.Advice {
+		t.Fatalf("getCachedMatchup = %+v, %v, want it from l1", got, err)
+	}
+
+	forgetCachedMatchups("l1-key")
+	if _, err := getCachedMatchup(ctx, client, "l1-key"); err != redis.Nil {
+		t.Errorf("err = %v after forgetting the key, want redis.Nil", err)
+	}
+}
+
+// BenchmarkGetCachedMatchup compares a cache hit served from l1 with one that
+// has to go to redis, which here is miniredis over loopback, so the real
+// difference is bigger
+func BenchmarkGetCachedMatchup(b *testing.B) {
+	for _, size := range []string{"0", "1000"} {
+		b.Run("L1_CACHE_SIZE="+size, func(b *testing.B) {
+			withTestConfig(b, "L1_CACHE_SIZE", size, "CACHE_COMPRESSION", "true")
+			client, err := ensureRedis()
+			if err != nil {
+				b.Fatal(err)
+			}
+			ctx := context.Background()
+			if _, err := setCachedMatchup(ctx, client, "bench-key", typicalMatchup()); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for range b.N {
+				if _, err := getCachedMatchup(ctx, client, "bench-key"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestAddCachedMatchupFirstWriterWins(t *testing.T) {
+	withTestConfig(t)
+	client, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// two instances generating the same matchup at once, each with its own
+	// wording
+	writers := make([]models.CachedMatchup, 2)
+	for i := range writers {
+		writers[i] = typicalMatchup()
+		writers[i].Advice = fmt.Sprintf("• writer %d's advice [Sources: [a]]", i)
+	}
+
+	got := make([]models.CachedMatchup, len(writers))
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, matchup := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			var err error
+			if got[i], err = addCachedMatchup(ctx, client, "nx-key", matchup); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if got[0].Advice != got[1].Advice {
+		t.Fatalf("writers returned %q and %q, want the same advice", got[0].Advice, got[1].Advice)
+	}
+	value, err := testRedis.Get("nx-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := decodeCachedMatchup(value)
+	if err != nil || !reflect.DeepEqual(stored, got[0]) {
+		t.Errorf("cached %q, want the returned %q", stored.Advice, got[0].Advice)
+	}
+
+	// a later writer leaves it alone too, and so does l1
+	late := typicalMatchup()
+	late.Advice = "• late advice [Sources: [a]]"
+	if served, err := addCachedMatchup(ctx, client, "nx-key", late); err != nil || served.Advice != stored.Advice {
+		t.Errorf("late writer served %q, %v, want the cached %q", served.Advice, err, stored.Advice)
+	}
+	if cached, err := getCachedMatchup(ctx, client, "nx-key"); err != nil || cached.Advice != stored.Advice {
+		t.Errorf("getCachedMatchup = %q, %v, want the first writer's %q", cached.Advice, err, stored.Advice)
+	}
+}
+
+func TestCacheGeneratedRefreshReplaces(t *testing.T) {
+	withTestConfig(t)
+	client, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	first := typicalMatchup()
+	cacheGenerated(ctx, client, "refresh-key", first, false)
+
+	refreshed := typicalMatchup()
+	refreshed.Advice = "• refreshed advice [Sources: [a]]"
+	if got := cacheGenerated(ctx, client, "refresh-key", refreshed, true); got.Advice != refreshed.Advice {
+		t.Errorf("refresh served %q, want the new advice", got.Advice)
+	}
+	if cached, err := getCachedMatchup(ctx, client, "refresh-key"); err != nil || cached.Advice != refreshed.Advice {
+		t.Errorf("cached %q, %v, want the refresh to replace it", cached.Advice, err)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	c := withTestConfig(t, "CACHE_TTL", "86400", "NEGATIVE_CACHE_TTL", "3600", "NEGATIVE_CACHE_JITTER", "600")
+	negative := models.CachedMatchup{Advice: noAdviceMessage}
+
+	if ttl := cacheTTL(typicalMatchup()); ttl != c.CacheTTL {
+		t.Errorf("advice cached for %s, want CACHE_TTL %s", ttl, c.CacheTTL)
+	}
+
+	seen := make(map[time.Duration]bool)
+	for range 50 {
+		ttl := cacheTTL(negative)
+		if ttl < c.NegativeCacheTTL || ttl >= c.NegativeCacheTTL+c.NegativeCacheJitter {
+			t.Fatalf("negative result cached for %s, want within %s of %s", ttl, c.NegativeCacheJitter, c.NegativeCacheTTL)
+		}
+		seen[ttl] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("every negative result cached for the same %v, want them jittered", seen)
+	}
+
+	c.NegativeCacheJitter = 0
+	if ttl := cacheTTL(negative); ttl != c.NegativeCacheTTL {
+		t.Errorf("negative result cached for %s without jitter, want %s", ttl, c.NegativeCacheTTL)
+	}
+}
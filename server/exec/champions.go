@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"server/models"
+)
+
+// ChampionsHandler suggests champion names for a partially typed query, e.g.
+// GET /api/champions?q=kai, so the client can catch bad names before they
+// reach the pipeline
+func ChampionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonResponse(w, http.StatusMethodNotAllowed, map[string]string{"error": fmt.Sprintf("Method %s not allowed", r.Method)})
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"champions": models.SuggestChampions(r.URL.Query().Get("q"), cfg.ChampionSuggestionLimit),
+	})
+}
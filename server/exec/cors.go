@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const defaultAllowedOrigin = "https://leagueofmatchups.ai"
+
+// allowedOrigins is the configured CORS_ALLOWED_ORIGINS as a set
+var allowedOrigins map[string]bool
+
+// newAllowedOrigins builds the set from the config, falling back to the
+// production site
+func newAllowedOrigins() map[string]bool {
+	origins := []string{defaultAllowedOrigin}
+	if len(cfg.AllowedOrigins) > 0 {
+		origins = cfg.AllowedOrigins
+	}
+
+	allowed := make(map[string]bool)
+	for _, origin := range origins {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			allowed[origin] = true
+		}
+	}
+	return allowed
+}
+
+// setCORSHeaders allows the request's origin if it's in the allowed list.
+// Other origins get no Access-Control-Allow-Origin, so browsers block them.
+func setCORSHeaders(w http.ResponseWriter, r *http.Request) {
+	// the response depends on the origin, caches mustn't share it across origins
+	w.Header().Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	if !allowedOrigins[origin] {
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization, "+adminTokenHeader)
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Max-Age", "86400") // 24 hours
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	withTestConfig(t, "CORS_ALLOWED_ORIGINS", "https://leagueofmatchups.ai, http://localhost:3000/")
+
+	served := 0
+	h := handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	tests := []struct {
+		name       string
+		method     string
+		origin     string
+		wantOrigin string
+		wantCode   int
+		wantServed bool
+	}{
+		{"production origin", http.MethodGet, "https://leagueofmatchups.ai", "https://leagueofmatchups.ai", http.StatusTeapot, true},
+		{"local dev, trailing slash configured", http.MethodGet, "http://localhost:3000", "http://localhost:3000", http.StatusTeapot, true},
+		{"disallowed origin", http.MethodGet, "https://evil.example.com", "", http.StatusTeapot, true},
+		{"no origin", http.MethodGet, "", "", http.StatusTeapot, true},
+		{"preflight", http.MethodOptions, "http://localhost:3000", "http://localhost:3000", http.StatusOK, false},
+		{"disallowed preflight", http.MethodOptions, "https://evil.example.com", "", http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served = 0
+			r := httptest.NewRequest(tt.method, "/api/matchup", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Vary"); got != "Origin" {
+				t.Errorf("Vary = %q, want Origin", got)
+			}
+			if (served > 0) != tt.wantServed {
+				t.Errorf("served = %v, want %v", served > 0, tt.wantServed)
+			}
+			if tt.wantOrigin != "" && tt.method == http.MethodOptions && w.Header().Get("Access-Control-Allow-Headers") == "" {
+				t.Error("preflight didn't list the allowed headers")
+			}
+		})
+	}
+}
+
+func TestCORSDefaultsToProduction(t *testing.T) {
+	withTestConfig(t, "CORS_ALLOWED_ORIGINS", "")
+
+	for origin, allowed := range map[string]bool{defaultAllowedOrigin: true, "http://localhost:3000": false} {
+		r := httptest.NewRequest(http.MethodGet, "/api/matchup", nil)
+		r.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		setCORSHeaders(w, r)
+
+		if got := w.Header().Get("Access-Control-Allow-Origin") == origin; got != allowed {
+			t.Errorf("%s allowed = %v, want %v", origin, got, allowed)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"server/models"
+	"server/scrape"
+	"server/summarize"
+)
+
+// DebugScrapeHandler scrapes a single thread, e.g.
+// GET /api/debug/scrape?url=https://www.reddit.com/r/summonerschool/comments/abc123/,
+// and returns the parsed post along with the text that would be sent to
+// bedrock for it. Admin only, since every call hits reddit.
+func (s *matchupService) DebugScrapeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonResponse(w, http.StatusMethodNotAllowed, map[string]string{"error": fmt.Sprintf("Method %s not allowed", r.Method)})
+		return
+	}
+
+	if !isAdmin(r) {
+		jsonResponse(w, http.StatusForbidden, map[string]string{"error": "debugging requires a valid admin token"})
+		return
+	}
+
+	link := r.URL.Query().Get("url")
+	if _, _, err := scrape.ParsePostURL(link); err != nil {
+		jsonResponse(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid reddit url: %s", err)})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.SourceTimeout)
+	defer cancel()
+
+	data, err := s.deps.scraper.Scrape(ctx, models.SearchItem{Link: link})
+	if err != nil {
+		jsonResponse(w, errorStatus(err), map[string]string{"error": fmt.Sprintf("Scraping failed: %s", err)})
+		return
+	}
+
+	formatted, err := summarize.FormatPost(data)
+	if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Formatting failed: %s", err)})
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"post":      json.RawMessage(data),
+		"formatted": formatted,
+	})
+}
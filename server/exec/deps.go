@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+
+	"server/models"
+	"server/scrape"
+	"server/search"
+	"server/source"
+	"server/summarize"
+)
+
+// Searcher, Scraper and Summarizer are the pipeline's stages, behind
+// interfaces so they can be swapped out (or faked) without touching the
+// handlers
+type Searcher interface {
+	Search(ctx context.Context, q models.Query) (models.SearchResponse, error)
+}
+
+type Scraper interface {
+	Scrape(ctx context.Context, item models.SearchItem) ([]byte, error)
+	ScrapeBatch(ctx context.Context, items []models.SearchItem) ([]scrape.Post, error)
+}
+
+type Summarizer interface {
+	Summarize(ctx context.Context, data []byte, championA, championB, role, patch string) (summarize.Result, error)
+	SummarizeBatch(ctx context.Context, posts [][]byte, championA, championB, role, patch string) (summarize.Result, error)
+	SummarizeSnippets(ctx context.Context, items []models.SearchItem, championA, championB, role, patch string) (summarize.Result, error)
+	RewritePerspective(ctx context.Context, advice, champion, opponent, lang string) (summarize.Result, error)
+}
+
+type adviceDeps struct {
+	searcher   Searcher
+	scraper    Scraper
+	summarizer Summarizer
+	// optional win rate source, nil when stats are turned off
+	stats source.Fetcher
+	// summarize every source in one bedrock call instead of one per source
+	batch bool
+}
+
+// the stage packages' own functions
+type (
+	searchStage    struct{}
+	scrapeStage    struct{}
+	summarizeStage struct{}
+)
+
+func (searchStage) Search(ctx context.Context, q models.Query) (models.SearchResponse, error) {
+	return search.Search(ctx, q)
+}
+
+func (scrapeStage) Scrape(ctx context.Context, item models.SearchItem) ([]byte, error) {
+	return scrape.Scrape(ctx, item)
+}
+
+func (scrapeStage) ScrapeBatch(ctx context.Context, items []models.SearchItem) ([]scrape.Post, error) {
+	return scrape.ScrapeBatch(ctx, items)
+}
+
+func (summarizeStage) Summarize(ctx context.Context, data []byte, championA, championB, role, patch string) (summarize.Result, error) {
+	return summarize.Summarize(ctx, data, championA, championB, role, patch)
+}
+
+func (summarizeStage) SummarizeBatch(ctx context.Context, posts [][]byte, championA, championB, role, patch string) (summarize.Result, error) {
+	return summarize.SummarizeBatch(ctx, posts, championA, championB, role, patch)
+}
+
+func (summarizeStage) SummarizeSnippets(ctx context.Context, items []models.SearchItem, championA, championB, role, patch string) (summarize.Result, error) {
+	return summarize.SummarizeSnippets(ctx, items, championA, championB, role, patch)
+}
+
+func (summarizeStage) RewritePerspective(ctx context.Context, advice, champion, opponent, lang string) (summarize.Result, error) {
+	return summarize.RewritePerspective(ctx, advice, champion, opponent, lang)
+}
+
+// matchupService serves the endpoints that compute advice, using whichever
+// stages it was built with
+type matchupService struct {
+	deps adviceDeps
+}
+
+// defaultDeps wires up the real stages, in batch mode when
+// SUMMARIZE_MODE=batch
+func defaultDeps() adviceDeps {
+	return adviceDeps{
+		searcher:   searchStage{},
+		scraper:    scrapeStage{},
+		summarizer: summarizeStage{},
+		stats:      source.New(cfg.Stats),
+		batch:      cfg.SummarizeBatch,
+	}
+}
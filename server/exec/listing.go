@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/logging"
+	"server/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// keys asked of each SCAN call, and the fewest matchups a page is filled to
+// before it's returned
+const (
+	listingScanCount = 200
+	listingPageSize  = 50
+)
+
+// cachedEntry is one cached matchup for a champion
+type cachedEntry struct {
+	Opponent string `json:"opp"`
+	Role     string `json:"role"`
+	// whose side the advice is written from, see canonicalKey
+	Perspective string `json:"perspective"`
+	Patch       string `json:"patch,omitempty"`
+	Lang        string `json:"lang,omitempty"`
+	Mode        string `json:"mode"` // "full" or "quick"
+	GeneratedAt string `json:"generated_at,omitempty"`
+}
+
+// parseCacheKey reverses canonicalKey and the suffixes matchupQuery adds,
+// "<champ>v<opp>@<role>[#patch][:lang][~quick]", for a key known to include
+// champion on one side. Rewritten reverse entries and anything else that
+// doesn't fit report false.
+func parseCacheKey(key string, champion string) (cachedEntry, bool) {
+	if strings.HasSuffix(key, reversedSuffix) {
+		return cachedEntry{}, false
+	}
+
+	pair, rest, ok := strings.Cut(key, "@")
+	if !ok {
+		return cachedEntry{}, false
+	}
+
+	entry := cachedEntry{Mode: "full"}
+	if trimmed, ok := strings.CutSuffix(rest, "~quick"); ok {
+		rest, entry.Mode = trimmed, "quick"
+	}
+	rest, entry.Lang, _ = strings.Cut(rest, ":")
+	entry.Role, entry.Patch, _ = strings.Cut(rest, "#")
+
+	if opp, ok := strings.CutPrefix(pair, champion+"v"); ok {
+		entry.Opponent, entry.Perspective = opp, champion
+	} else if opp, ok := strings.CutSuffix(pair, "v"+champion); ok {
+		entry.Opponent, entry.Perspective = opp, opp
+	}
+
+	// the glob can match a longer name that happens to end or start the same
+	if canonical, ok := models.NormalizeChampion(entry.Opponent); !ok || canonical != entry.Opponent {
+		return cachedEntry{}, false
+	}
+	if _, ok := models.NormalizeRole(entry.Role); !ok {
+		return cachedEntry{}, false
+	}
+
+	return entry, true
+}
+
+// ChampionMatchupsHandler lists the matchups with cached advice for a
+// champion, GET /api/champion/{name}/matchups?cursor=. It SCANs rather than
+// using KEYS so a large cache doesn't block redis, a page at a time: the
+// response's cursor is passed back to continue, and is empty once every key
+// has been seen. Pages can be short (or empty) before the end.
+func ChampionMatchupsHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	champion, ok := models.NormalizeChampion(name)
+	if !ok {
+		jsonResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error":       fmt.Sprintf("Unknown champion: %s", name),
+			"suggestions": models.ClosestChampions(name, 3),
+		})
+		return
+	}
+
+	// the champion can be either side of a canonical key, so the cursor is
+	// which side is being scanned followed by redis's cursor for it
+	side, cursor := 0, uint64(0)
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		s, c, _ := strings.Cut(v, ":")
+		var err1, err2 error
+		side, err1 = strconv.Atoi(s)
+		cursor, err2 = strconv.ParseUint(c, 10, 64)
+		if err1 != nil || err2 != nil || side < 0 || side > 1 {
+			jsonResponse(w, http.StatusBadRequest, map[string]string{"error": "Invalid cursor"})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		jsonResponse(w, http.StatusServiceUnavailable, map[string]string{"error": "Redis client not initialized"})
+		return
+	}
+
+	// champion names never contain glob characters
+	patterns := []string{champion + "v*@*", "*v" + champion + "@*"}
+
+	var keys []string
+	var entries []cachedEntry
+	for len(entries) < listingPageSize {
+		var page []string
+		page, cursor, err = rdb.Scan(ctx, cursor, patterns[side], listingScanCount).Result()
+		if err != nil {
+			jsonResponse(w, http.StatusBadGateway, map[string]string{"error": fmt.Sprintf("Redis error: %s", err)})
+			return
+		}
+
+		for _, key := range page {
+			if entry, ok := parseCacheKey(key, champion); ok {
+				keys = append(keys, key)
+				entries = append(entries, entry)
+			}
+		}
+
+		if cursor == 0 {
+			if side++; side == len(patterns) {
+				break
+			}
+		}
+	}
+
+	next := ""
+	if side < len(patterns) {
+		next = fmt.Sprintf("%d:%d", side, cursor)
+	}
+
+	if err := fillGeneratedAt(ctx, rdb, keys, entries); err != nil {
+		logging.FromContext(ctx).Warn("couldn't read cached matchups", "error", err)
+	}
+
+	if entries == nil {
+		entries = []cachedEntry{}
+	}
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"champion": champion,
+		"matchups": entries,
+		"cursor":   next,
+	})
+}
+
+// fillGeneratedAt reads the matchups at keys in one MGET and sets when each
+// was generated. Keys that expired since the scan are left without a time.
+func fillGeneratedAt(ctx context.Context, rdb *redis.Client, keys []string, entries []cachedEntry) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	values, err := rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return err
+	}
+
+	for i, value := range values {
+		value, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if matchup, err := decodeCachedMatchup(value); err == nil {
+			entries[i].GeneratedAt = generatedAt(matchup)
+		}
+	}
+	return nil
+}
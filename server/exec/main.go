@@ -4,54 +4,103 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"server/config"
+	"server/logging"
 	"server/models"
+	"server/patch"
 	"server/scrape"
 	"server/search"
 	"server/summarize"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var rdb *redis.Client
+// largest request body accepted, a full batch of matchups fits comfortably
+const maxBodyBytes = 64 << 10
 
-func initRedis() error {
-	redisEndpt := os.Getenv("REDIS_ENDPOINT")
-	if redisEndpt == "" {
-		return fmt.Errorf("REDIS_ENDPOINT environment variable is not set")
-	}
+// loaded once in setup and read by every handler
+var cfg *config.Config
 
-	rdb = redis.NewClient(&redis.Options{
-		Addr: redisEndpt,
-	})
+// number of requests currently being served, reported on shutdown
+var activeRequests int64
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// setup reads the config, configures the stages with it and connects to
+// redis. main runs it before serving, tests configure the package themselves.
+func setup() {
+	// the only place .env is read, config.Load picks up everything it sets.
+	// It may set DEBUG, so load it before configuring the logger.
+	err := godotenv.Load(".env")
+	logging.Init()
+	if err != nil {
+		// deployments set real env vars, a missing file is expected there
+		slog.Debug("no .env file loaded", "error", err)
+	}
 
-	_, err := rdb.Ping(ctx).Result()
+	// better to refuse to start than to fail every request later
+	loaded, err := config.Load()
 	if err != nil {
-		return fmt.Errorf("failed to connect to Redis: %v", err)
+		slog.Error("refusing to start", "error", err)
+		os.Exit(1)
 	}
+	configure(loaded)
 
-	return nil
+	if _, err := ensureRedis(); err != nil {
+		slog.Error("couldn't connect to redis, retrying in the background", "error", err)
+		go reconnectRedis()
+	}
 }
 
-func init() {
-	if err := godotenv.Load(".env"); err != nil {
-		log.Println("Error loading .env file:", err)
+// configure makes c the config every handler reads, hands its sections to the
+// stages and rebuilds the rate limiter, l1 cache and allowed origins it sets
+func configure(c *config.Config) {
+	cfg = c
+	search.Configure(cfg.Search)
+	scrape.Configure(cfg.Reddit)
+	summarize.Configure(cfg.Bedrock)
+	patch.Configure(cfg.MockMode)
+	models.SetSubredditWeights(cfg.SubredditWeights)
+
+	if limiter != nil {
+		limiter.stop()
 	}
+	limiter = newIPLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst)
+	l1 = newL1Cache()
+	allowedOrigins = newAllowedOrigins()
+}
 
-	if err := initRedis(); err != nil {
-		log.Println("Error initializing Redis:", err)
-	}
+// handler wraps mux with what every request gets: CORS headers (answering
+// preflights itself), a request id, the body size limit and the active
+// request count
+func handler(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setCORSHeaders(w, r)
+
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// tag every log line for this request so failures can be traced back to it
+		requestID := logging.NewRequestID()
+		w.Header().Set("X-Request-ID", requestID)
+		r = r.WithContext(logging.With(r.Context(), "request_id", requestID))
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+
+		atomic.AddInt64(&activeRequests, 1)
+		defer atomic.AddInt64(&activeRequests, -1)
+
+		mux.ServeHTTP(w, r)
+	})
 }
 
 func jsonResponse(w http.ResponseWriter, code int, payload interface{}) {
@@ -61,143 +110,70 @@ func jsonResponse(w http.ResponseWriter, code int, payload interface{}) {
 	w.Write(response)
 }
 
-func MatchupHandler(w http.ResponseWriter, r *http.Request) {
-	// 3 minute timeout context
-	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Minute)
+// HealthHandler is a readiness probe reporting whether redis and bedrock are reachable
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
 	defer cancel()
 
-	if rdb == nil {
-		jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Redis client not initialized"})
-		return
-	}
-
-	q := models.Query{
-		Champion: r.URL.Query().Get("champ"),
-		Opponent: r.URL.Query().Get("opp"),
-		Role:     r.URL.Query().Get("role"),
-	}
+	status := map[string]string{"status": "ok", "redis": "ok", "bedrock": "ok"}
+	code := http.StatusOK
 
-	// Validate input
-	if q.Champion == "" || q.Opponent == "" || q.Role == "" {
-		jsonResponse(w, http.StatusBadRequest, map[string]string{"error": "Missing required parameters"})
-		return
+	if rdb, err := ensureRedis(); err != nil {
+		status["redis"] = fmt.Sprintf("Redis client not initialized: %s", err)
+		code = http.StatusServiceUnavailable
+	} else if err := rdb.Ping(ctx).Err(); err != nil {
+		status["redis"] = fmt.Sprintf("ping failed: %s", err)
+		code = http.StatusServiceUnavailable
 	}
 
-	key := q.Champion + "v" + q.Opponent + "@" + q.Role
-	advice, err := rdb.Get(ctx, key).Result()
-	if err == nil {
-		// If key exists in cache, return it immediately
-		jsonResponse(w, http.StatusOK, map[string]string{"advice": advice})
-		return
-	} else if err != redis.Nil {
-		// If there's an error other than key not existing, return error
-		jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Redis error: %s", err)})
-		return
+	if err := summarize.CheckConfig(ctx); err != nil {
+		status["bedrock"] = err.Error()
+		code = http.StatusServiceUnavailable
 	}
 
-	// If we're here, the key wasn't in the cache, so we need to generate advice
-
-	searchResults, err := search.Search(q)
-	if err != nil {
-		jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Search failed: %s", err)})
-		return
+	if code != http.StatusOK {
+		status["status"] = "unavailable"
 	}
 
-	if len(searchResults.Items) == 0 {
-		advice := "We aren't confident about the availability of advice on Reddit for this matchup :("
-		if err := rdb.Set(ctx, key, advice, 2592000*time.Second).Err(); err != nil {
-			log.Printf("Failed to set Redis key: %v", err)
-		}
-		jsonResponse(w, http.StatusOK, map[string]string{"advice": advice})
-		return
-	}
-
-	resultChan := make(chan string)
-	errorChan := make(chan error)
-
-	for _, item := range searchResults.Items {
-		go func(item models.SearchItem) {
-			scrapedContent, err := scrape.Scrape(item)
-			if err != nil {
-				errorChan <- fmt.Errorf("scraping error for %s: %v", item.Link, err)
-				return
-			}
-
-			summary, err := summarize.Summarize(scrapedContent, q.Champion, q.Opponent, q.Role)
-			if err != nil {
-				errorChan <- fmt.Errorf("summarization error for %s: %v", item.Link, err)
-				return
-			}
-
-			if strings.Contains(summary, "INVALID_INPUT") {
-				errorChan <- fmt.Errorf("invalid input for %s", item.Link)
-				return
-			}
-
-			resultChan <- summary
-		}(item)
-	}
-
-	var finalAdvice strings.Builder
-	errorCount := 0
-
-	for i := 0; i < len(searchResults.Items); i++ {
-		select {
-		case summary := <-resultChan:
-			finalAdvice.WriteString(summary)
-			finalAdvice.WriteString("\n\n")
-		case err := <-errorChan:
-			log.Printf("Error: %v", err)
-			errorCount++
-		case <-ctx.Done():
-			jsonResponse(w, http.StatusRequestTimeout, map[string]string{"error": "Processing took too long and was terminated"})
-			return
-		}
-	}
-
-	if finalAdvice.Len() == 0 || errorCount == len(searchResults.Items) {
-		advice := "We aren't confident about the availability of advice on Reddit for this matchup :("
-		if err := rdb.Set(ctx, key, advice, 2592000*time.Second).Err(); err != nil {
-			log.Printf("Failed to set Redis key: %v", err)
-		}
-		jsonResponse(w, http.StatusOK, map[string]string{"advice": advice})
-		return
-	}
-
-	advice = finalAdvice.String()
-	if err := rdb.Set(ctx, key, advice, 2592000*time.Second).Err(); err != nil {
-		log.Printf("Failed to set Redis key: %v", err)
-	}
-	jsonResponse(w, http.StatusOK, map[string]string{"advice": advice})
+	jsonResponse(w, code, status)
 }
 
 func main() {
-	http.HandleFunc("/api/matchup", MatchupHandler)
+	setup()
+
+	matchups := &matchupService{deps: defaultDeps()}
+
+	http.HandleFunc("/api/matchup", matchups.MatchupHandler)
+	http.HandleFunc("/api/matchup/stream", matchups.StreamHandler)
+	http.HandleFunc("/api/matchup/v2", matchups.MatchupV2Handler)
+	http.HandleFunc("/api/matchup/batch", matchups.BatchHandler)
+	http.HandleFunc("/api/matchup/popular", PopularHandler)
+	http.HandleFunc("/api/matchup/ttl", MatchupTTLHandler)
+	http.HandleFunc("/api/debug/scrape", matchups.DebugScrapeHandler)
+	http.HandleFunc("/api/champions", ChampionsHandler)
+	http.HandleFunc("GET /api/champion/{name}/matchups", ChampionMatchupsHandler)
+	http.HandleFunc("/healthz", HealthHandler)
+	http.HandleFunc("GET /version", VersionHandler)
+	http.Handle("/metrics", promhttp.Handler())
 
 	srv := &http.Server{
 		Addr: ":8080",
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// CORS headers
-			w.Header().Set("Access-Control-Allow-Origin", "https://leagueofmatchups.ai")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization")
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
-			w.Header().Set("Access-Control-Max-Age", "86400") // 24 hours
-
-			if r.Method == "OPTIONS" {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-
-			http.DefaultServeMux.ServeHTTP(w, r)
-		}),
+		// requests are small, a client taking longer than this to send one is
+		// holding the connection open for nothing
+		ReadTimeout: cfg.ReadTimeout,
+		// has to outlast the compute budget (MATCHUP_TIMEOUT) or slow
+		// matchups (and streams) get cut off mid response
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+		Handler:      handler(http.DefaultServeMux),
 	}
 
 	// init  server in a goroutine so that
 	// it won't block the graceful shutdown handling below
 	go func() {
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start server: %v\n", err)
+			slog.Error("failed to start server", "error", err)
+			os.Exit(1)
 		}
 	}()
 
@@ -205,5 +181,19 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	log.Println("Shutting down server...")
+	slog.Info("shutting down server", "active_requests", atomic.LoadInt64(&activeRequests))
+
+	// give in-flight matchups a chance to finish before exiting
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("server forced to shut down", "active_requests", atomic.LoadInt64(&activeRequests), "error", err)
+	}
+
+	if err := closeRedis(); err != nil {
+		slog.Warn("failed to close redis client", "error", err)
+	}
+
+	slog.Info("server exited")
 }
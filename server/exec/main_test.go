@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"server/config"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// testRedis stands in for redis across the package's tests
+var testRedis *miniredis.Miniredis
+
+func TestMain(m *testing.M) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "couldn't start miniredis:", err)
+		os.Exit(1)
+	}
+	testRedis = mr
+
+	code := m.Run()
+	closeRedis()
+	mr.Close()
+	os.Exit(code)
+}
+
+// testAdminToken is the ADMIN_TOKEN tests are configured with
+const testAdminToken = "secret"
+
+// withTestConfig configures the package like setup would, in mock mode and
+// against an empty testRedis, with env (pairs of names and values) set on
+// top. The config comes back so tests can tweak it further.
+func withTestConfig(t testing.TB, env ...string) *config.Config {
+	t.Helper()
+
+	t.Setenv("MOCK_MODE", "true")
+	t.Setenv("REDIS_ENDPOINT", testRedis.Addr())
+	t.Setenv("ADMIN_TOKEN", testAdminToken)
+	for i := 0; i+1 < len(env); i += 2 {
+		t.Setenv(env[i], env[i+1])
+	}
+
+	c, err := config.Load()
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	old := cfg
+	configure(c)
+	testRedis.FlushAll()
+	if err := closeRedis(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		closeRedis()
+		if old != nil {
+			configure(old)
+		}
+	})
+
+	return c
+}
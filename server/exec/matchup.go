@@ -0,0 +1,947 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"mime"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"server/logging"
+	"server/metrics"
+	"server/models"
+	"server/patch"
+	"server/scrape"
+	"server/search"
+	"server/source"
+	"server/summarize"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+)
+
+const noAdviceMessage = "We aren't confident about the availability of advice on Reddit for this matchup :("
+
+// appended to advice cut short by MAX_ADVICE_CHARS
+const truncatedNote = "(Some advice was left out for length.)"
+
+// matchups with fewer search results than this can be summarized
+// exploratorily, see matchupRequest.exploratory
+const thinSourceCount = 3
+
+// canonicalKey builds an order-independent cache key for a matchup so that
+// A vs B and B vs A share the same search/scrape/summarize work. reversed
+// reports whether the champions had to be swapped to reach canonical order.
+func canonicalKey(q models.Query) (key string, reversed bool) {
+	champ, opp := q.Champion, q.Opponent
+	if strings.ToLower(champ) > strings.ToLower(opp) {
+		champ, opp = opp, champ
+		reversed = true
+	}
+
+	return champ + "v" + opp + "@" + q.Role, reversed
+}
+
+// parseQuery reads the matchup from the query string on GET/DELETE/PUT or
+// from a JSON body on POST, returning the status code to respond with on
+// failure
+func parseQuery(r *http.Request) (models.Query, int, error) {
+	switch r.Method {
+	case http.MethodGet, http.MethodDelete, http.MethodPut:
+		return models.Query{
+			Champion: r.URL.Query().Get("champ"),
+			Opponent: r.URL.Query().Get("opp"),
+			Role:     r.URL.Query().Get("role"),
+		}, http.StatusOK, nil
+	case http.MethodPost:
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			return models.Query{}, http.StatusUnsupportedMediaType, fmt.Errorf("Content-Type must be application/json")
+		}
+
+		var q models.Query
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			return models.Query{}, bodyErrorCode(err), fmt.Errorf("Invalid JSON body: %s", err)
+		}
+		return q, http.StatusOK, nil
+	default:
+		return models.Query{}, http.StatusMethodNotAllowed, fmt.Errorf("Method %s not allowed", r.Method)
+	}
+}
+
+// bodyErrorCode is 413 if decoding a body failed because it was over
+// maxBodyBytes, otherwise 400
+func bodyErrorCode(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// validateQuery checks the required fields and normalizes champion and role
+// names. A non-nil payload is the 400 body to send back.
+func validateQuery(q models.Query) (models.Query, interface{}) {
+	if q.Champion == "" || q.Opponent == "" {
+		return q, map[string]string{"error": "Missing required parameters"}
+	}
+
+	// reject unknown champions before paying for the pipeline, and use the
+	// canonical names so the cache key doesn't depend on spelling
+	for _, name := range []*string{&q.Champion, &q.Opponent} {
+		canonical, ok := models.NormalizeChampion(*name)
+		if !ok {
+			return q, map[string]interface{}{
+				"error":       fmt.Sprintf("Unknown champion: %s", *name),
+				"suggestions": models.ClosestChampions(*name, 3),
+			}
+		}
+		*name = canonical
+	}
+
+	role, ok := models.NormalizeRole(q.Role)
+	if !ok {
+		return q, map[string]string{"error": fmt.Sprintf("Unknown role: %s", q.Role)}
+	}
+	q.Role = role
+
+	return q, nil
+}
+
+// logUsage records what a matchup cost in bedrock tokens so spend can be
+// attributed to popular matchups
+func logUsage(ctx context.Context, usage summarize.Usage) {
+	logging.FromContext(ctx).Info("bedrock usage",
+		"input_tokens", usage.InputTokens,
+		"output_tokens", usage.OutputTokens,
+		"total_tokens", usage.Total(),
+		"estimated_usd", usage.EstimatedCost())
+}
+
+// a summary along with the thread it was generated from
+type sourceSummary struct {
+	link    string
+	summary string
+	points  []models.AdvicePoint
+	score   int
+	model   string
+	// authority of the thread's subreddit, see models.SubredditWeight
+	weight float64
+}
+
+// generatedAdvice is what one of the generate functions produced
+type generatedAdvice struct {
+	advice  string
+	points  []models.AdvicePoint
+	sources []string
+	// bedrock models that wrote it, normally just the primary, see
+	// summarize's modelChain
+	models []string
+}
+
+// appendModel adds model to models once, ignoring results without one
+func appendModel(models []string, model string) []string {
+	if model == "" || slices.Contains(models, model) {
+		return models
+	}
+	return append(models, model)
+}
+
+var (
+	sourceSem     chan struct{}
+	sourceSemOnce sync.Once
+)
+
+// acquireSourceSlot waits for one of the MAX_SOURCE_CONCURRENCY slots shared
+// by every matchup, so raising the result count or warming a batch can't fan
+// out into more reddit and bedrock calls than they'll take at once
+func acquireSourceSlot(ctx context.Context) (release func(), err error) {
+	sourceSemOnce.Do(func() {
+		sourceSem = make(chan struct{}, cfg.MaxSourceConcurrency)
+	})
+
+	select {
+	case sourceSem <- struct{}{}:
+		return func() { <-sourceSem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// generateAdvice scrapes and summarizes every search result concurrently,
+// calling onSummary (if set) as each source finishes. It returns the advice
+// concatenated best scoring thread first and the links that contributed to
+// it, or an empty string if no source produced any.
+func generateAdvice(ctx context.Context, q models.Query, items []models.SearchItem, deps adviceDeps, onSummary func(string)) (generatedAdvice, error) {
+	// buffered so sources finishing after we've given up (timeout or client
+	// disconnect) can still send and exit instead of blocking forever
+	resultChan := make(chan sourceSummary, len(items))
+	errorChan := make(chan error, len(items))
+
+	// bedrock usage across every source, including ones that failed
+	var usageMu sync.Mutex
+	var usage summarize.Usage
+
+	// each source gets its own slice of the budget so one stalled thread is
+	// dropped instead of timing out the whole request
+	sourceTimeout := cfg.SourceTimeout
+
+	for _, item := range items {
+		go func(item models.SearchItem) {
+			release, err := acquireSourceSlot(ctx)
+			if err != nil {
+				errorChan <- fmt.Errorf("gave up waiting to process %s: %v", item.Link, err)
+				return
+			}
+			defer release()
+
+			// the source's budget starts once it has a slot
+			sourceCtx, cancel := context.WithTimeout(ctx, sourceTimeout)
+			defer cancel()
+
+			scrapedContent, err := deps.scraper.Scrape(sourceCtx, item)
+			if err != nil {
+				errorChan <- fmt.Errorf("scraping error for %s: %w", item.Link, err)
+				return
+			}
+			if err := sourceCtx.Err(); err != nil {
+				errorChan <- fmt.Errorf("scraping %s exceeded source timeout: %v", item.Link, err)
+				return
+			}
+
+			result, err := deps.summarizer.Summarize(sourceCtx, scrapedContent, q.Champion, q.Opponent, q.Role, q.Patch)
+			usageMu.Lock()
+			usage = usage.Add(result.Usage)
+			usageMu.Unlock()
+			if errors.Is(err, summarize.ErrIrrelevantSource) {
+				errorChan <- fmt.Errorf("invalid input for %s", item.Link)
+				return
+			}
+			if err != nil {
+				errorChan <- fmt.Errorf("summarization error for %s: %v", item.Link, err)
+				return
+			}
+
+			resultChan <- sourceSummary{link: item.Link, summary: result.Summary, points: result.Points, score: result.Score, model: result.Model, weight: models.LinkWeight(item.Link, q.Champion)}
+		}(item)
+	}
+
+	var summaries []sourceSummary
+	errorCount := 0
+
+	for i := 0; i < len(items); i++ {
+		select {
+		case result := <-resultChan:
+			summaries = append(summaries, result)
+			if onSummary != nil {
+				onSummary(result.summary)
+			}
+		case err := <-errorChan:
+			logSourceError(ctx, err)
+			errorCount++
+		case <-ctx.Done():
+			return generatedAdvice{}, ctx.Err()
+		}
+	}
+
+	usageMu.Lock()
+	logUsage(ctx, usage)
+	usageMu.Unlock()
+
+	if errorCount == len(items) {
+		return generatedAdvice{sources: []string{}}, nil
+	}
+
+	// finish order is down to timing, sorting keeps the cached advice stable
+	// and leads with the thread the community rated highest, scaled by how
+	// much its subreddit is trusted
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := float64(summaries[i].score)*summaries[i].weight, float64(summaries[j].score)*summaries[j].weight
+		if a != b {
+			return a > b
+		}
+		return summaries[i].link < summaries[j].link
+	})
+
+	var finalAdvice strings.Builder
+	generated := generatedAdvice{sources: []string{}}
+	for _, result := range summaries {
+		finalAdvice.WriteString(result.summary)
+		finalAdvice.WriteString("\n\n")
+		generated.points = append(generated.points, result.points...)
+		generated.sources = append(generated.sources, result.link)
+		generated.models = appendModel(generated.models, result.model)
+	}
+	generated.advice = finalAdvice.String()
+
+	return generated, nil
+}
+
+// logSourceError logs why a source was dropped. A missing thread is routine,
+// while rejected credentials will sink every source until someone fixes them.
+func logSourceError(ctx context.Context, err error) {
+	switch {
+	case errors.Is(err, scrape.ErrPostNotFound), errors.Is(err, scrape.ErrPostForbidden), errors.Is(err, scrape.ErrBadURL):
+		logging.FromContext(ctx).Info("source skipped", "error", err)
+	case errors.Is(err, scrape.ErrSubredditUnavailable):
+		logging.FromContext(ctx).Info("source skipped, subreddit is unavailable", "error", err)
+	case errors.Is(err, scrape.ErrRedditAuth):
+		logging.FromContext(ctx).Error("reddit rejected our credentials", "error", err)
+	case errors.Is(err, scrape.ErrRedditRateLimited):
+		logging.FromContext(ctx).Warn("source dropped, reddit rate limit reached", "error", err)
+	default:
+		logging.FromContext(ctx).Error("source failed", "error", err)
+	}
+}
+
+// truncateAdvice cuts advice down to at most max characters, dropping whole
+// points from the end and noting that it did. What's left is rendered from
+// the points that are kept, so the advice and its points always match. A
+// first point that's too long on its own is cut at a word instead. max of 0
+// means no limit.
+func truncateAdvice(advice string, points []models.AdvicePoint, max int) (string, []models.AdvicePoint) {
+	if max == 0 || len(advice) <= max {
+		return advice, points
+	}
+
+	// leave room for the note and the blank line before it
+	budget := max - len(truncatedNote) - 2
+	var kept []models.AdvicePoint
+	for _, point := range points {
+		if len(summarize.FormatPoints(append(slices.Clip(kept), point))) > budget {
+			break
+		}
+		kept = append(kept, point)
+	}
+
+	if len(kept) == 0 && len(points) > 0 && budget > 0 {
+		point := points[0]
+		if over := len(summarize.FormatPoints(points[:1])) - budget; over < len(point.Text) {
+			text := point.Text[:len(point.Text)-over]
+			if i := strings.LastIndex(text, " "); i > 0 {
+				text = text[:i]
+			}
+			if point.Text = strings.ToValidUTF8(text, ""); point.Text != "" {
+				kept = []models.AdvicePoint{point}
+			}
+		}
+	}
+
+	if len(kept) == 0 {
+		return truncatedNote[:min(len(truncatedNote), max)], nil
+	}
+	return summarize.FormatPoints(kept) + "\n\n" + truncatedNote, kept
+}
+
+// fetchStatsPoint returns the matchup's stats as an advice point, or an
+// empty summary if stats are turned off or unavailable
+func fetchStatsPoint(ctx context.Context, fetcher source.Fetcher, q models.Query) sourceSummary {
+	// win rates are per lane, there's no one number for a flex matchup
+	if fetcher == nil || q.Role == models.RoleAny {
+		return sourceSummary{}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.SourceTimeout)
+	defer cancel()
+
+	stats, err := fetcher.Fetch(ctx, q)
+	if errors.Is(err, source.ErrNoData) {
+		logging.FromContext(ctx).Info("no stats for matchup", "fetcher", fetcher.Name())
+		return sourceSummary{}
+	}
+	if err != nil {
+		metrics.SourceErrors.WithLabelValues(fetcher.Name()).Inc()
+		logging.FromContext(ctx).Warn("stats fetch failed", "fetcher", fetcher.Name(), "error", err)
+		return sourceSummary{}
+	}
+
+	point := source.Point(q, stats)
+	return sourceSummary{link: stats.Link, summary: summarize.FormatPoints([]models.AdvicePoint{point}), points: []models.AdvicePoint{point}}
+}
+
+// a validated matchup request
+type matchupRequest struct {
+	// oriented to match the canonical cache key
+	query models.Query
+	key   string
+	// the client asked from the other champion's side, see orient
+	reversed bool
+	// skip the cache read and regenerate the advice
+	refresh bool
+	// a refresh without the admin token, which only regenerates a cached
+	// negative result
+	retryNegative bool
+	// rate limits are applied per ip when advice has to be generated
+	clientIP string
+	// admin batch jobs aren't rate limited
+	unlimited bool
+	// summarize thin matchups at a higher temperature
+	exploratory bool
+	// language code the advice is written in, empty for english
+	lang string
+	// advice from the search snippets alone, see generateQuickAdvice
+	quick bool
+}
+
+// generateAdviceBatch scrapes every search result in one batch, then
+// summarizes them together with a single summary and quality control call.
+// Every thread that scraped successfully is reported as a source, since the
+// combined summary can't be attributed to individual threads.
+func generateAdviceBatch(ctx context.Context, q models.Query, items []models.SearchItem, deps adviceDeps, onSummary func(string)) (generatedAdvice, error) {
+	sourceTimeout := cfg.SourceTimeout
+
+	// the whole batch counts as one source against the concurrency cap
+	release, err := acquireSourceSlot(ctx)
+	if err != nil {
+		return generatedAdvice{}, err
+	}
+	scrapedPosts, err := deps.scraper.ScrapeBatch(ctx, items)
+	release()
+	if err != nil {
+		if ctx.Err() != nil {
+			return generatedAdvice{}, ctx.Err()
+		}
+		logging.FromContext(ctx).Error("scraping failed", "error", err)
+		return generatedAdvice{sources: []string{}}, nil
+	}
+
+	var posts [][]byte
+	sources := []string{}
+	for i, post := range scrapedPosts {
+		// left zero when that thread couldn't be scraped
+		if post.Permalink == "" {
+			continue
+		}
+		data, err := json.Marshal(post)
+		if err != nil {
+			continue
+		}
+		posts = append(posts, data)
+		sources = append(sources, items[i].Link)
+	}
+
+	if len(posts) == 0 {
+		return generatedAdvice{sources: []string{}}, nil
+	}
+
+	summaryCtx, cancel := context.WithTimeout(ctx, sourceTimeout)
+	defer cancel()
+
+	result, err := deps.summarizer.SummarizeBatch(summaryCtx, posts, q.Champion, q.Opponent, q.Role, q.Patch)
+	logUsage(ctx, result.Usage)
+	if errors.Is(err, summarize.ErrIrrelevantSource) {
+		return generatedAdvice{sources: []string{}}, nil
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return generatedAdvice{}, ctx.Err()
+		}
+		logging.FromContext(ctx).Error("batch summarization failed", "error", err)
+		return generatedAdvice{sources: []string{}}, nil
+	}
+
+	if onSummary != nil {
+		onSummary(result.Summary)
+	}
+
+	return generatedAdvice{advice: result.Summary, points: result.Points, sources: sources, models: appendModel(nil, result.Model)}, nil
+}
+
+// generateQuickAdvice summarizes the search snippets in a single bedrock call
+// without scraping reddit at all, trading quality for a fast answer. Every
+// result with a snippet is reported as a source.
+func generateQuickAdvice(ctx context.Context, q models.Query, items []models.SearchItem, deps adviceDeps, onSummary func(string)) (generatedAdvice, error) {
+	summaryCtx, cancel := context.WithTimeout(ctx, cfg.SourceTimeout)
+	defer cancel()
+
+	result, err := deps.summarizer.SummarizeSnippets(summaryCtx, items, q.Champion, q.Opponent, q.Role, q.Patch)
+	logUsage(ctx, result.Usage)
+	if errors.Is(err, summarize.ErrIrrelevantSource) {
+		return generatedAdvice{sources: []string{}}, nil
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return generatedAdvice{}, ctx.Err()
+		}
+		logging.FromContext(ctx).Error("snippet summarization failed", "error", err)
+		return generatedAdvice{sources: []string{}}, nil
+	}
+
+	sources := []string{}
+	for _, item := range items {
+		if item.Snippet != "" {
+			sources = append(sources, item.Link)
+		}
+	}
+
+	if onSummary != nil {
+		onSummary(result.Summary)
+	}
+
+	return generatedAdvice{advice: result.Summary, points: result.Points, sources: sources, models: appendModel(nil, result.Model)}, nil
+}
+
+// matchupQuery parses and validates the matchup for a request, writing the
+// error response itself and returning false if the request can't proceed
+func matchupQuery(w http.ResponseWriter, r *http.Request) (matchupRequest, bool) {
+	q, code, err := parseQuery(r)
+	if err != nil {
+		jsonResponse(w, code, map[string]string{"error": err.Error()})
+		return matchupRequest{}, false
+	}
+
+	// Validate input
+	q, invalid := validateQuery(q)
+	if invalid != nil {
+		jsonResponse(w, http.StatusBadRequest, invalid)
+		return matchupRequest{}, false
+	}
+
+	req := newMatchupRequest(r.Context(), q)
+
+	if lang := strings.ToLower(r.URL.Query().Get("lang")); lang != "" {
+		if _, ok := summarize.OutputLanguages[lang]; !ok {
+			jsonResponse(w, http.StatusBadRequest, map[string]interface{}{
+				"error":     fmt.Sprintf("Unsupported language: %s", lang),
+				"supported": slices.Sorted(maps.Keys(summarize.OutputLanguages)),
+			})
+			return matchupRequest{}, false
+		}
+		req = req.inLanguage(lang)
+	}
+
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "full":
+	case "quick":
+		// a different quality of advice, so it's cached apart from the full kind
+		req.quick = true
+		req.key += "~quick"
+	default:
+		jsonResponse(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Unknown mode: %s, must be full or quick", mode)})
+		return matchupRequest{}, false
+	}
+
+	// forcing a regeneration costs a full pipeline run so it's admin only,
+	// other than for a matchup that had no advice, see getAdvice
+	if r.URL.Query().Get("refresh") == "true" {
+		if isAdmin(r) {
+			req.refresh = true
+		} else {
+			req.retryNegative = true
+		}
+	}
+
+	req.clientIP = clientIP(r)
+	req.exploratory = r.URL.Query().Get("exploratory") == "true"
+
+	return req, true
+}
+
+// newMatchupRequest orients a validated query to its cache key
+func newMatchupRequest(ctx context.Context, q models.Query) matchupRequest {
+	key, reversed := canonicalKey(q)
+	if reversed {
+		// advice is always generated (and cached) from the perspective of the
+		// canonical champion, the response tells the client whose view it is
+		q.Champion, q.Opponent = q.Opponent, q.Champion
+	}
+
+	// advice goes stale every patch, keying on it means a new patch starts
+	// from a clean cache
+	if q.Patch = patch.Current(ctx); q.Patch != "" {
+		key += "#" + q.Patch
+	}
+
+	return matchupRequest{query: q, key: key, reversed: reversed}
+}
+
+// inLanguage returns req for advice written in lang, cached separately from
+// every other language. English is the default and keeps the plain key.
+func (req matchupRequest) inLanguage(lang string) matchupRequest {
+	if lang == "en" {
+		return req
+	}
+	req.lang = lang
+	req.key += ":" + lang
+	return req
+}
+
+// writeAdviceError responds with an error from getAdvice, telling rate
+// limited clients when to come back
+func writeAdviceError(w http.ResponseWriter, code int, err error) {
+	var limited *rateLimitError
+	if errors.As(err, &limited) {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(limited.retryAfter)))
+	}
+	var unavailable *unavailableError
+	if errors.As(err, &unavailable) {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(unavailable.retryAfter)))
+	}
+
+	jsonResponse(w, code, map[string]string{"error": err.Error()})
+}
+
+// unavailableError is a 503 for a dependency that's out of quota, telling the
+// client when it's expected back
+type unavailableError struct {
+	message    string
+	retryAfter time.Duration
+}
+
+func (e *unavailableError) Error() string {
+	return e.message
+}
+
+// errorStatus picks the status for a failed pipeline call: 503 when search is
+// out of quota or reddit is rate limiting us, 404 for a thread that's gone or
+// in a subreddit reddit won't show, 504 for timeouts, 502 when an upstream
+// service failed and 500 for anything else, which is on us
+func errorStatus(err error) int {
+	var quotaErr *search.QuotaError
+	var upstreamErr *models.UpstreamError
+	switch {
+	case errors.As(err, &quotaErr), errors.Is(err, scrape.ErrRedditRateLimited):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, scrape.ErrPostNotFound), errors.Is(err, scrape.ErrPostForbidden), errors.Is(err, scrape.ErrSubredditUnavailable):
+		return http.StatusNotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.As(err, &upstreamErr):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// in-flight matchup computations keyed on the canonical cache key
+var inflight singleflight.Group
+
+type computeResult struct {
+	matchup models.CachedMatchup
+	code    int
+	// cancelled because every caller waiting on it left
+	abandoned bool
+}
+
+// flights counts the callers waiting on each of inflight's computations
+var flights = struct {
+	sync.Mutex
+	m map[string]*flight
+}{m: make(map[string]*flight)}
+
+// flight is the context a matchup's computation runs under, cancelled once
+// the last caller waiting on it leaves
+type flight struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+}
+
+// joinFlight counts the caller as waiting on key's computation, returning the
+// context it runs under and a func to call once the caller has its result or
+// has given up on it
+func joinFlight(key string) (context.Context, func()) {
+	flights.Lock()
+	defer flights.Unlock()
+
+	f, ok := flights.m[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		f = &flight{ctx: ctx, cancel: cancel}
+		flights.m[key] = f
+	}
+	f.waiters++
+
+	return f.ctx, func() {
+		flights.Lock()
+		defer flights.Unlock()
+
+		if f.waiters--; f.waiters == 0 {
+			f.cancel()
+			delete(flights.m, key)
+		}
+	}
+}
+
+// getAdvice returns the cached matchup for key, generating and caching it if
+// it's missing. On failure it returns the status code to respond with.
+func (s *matchupService) getAdvice(ctx context.Context, rdb *redis.Client, req matchupRequest, onSummary func(string)) (models.CachedMatchup, int, error) {
+	q, key := req.query, req.key
+	ctx = logging.With(ctx, "champ", q.Champion, "opp", q.Opponent, "role", q.Role)
+
+	// warm ups aren't demand, only count what clients ask for
+	if !req.unlimited {
+		recordRequest(ctx, rdb, q)
+	}
+
+	if !req.refresh {
+		cached, err := getCachedMatchup(ctx, rdb, key)
+		if err == nil && req.retryNegative && isNegative(cached) {
+			// anyone can ask for another look at a matchup with no advice,
+			// it's rate limited like any other generation below
+			metrics.CacheLookups.WithLabelValues("miss").Inc()
+			logging.FromContext(ctx).Info("retrying negative result", "key", key)
+		} else if err == nil && req.retryNegative {
+			return models.CachedMatchup{}, http.StatusForbidden, fmt.Errorf("refresh requires a valid admin token")
+		} else if err == nil {
+			// If key exists in cache, return it immediately
+			metrics.CacheLookups.WithLabelValues("hit").Inc()
+			return cached, http.StatusOK, nil
+		} else if err != redis.Nil {
+			// the cache is best effort, a flaky read shouldn't stop us from
+			// generating the advice
+			metrics.CacheLookups.WithLabelValues("error").Inc()
+			logging.FromContext(ctx).Warn("cache read failed, generating advice", "key", key, "error", err)
+		} else {
+			metrics.CacheLookups.WithLabelValues("miss").Inc()
+		}
+	}
+
+	// If we're here, the key wasn't in the cache, so we need to generate advice
+
+	// checked first so batch and admin requests don't use up the client's tokens
+	if !req.unlimited {
+		if ok, retryAfter := limiter.allow(req.clientIP); !ok {
+			return models.CachedMatchup{}, http.StatusTooManyRequests, &rateLimitError{retryAfter: retryAfter}
+		}
+	}
+
+	// only the first caller's summaries are streamed, and only while it's
+	// still waiting on the result
+	var notifyMu sync.Mutex
+	waiting := true
+	defer func() {
+		notifyMu.Lock()
+		waiting = false
+		notifyMu.Unlock()
+	}()
+	notify := func(summary string) {
+		notifyMu.Lock()
+		defer notifyMu.Unlock()
+		if waiting && onSummary != nil {
+			onSummary(summary)
+		}
+	}
+
+	// concurrent requests for the same matchup share one computation. It runs
+	// detached from the caller's context so one client disconnecting doesn't
+	// cancel it for everyone else, but once every caller has gone it's
+	// cancelled rather than paying for bedrock calls nobody will read.
+	flightCtx, leave := joinFlight(key)
+	defer leave()
+
+	for {
+		ch := inflight.DoChan(key, func() (interface{}, error) {
+			computeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cfg.MatchupTimeout)
+			defer cancel()
+			defer context.AfterFunc(flightCtx, cancel)()
+
+			// a flight that finished between our cache read and here already cached it
+			if !req.refresh {
+				if cached, err := getCachedMatchup(computeCtx, rdb, key); err == nil && !(req.retryNegative && isNegative(cached)) {
+					return computeResult{matchup: cached, code: http.StatusOK}, nil
+				}
+			}
+
+			matchup, code, err := s.computeMatchup(computeCtx, rdb, req, notify)
+			return computeResult{matchup: matchup, code: code, abandoned: err != nil && flightCtx.Err() != nil}, err
+		})
+
+		select {
+		case res := <-ch:
+			result := res.Val.(computeResult)
+			if result.abandoned && ctx.Err() == nil {
+				// joined a computation just as the callers before us gave up
+				// on it, start another
+				continue
+			}
+			return result.matchup, result.code, res.Err
+		case <-ctx.Done():
+			return models.CachedMatchup{}, http.StatusGatewayTimeout, fmt.Errorf("Processing took too long and was terminated")
+		}
+	}
+}
+
+// computeMatchup searches, scrapes and summarizes a matchup and caches the result
+func (s *matchupService) computeMatchup(ctx context.Context, rdb *redis.Client, req matchupRequest, onSummary func(string)) (models.CachedMatchup, int, error) {
+	matchup, code, err := computeAdvice(ctx, req, s.deps, onSummary)
+	if err != nil {
+		return models.CachedMatchup{}, code, err
+	}
+
+	// a retried negative result is still cached, so it has to be replaced
+	return cacheGenerated(ctx, rdb, req.key, matchup, req.refresh || req.retryNegative), http.StatusOK, nil
+}
+
+// computeAdvice runs the pipeline for a matchup against deps without touching
+// the cache. On failure it returns the status code to respond with.
+func computeAdvice(ctx context.Context, req matchupRequest, deps adviceDeps, onSummary func(string)) (models.CachedMatchup, int, error) {
+	q := req.query
+
+	// stats don't depend on the search results so they're fetched alongside,
+	// with ctx passed in since it picks up summary options below
+	statsChan := make(chan sourceSummary, 1)
+	go func(ctx context.Context) {
+		statsChan <- fetchStatsPoint(ctx, deps.stats, q)
+	}(ctx)
+
+	searchResults, err := deps.searcher.Search(ctx, q)
+	if err != nil {
+		var quotaErr *search.QuotaError
+		if errors.As(err, &quotaErr) {
+			logging.FromContext(ctx).Error("search quota exceeded", "error", err)
+			return models.CachedMatchup{}, http.StatusServiceUnavailable, &unavailableError{message: "Search temporarily unavailable", retryAfter: quotaErr.RetryAfter()}
+		}
+		return models.CachedMatchup{}, errorStatus(err), fmt.Errorf("Search failed: %s", err)
+	}
+
+	// Exploratory advice is cached under the same key as the deterministic
+	// kind, so for thin matchups whichever request generates it first decides
+	// what's served until it expires. Well covered matchups never change.
+	if req.exploratory && len(searchResults.Items) < thinSourceCount {
+		logging.FromContext(ctx).Info("exploring thin matchup", "results", len(searchResults.Items))
+		ctx = summarize.WithExploration(ctx)
+	}
+
+	if req.lang != "" {
+		ctx = summarize.WithLanguage(ctx, req.lang)
+	}
+
+	generate := generateAdvice
+	switch {
+	case req.quick:
+		generate = generateQuickAdvice
+	case deps.batch:
+		generate = generateAdviceBatch
+	}
+
+	generated, err := generate(ctx, q, searchResults.Items, deps, onSummary)
+	if err != nil {
+		return models.CachedMatchup{}, http.StatusGatewayTimeout, fmt.Errorf("Processing took too long and was terminated")
+	}
+	advice, points, sources := generated.advice, generated.points, generated.sources
+
+	// the hard numbers lead, the community's advice explains them
+	if stats := <-statsChan; stats.summary != "" {
+		if onSummary != nil {
+			onSummary(stats.summary)
+		}
+		advice = strings.TrimSpace(stats.summary + "\n\n" + advice)
+		points = append(slices.Clip(stats.points), points...)
+		if stats.link != "" {
+			sources = append([]string{stats.link}, sources...)
+		}
+	}
+
+	if advice == "" {
+		advice = noAdviceMessage
+	}
+	advice, points = truncateAdvice(advice, points, cfg.MaxAdviceChars)
+
+	matchup := models.CachedMatchup{
+		Advice:      advice,
+		Points:      points,
+		Sources:     sources,
+		GeneratedAt: time.Now().Unix(),
+		Patch:       q.Patch,
+		Models:      generated.models,
+	}
+	metrics.SourcesUsed.Observe(float64(len(sources)))
+
+	return matchup, http.StatusOK, nil
+}
+
+func (s *matchupService) MatchupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		DeleteMatchupHandler(w, r)
+		return
+	}
+	metrics.Requests.WithLabelValues("matchup").Inc()
+
+	// bounded by the matchup compute budget
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.MatchupTimeout)
+	defer cancel()
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		jsonResponse(w, http.StatusServiceUnavailable, map[string]string{"error": "Redis client not initialized"})
+		return
+	}
+
+	req, ok := matchupQuery(w, r)
+	if !ok {
+		return
+	}
+	matchup, code, err := s.getAdvice(ctx, rdb, req, nil)
+	if err != nil {
+		writeAdviceError(w, code, err)
+		return
+	}
+	setCacheControl(w, matchup)
+	matchup, q := s.orient(ctx, rdb, req, matchup)
+
+	jsonResponse(w, http.StatusOK, models.MatchupResponse{
+		Advice:      matchup.Advice,
+		Perspective: q.Champion,
+		SourcesUsed: len(matchup.Sources),
+		Sources:     matchup.Sources,
+		Patch:       matchup.Patch,
+		GeneratedAt: generatedAt(matchup),
+		Models:      matchup.Models,
+	})
+}
+
+// advicePoints is the matchup's advice as points, parsed from the text for
+// entries cached before the points were stored with it
+func advicePoints(matchup models.CachedMatchup) []models.AdvicePoint {
+	if matchup.Points != nil {
+		return matchup.Points
+	}
+	return summarize.ParsePoints(matchup.Advice)
+}
+
+// MatchupV2Handler returns the same advice as MatchupHandler split into
+// individual points with their sources, so clients don't have to parse them
+func (s *matchupService) MatchupV2Handler(w http.ResponseWriter, r *http.Request) {
+	metrics.Requests.WithLabelValues("matchup_v2").Inc()
+
+	// bounded by the matchup compute budget
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.MatchupTimeout)
+	defer cancel()
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		jsonResponse(w, http.StatusServiceUnavailable, map[string]string{"error": "Redis client not initialized"})
+		return
+	}
+
+	req, ok := matchupQuery(w, r)
+	if !ok {
+		return
+	}
+	matchup, code, err := s.getAdvice(ctx, rdb, req, nil)
+	if err != nil {
+		writeAdviceError(w, code, err)
+		return
+	}
+	setCacheControl(w, matchup)
+	matchup, q := s.orient(ctx, rdb, req, matchup)
+
+	jsonResponse(w, http.StatusOK, models.AdviceResponse{
+		Points:      advicePoints(matchup),
+		Champion:    q.Champion,
+		Opponent:    q.Opponent,
+		Role:        q.Role,
+		Patch:       matchup.Patch,
+		GeneratedAt: generatedAt(matchup),
+		Models:      matchup.Models,
+	})
+}
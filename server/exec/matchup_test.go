@@ -0,0 +1,994 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"server/models"
+	"server/scrape"
+	"server/summarize"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// fakeSearcher returns items for every query. With gate set each search
+// waits for it to be closed first.
+type fakeSearcher struct {
+	items []models.SearchItem
+	err   error
+	gate  chan struct{}
+	calls atomic.Int32
+}
+
+func (f *fakeSearcher) Search(ctx context.Context, q models.Query) (models.SearchResponse, error) {
+	f.calls.Add(1)
+	if f.gate != nil {
+		<-f.gate
+	}
+	return models.SearchResponse{Items: f.items}, f.err
+}
+
+// fakeScraper "scrapes" a thread to its link, failing the ones in errs
+type fakeScraper struct {
+	errs map[string]error
+}
+
+func (f *fakeScraper) Scrape(ctx context.Context, item models.SearchItem) ([]byte, error) {
+	if err := f.errs[item.Link]; err != nil {
+		return nil, err
+	}
+	return []byte(item.Link), nil
+}
+
+func (f *fakeScraper) ScrapeBatch(ctx context.Context, items []models.SearchItem) ([]scrape.Post, error) {
+	posts := make([]scrape.Post, len(items))
+	for i, item := range items {
+		if f.errs[item.Link] == nil {
+			posts[i] = scrape.Post{Permalink: item.Link}
+		}
+	}
+	return posts, nil
+}
+
+// fakeSummarizer writes one point per thread citing it, scored by scores and
+// failing the threads in errs. With block set it waits out the context,
+// counting the summaries it gave up on in cancelled.
+type fakeSummarizer struct {
+	errs      map[string]error
+	scores    map[string]int
+	block     bool
+	calls     atomic.Int32
+	cancelled atomic.Int32
+}
+
+func (f *fakeSummarizer) Summarize(ctx context.Context, data []byte, championA, championB, role, patch string) (summarize.Result, error) {
+	f.calls.Add(1)
+	link := string(data)
+	if f.block {
+		<-ctx.Done()
+		f.cancelled.Add(1)
+		return summarize.Result{}, ctx.Err()
+	}
+	if err := f.errs[link]; err != nil {
+		return summarize.Result{Usage: summarize.Usage{InputTokens: 100}}, err
+	}
+	result := fakeResult(fmt.Sprintf("%s should respect %s's level 2", championA, championB), link)
+	result.Score = f.scores[link]
+	return result, nil
+}
+
+func (f *fakeSummarizer) SummarizeBatch(ctx context.Context, posts [][]byte, championA, championB, role, patch string) (summarize.Result, error) {
+	f.calls.Add(1)
+	return fakeResult(fmt.Sprintf("%s should respect %s's level 2", championA, championB), "batch"), nil
+}
+
+func (f *fakeSummarizer) SummarizeSnippets(ctx context.Context, items []models.SearchItem, championA, championB, role, patch string) (summarize.Result, error) {
+	f.calls.Add(1)
+	return fakeResult(fmt.Sprintf("%s should play safe against %s", championA, championB), "snippets"), nil
+}
+
+func (f *fakeSummarizer) RewritePerspective(ctx context.Context, advice, champion, opponent, lang string) (summarize.Result, error) {
+	f.calls.Add(1)
+	text := fmt.Sprintf("%s should punish %s", champion, opponent)
+	if lang != "" {
+		text += " (" + lang + ")"
+	}
+	return fakeResult(text, "rewrite"), nil
+}
+
+func fakeResult(text string, source string) summarize.Result {
+	points := []models.AdvicePoint{{Text: text, Sources: []string{source}}}
+	return summarize.Result{Summary: summarize.FormatPoints(points), Points: points, Model: "fake"}
+}
+
+func thread(id string) models.SearchItem {
+	return models.SearchItem{
+		Title: "Lux vs Zed mid",
+		Link:  "https://www.reddit.com/r/summonerschool/comments/" + id + "/lux_vs_zed/",
+	}
+}
+
+var luxZed = models.Query{Champion: "Lux", Opponent: "Zed", Role: "mid"}
+
+func TestComputeAdvice(t *testing.T) {
+	a, b, c := thread("aaa111"), thread("bbb222"), thread("ccc333")
+
+	tests := []struct {
+		name       string
+		items      []models.SearchItem
+		searchErr  error
+		scrapeErrs map[string]error
+		sumErrs    map[string]error
+		wantCode   int
+		wantErr    bool
+		// sources in the order their advice is served, nil for the no
+		// advice message
+		wantSources []string
+	}{
+		{
+			name:        "all sources succeed",
+			items:       []models.SearchItem{a, b, c},
+			wantCode:    http.StatusOK,
+			wantSources: []string{c.Link, b.Link, a.Link},
+		},
+		{
+			name:        "partial failure",
+			items:       []models.SearchItem{a, b, c},
+			scrapeErrs:  map[string]error{a.Link: scrape.ErrPostNotFound},
+			sumErrs:     map[string]error{b.Link: errors.New("bedrock is down")},
+			wantCode:    http.StatusOK,
+			wantSources: []string{c.Link},
+		},
+		{
+			name:        "subreddit gone private",
+			items:       []models.SearchItem{a, b},
+			scrapeErrs:  map[string]error{a.Link: &scrape.SubredditUnavailableError{Reason: "private"}},
+			wantCode:    http.StatusOK,
+			wantSources: []string{b.Link},
+		},
+		{
+			name:       "all sources fail",
+			items:      []models.SearchItem{a, b},
+			scrapeErrs: map[string]error{a.Link: scrape.ErrRedditRateLimited},
+			sumErrs:    map[string]error{b.Link: errors.New("bedrock is down")},
+			wantCode:   http.StatusOK,
+		},
+		{
+			name:     "irrelevant sources",
+			items:    []models.SearchItem{a, b},
+			sumErrs:  map[string]error{a.Link: summarize.ErrIrrelevantSource, b.Link: summarize.ErrIrrelevantSource},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "no search results",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:      "search fails",
+			searchErr: &models.UpstreamError{Service: "google", Err: errors.New("502 bad gateway")},
+			wantCode:  http.StatusBadGateway,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withTestConfig(t)
+
+			summarizer := &fakeSummarizer{
+				errs:   tt.sumErrs,
+				scores: map[string]int{a.Link: 10, b.Link: 20, c.Link: 30},
+			}
+			deps := adviceDeps{
+				searcher:   &fakeSearcher{items: tt.items, err: tt.searchErr},
+				scraper:    &fakeScraper{errs: tt.scrapeErrs},
+				summarizer: summarizer,
+			}
+
+			matchup, code, err := computeAdvice(context.Background(), newMatchupRequest(context.Background(), luxZed), deps, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want an error: %v", err, tt.wantErr)
+			}
+			if code != tt.wantCode {
+				t.Errorf("code = %d, want %d", code, tt.wantCode)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if tt.wantSources == nil {
+				if matchup.Advice != noAdviceMessage || len(matchup.Sources) != 0 || len(matchup.Points) != 0 {
+					t.Errorf("got %+v, want the no advice message", matchup)
+				}
+				return
+			}
+
+			if !reflect.DeepEqual(matchup.Sources, tt.wantSources) {
+				t.Errorf("sources = %q, want %q", matchup.Sources, tt.wantSources)
+			}
+			if len(matchup.Points) != len(tt.wantSources) {
+				t.Fatalf("got %d points, want one per source: %+v", len(matchup.Points), matchup.Points)
+			}
+			for i, point := range matchup.Points {
+				if point.Sources[0] != tt.wantSources[i] {
+					t.Errorf("point %d cites %s, want %s", i, point.Sources[0], tt.wantSources[i])
+				}
+			}
+			if !reflect.DeepEqual(matchup.Models, []string{"fake"}) {
+				t.Errorf("models = %q, want [fake]", matchup.Models)
+			}
+		})
+	}
+}
+
+func TestComputeAdviceTimesOut(t *testing.T) {
+	withTestConfig(t)
+
+	summarizer := &fakeSummarizer{block: true}
+	deps := adviceDeps{
+		searcher:   &fakeSearcher{items: []models.SearchItem{thread("aaa111"), thread("bbb222")}},
+		scraper:    &fakeScraper{},
+		summarizer: summarizer,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, code, err := computeAdvice(ctx, newMatchupRequest(ctx, luxZed), deps, nil)
+	if err == nil || code != http.StatusGatewayTimeout {
+		t.Errorf("code = %d, err = %v, want a gateway timeout", code, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s to give up, want about the 50ms deadline", elapsed)
+	}
+}
+
+func TestComputeAdviceTimeoutLeavesNoGoroutines(t *testing.T) {
+	withTestConfig(t)
+
+	var items []models.SearchItem
+	for i := range 20 {
+		items = append(items, thread(fmt.Sprintf("t%05d", i)))
+	}
+	deps := adviceDeps{
+		searcher:   &fakeSearcher{items: items},
+		scraper:    &fakeScraper{},
+		summarizer: &fakeSummarizer{block: true},
+	}
+
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, code, _ := computeAdvice(ctx, newMatchupRequest(ctx, luxZed), deps, nil); code != http.StatusGatewayTimeout {
+		t.Fatalf("code = %d, want a gateway timeout", code)
+	}
+
+	// every source notices the deadline and has to be able to report it
+	// with nobody left reading, a couple of spares for the runtime's own
+	const margin = 2
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before+margin {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left running after the timeout, %d before", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newTestService is a matchupService over fake stages that summarize every
+// one of items
+func newTestService(items ...models.SearchItem) (*matchupService, *fakeSearcher, *fakeSummarizer) {
+	searcher := &fakeSearcher{items: items}
+	summarizer := &fakeSummarizer{}
+	return &matchupService{deps: adviceDeps{searcher: searcher, scraper: &fakeScraper{}, summarizer: summarizer}}, searcher, summarizer
+}
+
+func TestGetAdviceCacheHit(t *testing.T) {
+	withTestConfig(t)
+	s, searcher, summarizer := newTestService(thread("aaa111"))
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	req := newMatchupRequest(ctx, luxZed)
+
+	cached := models.CachedMatchup{Advice: "• Cached advice [Sources: [a]]", Sources: []string{"a"}, GeneratedAt: time.Now().Unix()}
+	if _, err := setCachedMatchup(ctx, rdb, req.key, cached); err != nil {
+		t.Fatal(err)
+	}
+
+	matchup, code, err := s.getAdvice(ctx, rdb, req, nil)
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("code = %d, err = %v", code, err)
+	}
+	if matchup.Advice != cached.Advice {
+		t.Errorf("advice = %q, want the cached %q", matchup.Advice, cached.Advice)
+	}
+	if searcher.calls.Load() != 0 || summarizer.calls.Load() != 0 {
+		t.Errorf("a cache hit ran the pipeline: %d searches, %d summaries", searcher.calls.Load(), summarizer.calls.Load())
+	}
+}
+
+func TestGetAdviceCacheMiss(t *testing.T) {
+	c := withTestConfig(t)
+	s, searcher, _ := newTestService(thread("aaa111"))
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	req := newMatchupRequest(ctx, luxZed)
+
+	matchup, code, err := s.getAdvice(ctx, rdb, req, nil)
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("code = %d, err = %v", code, err)
+	}
+	if searcher.calls.Load() != 1 {
+		t.Errorf("searched %d times, want once", searcher.calls.Load())
+	}
+	if len(matchup.Points) != 1 || matchup.Sources[0] != thread("aaa111").Link {
+		t.Errorf("got %+v, want the generated advice", matchup)
+	}
+	if !reflect.DeepEqual(matchup.Models, []string{"fake"}) {
+		t.Errorf("models = %q, want the summarizer's", matchup.Models)
+	}
+
+	value, err := testRedis.Get(req.key)
+	if err != nil {
+		t.Fatalf("advice wasn't cached: %v", err)
+	}
+	stored, err := decodeCachedMatchup(value)
+	if err != nil || !reflect.DeepEqual(stored, matchup) {
+		t.Errorf("cached %+v, want %+v", stored, matchup)
+	}
+	if ttl := testRedis.TTL(req.key); ttl != c.CacheTTL {
+		t.Errorf("cached for %s, want CACHE_TTL %s", ttl, c.CacheTTL)
+	}
+
+	// the next request is served from the cache
+	if _, _, err := s.getAdvice(ctx, rdb, req, nil); err != nil {
+		t.Fatal(err)
+	}
+	if searcher.calls.Load() != 1 {
+		t.Errorf("searched %d times, want the cached advice served", searcher.calls.Load())
+	}
+}
+
+func TestGetAdviceCachesNegativeFallback(t *testing.T) {
+	c := withTestConfig(t, "NEGATIVE_CACHE_JITTER", "0")
+	s, _, _ := newTestService()
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	req := newMatchupRequest(ctx, luxZed)
+
+	matchup, code, err := s.getAdvice(ctx, rdb, req, nil)
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("code = %d, err = %v", code, err)
+	}
+	if !isNegative(matchup) {
+		t.Errorf("advice = %q, want the no advice message", matchup.Advice)
+	}
+	if ttl := testRedis.TTL(req.key); ttl != c.NegativeCacheTTL {
+		t.Errorf("negative result cached for %s, want NEGATIVE_CACHE_TTL %s", ttl, c.NegativeCacheTTL)
+	}
+}
+
+func TestGetAdviceSummarizerError(t *testing.T) {
+	withTestConfig(t)
+	item := thread("aaa111")
+	s, _, summarizer := newTestService(item)
+	summarizer.errs = map[string]error{item.Link: &models.UpstreamError{Service: "bedrock", Err: errors.New("throttled")}}
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// a failed source is dropped rather than failing the request
+	matchup, code, err := s.getAdvice(ctx, rdb, newMatchupRequest(ctx, luxZed), nil)
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("code = %d, err = %v", code, err)
+	}
+	if !isNegative(matchup) {
+		t.Errorf("advice = %q, want the no advice message", matchup.Advice)
+	}
+}
+
+func TestGetAdviceSearchErrorIsNotCached(t *testing.T) {
+	withTestConfig(t)
+	s, searcher, _ := newTestService()
+	searcher.err = &models.UpstreamError{Service: "google", Err: errors.New("500")}
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	req := newMatchupRequest(ctx, luxZed)
+
+	if _, code, err := s.getAdvice(ctx, rdb, req, nil); err == nil || code != http.StatusBadGateway {
+		t.Errorf("code = %d, err = %v, want a bad gateway", code, err)
+	}
+	if testRedis.Exists(req.key) {
+		t.Error("a failed search was cached")
+	}
+}
+
+func TestGetAdviceUnlimitedKeepsClientTokens(t *testing.T) {
+	withTestConfig(t, "RATE_LIMIT_RPS", "0.001", "RATE_LIMIT_BURST", "1")
+	s, _, _ := newTestService(thread("aaa111"))
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// unlimited misses for several matchups from one client...
+	for _, opp := range []string{"Zed", "Yasuo", "Syndra"} {
+		req := newMatchupRequest(ctx, models.Query{Champion: "Lux", Opponent: opp, Role: "mid"})
+		req.clientIP, req.unlimited = "203.0.113.7", true
+		if _, code, err := s.getAdvice(ctx, rdb, req, nil); err != nil || code != http.StatusOK {
+			t.Fatalf("unlimited %s: code = %d, err = %v", opp, code, err)
+		}
+	}
+
+	// ...leave its one token for a limited miss
+	req := newMatchupRequest(ctx, models.Query{Champion: "Lux", Opponent: "Ahri", Role: "mid"})
+	req.clientIP = "203.0.113.7"
+	if _, code, err := s.getAdvice(ctx, rdb, req, nil); err != nil || code != http.StatusOK {
+		t.Fatalf("first limited miss: code = %d, err = %v", code, err)
+	}
+
+	req = newMatchupRequest(ctx, models.Query{Champion: "Lux", Opponent: "Orianna", Role: "mid"})
+	req.clientIP = "203.0.113.7"
+	_, code, err := s.getAdvice(ctx, rdb, req, nil)
+	var limited *rateLimitError
+	if code != http.StatusTooManyRequests || !errors.As(err, &limited) {
+		t.Errorf("second limited miss: code = %d, err = %v, want rate limited", code, err)
+	}
+}
+
+// waitForFlightAfter makes the test wait for q's computation to finish before
+// it's cleaned up, callers can return before that by leaving or hitting the
+// cache it's just written
+func waitForFlightAfter(t *testing.T, q models.Query) {
+	key := newMatchupRequest(context.Background(), q).key
+	t.Cleanup(func() {
+		// joins the computation if it's still running
+		inflight.Do(key, func() (interface{}, error) { return computeResult{}, nil })
+	})
+}
+
+func TestGetAdviceConcurrentMissesComputeOnce(t *testing.T) {
+	withTestConfig(t)
+	s, searcher, _ := newTestService(thread("aaa111"))
+	searcher.gate = make(chan struct{})
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForFlightAfter(t, luxZed)
+
+	const n = 10
+	type answer struct {
+		matchup models.CachedMatchup
+		code    int
+		err     error
+	}
+	answers := make(chan answer, n)
+	for i := range n {
+		go func() {
+			ctx := context.Background()
+			req := newMatchupRequest(ctx, luxZed)
+			req.clientIP = fmt.Sprintf("203.0.113.%d", i)
+			matchup, code, err := s.getAdvice(ctx, rdb, req, nil)
+			answers <- answer{matchup, code, err}
+		}()
+	}
+
+	// give every caller time to join the first one's flight, any that are
+	// late find its result cached
+	time.Sleep(50 * time.Millisecond)
+	close(searcher.gate)
+
+	var first models.CachedMatchup
+	for i := range n {
+		a := <-answers
+		if a.err != nil || a.code != http.StatusOK {
+			t.Fatalf("caller %d: code = %d, err = %v", i, a.code, a.err)
+		}
+		if i == 0 {
+			first = a.matchup
+		} else if !reflect.DeepEqual(a.matchup, first) {
+			t.Errorf("caller %d got %+v, want the same advice as the rest %+v", i, a.matchup, first)
+		}
+	}
+	if searcher.calls.Load() != 1 {
+		t.Errorf("searched %d times for %d concurrent requests, want once", searcher.calls.Load(), n)
+	}
+}
+
+func TestGetAdviceSurvivesFirstCallerLeaving(t *testing.T) {
+	withTestConfig(t)
+	s, searcher, _ := newTestService(thread("aaa111"))
+	searcher.gate = make(chan struct{})
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForFlightAfter(t, luxZed)
+
+	// the first caller starts the computation then disconnects
+	ctx, cancel := context.WithCancel(context.Background())
+	left := make(chan int)
+	go func() {
+		_, code, _ := s.getAdvice(ctx, rdb, newMatchupRequest(ctx, luxZed), nil)
+		left <- code
+	}()
+	for searcher.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	stayed := make(chan error)
+	go func() {
+		ctx := context.Background()
+		req := newMatchupRequest(ctx, luxZed)
+		req.clientIP = "203.0.113.8"
+		_, code, err := s.getAdvice(ctx, rdb, req, nil)
+		if err == nil && code != http.StatusOK {
+			err = fmt.Errorf("code = %d", code)
+		}
+		stayed <- err
+	}()
+	waitFor(t, "the second caller to join", func() bool { return waiters(luxZed) == 2 })
+
+	cancel()
+	if code := <-left; code != http.StatusGatewayTimeout {
+		t.Errorf("the caller that left got %d, want a gateway timeout", code)
+	}
+
+	close(searcher.gate)
+	if err := <-stayed; err != nil {
+		t.Errorf("the caller still waiting failed: %v", err)
+	}
+	if searcher.calls.Load() != 1 {
+		t.Errorf("searched %d times, want the computation shared", searcher.calls.Load())
+	}
+}
+
+func TestGetAdviceCancelledOnceEveryCallerLeaves(t *testing.T) {
+	withTestConfig(t)
+	s, searcher, summarizer := newTestService(thread("aaa111"))
+	summarizer.block = true
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForFlightAfter(t, luxZed)
+
+	const n = 3
+	cancels := make([]context.CancelFunc, n)
+	codes := make(chan int, n)
+	for i := range n {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancels[i] = cancel
+		go func() {
+			req := newMatchupRequest(ctx, luxZed)
+			req.clientIP = fmt.Sprintf("203.0.113.%d", i)
+			_, code, _ := s.getAdvice(ctx, rdb, req, nil)
+			codes <- code
+		}()
+	}
+	waitFor(t, "every caller to join", func() bool { return waiters(luxZed) == n && summarizer.calls.Load() > 0 })
+
+	// the computation keeps going while anyone is still waiting on it
+	for _, cancel := range cancels[:n-1] {
+		cancel()
+		<-codes
+	}
+	time.Sleep(20 * time.Millisecond)
+	if summarizer.cancelled.Load() != 0 {
+		t.Fatal("the computation was cancelled with a caller still waiting")
+	}
+
+	cancels[n-1]()
+	if code := <-codes; code != http.StatusGatewayTimeout {
+		t.Errorf("the last caller got %d, want a gateway timeout", code)
+	}
+	waitFor(t, "the computation to be cancelled", func() bool { return summarizer.cancelled.Load() == 1 })
+	if searcher.calls.Load() != 1 {
+		t.Errorf("searched %d times, want the computation shared", searcher.calls.Load())
+	}
+}
+
+// waitFor polls cond until it's true, failing the test if it takes too long
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("gave up waiting for %s", what)
+		}
+	}
+}
+
+// waiters is how many callers are waiting on q's computation
+func waiters(q models.Query) int {
+	key := newMatchupRequest(context.Background(), q).key
+	flights.Lock()
+	defer flights.Unlock()
+	if f, ok := flights.m[key]; ok {
+		return f.waiters
+	}
+	return 0
+}
+
+func TestGetAdviceRestartsAnAbandonedComputation(t *testing.T) {
+	withTestConfig(t)
+	s, searcher, _ := newTestService(thread("aaa111"))
+	searcher.gate = make(chan struct{})
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForFlightAfter(t, luxZed)
+
+	// the only caller leaves while the search is still running
+	ctx, cancel := context.WithCancel(context.Background())
+	left := make(chan struct{})
+	go func() {
+		s.getAdvice(ctx, rdb, newMatchupRequest(ctx, luxZed), nil)
+		close(left)
+	}()
+	for searcher.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-left
+
+	// a caller arriving before the cancelled computation has wound down
+	// joins it, then gets a fresh one instead of its error
+	answer := make(chan error)
+	go func() {
+		ctx := context.Background()
+		req := newMatchupRequest(ctx, luxZed)
+		req.clientIP = "203.0.113.8"
+		_, code, err := s.getAdvice(ctx, rdb, req, nil)
+		if err == nil && code != http.StatusOK {
+			err = fmt.Errorf("code = %d", code)
+		}
+		answer <- err
+	}()
+	waitFor(t, "the next caller to join", func() bool { return waiters(luxZed) == 1 })
+	time.Sleep(20 * time.Millisecond)
+	close(searcher.gate)
+
+	if err := <-answer; err != nil {
+		t.Errorf("the caller that came after failed: %v", err)
+	}
+	if searcher.calls.Load() != 2 {
+		t.Errorf("searched %d times, want the abandoned search and a fresh one", searcher.calls.Load())
+	}
+}
+
+// failingGets is a redis hook that fails every GET, as if redis were
+// struggling, while letting writes through
+type failingGets struct{}
+
+func (failingGets) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if cmd.Name() == "get" {
+		return ctx, errors.New("LOADING Redis is loading the dataset in memory")
+	}
+	return ctx, nil
+}
+
+func (failingGets) AfterProcess(ctx context.Context, cmd redis.Cmder) error { return nil }
+
+func (failingGets) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (failingGets) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error { return nil }
+
+func TestGetAdviceGeneratesWhenCacheReadFails(t *testing.T) {
+	withTestConfig(t, "L1_CACHE_SIZE", "0")
+	s, searcher, _ := newTestService(thread("aaa111"))
+
+	client, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.AddHook(failingGets{})
+	ctx := context.Background()
+	req := newMatchupRequest(ctx, luxZed)
+
+	matchup, code, err := s.getAdvice(ctx, client, req, nil)
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("code = %d, err = %v, want the advice generated anyway", code, err)
+	}
+	if searcher.calls.Load() != 1 || len(matchup.Points) != 1 {
+		t.Errorf("got %+v after %d searches, want freshly generated advice", matchup, searcher.calls.Load())
+	}
+
+	// the write still goes through, for when reads recover
+	value, err := testRedis.Get(req.key)
+	if err != nil {
+		t.Fatalf("advice wasn't cached: %v", err)
+	}
+	if stored, err := decodeCachedMatchup(value); err != nil || stored.Advice != matchup.Advice {
+		t.Errorf("cached %+v, want %+v", stored, matchup)
+	}
+}
+
+func TestMatchupRequestKeyIncludesLanguage(t *testing.T) {
+	withTestConfig(t)
+	req := newMatchupRequest(context.Background(), luxZed)
+
+	if got := req.inLanguage("en"); got.key != req.key || got.lang != "" {
+		t.Errorf("english key = %q lang %q, want the plain key %q", got.key, got.lang, req.key)
+	}
+	es, fr := req.inLanguage("es"), req.inLanguage("fr")
+	if es.key != req.key+":es" || es.lang != "es" {
+		t.Errorf("spanish key = %q lang %q, want %q", es.key, es.lang, req.key+":es")
+	}
+	if es.key == fr.key {
+		t.Errorf("spanish and french share the key %q", es.key)
+	}
+}
+
+func TestMatchupHandlerCachesPerLanguage(t *testing.T) {
+	withTestConfig(t)
+	s, _, _ := newTestService(thread("aaa111"))
+	key := newMatchupRequest(context.Background(), luxZed).key
+
+	w := httptest.NewRecorder()
+	s.MatchupHandler(w, httptest.NewRequest(http.MethodGet, "/api/matchup?champ=lux&opp=zed&role=mid&lang=ES", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("code = %d: %s", w.Code, w.Body)
+	}
+	if !testRedis.Exists(key + ":es") {
+		t.Errorf("spanish advice isn't cached under %q, keys: %v", key+":es", testRedis.Keys())
+	}
+	if testRedis.Exists(key) {
+		t.Errorf("spanish advice was cached under the english key %q", key)
+	}
+
+	w = httptest.NewRecorder()
+	s.MatchupHandler(w, httptest.NewRequest(http.MethodGet, "/api/matchup?champ=lux&opp=zed&role=mid&lang=xx", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unsupported language: code = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	var body struct {
+		Supported []string `json:"supported"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Supported) != len(summarize.OutputLanguages) {
+		t.Errorf("supported = %v, want every output language", body.Supported)
+	}
+}
+
+func TestTruncateAdvice(t *testing.T) {
+	point := models.AdvicePoint{Text: "Lux should respect the level 2", Sources: []string{"a"}}
+	multiLine := models.AdvicePoint{Text: "Hold E for his W shadow\nthen Q him as he walks up", Sources: []string{"b"}}
+	lineLen := len(summarize.FormatPoints([]models.AdvicePoint{point}))
+	noteRoom := len(truncatedNote) + 2
+	repeat := func(p models.AdvicePoint, n int) []models.AdvicePoint {
+		points := make([]models.AdvicePoint, n)
+		for i := range points {
+			points[i] = p
+		}
+		return points
+	}
+	truncated := func(points ...models.AdvicePoint) string {
+		return summarize.FormatPoints(points) + "\n\n" + truncatedNote
+	}
+
+	tests := []struct {
+		name       string
+		points     []models.AdvicePoint
+		max        int
+		want       string
+		wantPoints []models.AdvicePoint
+	}{
+		{name: "no limit", points: repeat(point, 2), max: 0, wantPoints: repeat(point, 2)},
+		{name: "fits", points: repeat(point, 2), max: 1000, wantPoints: repeat(point, 2)},
+		{
+			name:       "drops whole points",
+			points:     repeat(point, 5),
+			max:        noteRoom + 2*lineLen + 1,
+			want:       truncated(point, point),
+			wantPoints: repeat(point, 2),
+		},
+		{
+			name:       "drops a point that only just overflows",
+			points:     repeat(point, 5),
+			max:        noteRoom + 2*lineLen,
+			want:       truncated(point),
+			wantPoints: repeat(point, 1),
+		},
+		{
+			name:       "keeps a multi-line point whole",
+			points:     []models.AdvicePoint{multiLine, point, point},
+			max:        noteRoom + len(summarize.FormatPoints([]models.AdvicePoint{multiLine, point})),
+			want:       truncated(multiLine, point),
+			wantPoints: []models.AdvicePoint{multiLine, point},
+		},
+		{
+			name:       "cuts a long first point at a word",
+			points:     []models.AdvicePoint{{Text: strings.Repeat("word ", 30)}, point},
+			max:        noteRoom + 12,
+			want:       truncated(models.AdvicePoint{Text: "word"}),
+			wantPoints: []models.AdvicePoint{{Text: "word"}},
+		},
+		{
+			name:       "doesn't split a character",
+			points:     []models.AdvicePoint{{Text: strings.Repeat("é", 50)}},
+			max:        noteRoom + 17,
+			want:       truncated(models.AdvicePoint{Text: strings.Repeat("é", 6)}),
+			wantPoints: []models.AdvicePoint{{Text: strings.Repeat("é", 6)}},
+		},
+		{
+			name:   "sources too long to cut to",
+			points: []models.AdvicePoint{{Text: "short", Sources: []string{strings.Repeat("a", 200)}}},
+			max:    noteRoom + 20,
+			want:   truncatedNote,
+		},
+		{name: "no room past the note", points: repeat(point, 2), max: 10, want: truncatedNote[:10]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			advice := summarize.FormatPoints(tt.points)
+			if tt.want == "" {
+				tt.want = advice
+			}
+
+			got, points := truncateAdvice(advice, tt.points, tt.max)
+			if got != tt.want {
+				t.Errorf("advice = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(points, tt.wantPoints) {
+				t.Errorf("points = %+v, want %+v", points, tt.wantPoints)
+			}
+			if tt.max > 0 && len(got) > tt.max {
+				t.Errorf("%d characters, want at most %d", len(got), tt.max)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("%q isn't valid UTF-8", got)
+			}
+		})
+	}
+}
+
+func TestGetAdviceCachesTruncatedAdvice(t *testing.T) {
+	c := withTestConfig(t)
+	s, _, _ := newTestService(thread("aaa111"), thread("bbb222"), thread("ccc333"), thread("ddd444"))
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	req := newMatchupRequest(ctx, luxZed)
+
+	// the advice as it'd be without a limit, one character too long for it
+	c.MaxAdviceChars = 0
+	full, _, err := computeAdvice(ctx, req, s.deps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MaxAdviceChars = len(full.Advice) - 1
+
+	matchup, code, err := s.getAdvice(ctx, rdb, req, nil)
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("code = %d, err = %v", code, err)
+	}
+	if len(matchup.Advice) > c.MaxAdviceChars || !strings.HasSuffix(matchup.Advice, truncatedNote) {
+		t.Errorf("advice = %q, want at most %d characters ending in the note", matchup.Advice, c.MaxAdviceChars)
+	}
+	if len(matchup.Points) == 0 || len(matchup.Points) >= len(full.Points) {
+		t.Errorf("kept %d of %d points, want the last ones dropped", len(matchup.Points), len(full.Points))
+	}
+	if want := summarize.FormatPoints(matchup.Points) + "\n\n" + truncatedNote; matchup.Advice != want {
+		t.Errorf("advice = %q, want it rendered from the kept points %q", matchup.Advice, want)
+	}
+
+	value, err := testRedis.Get(req.key)
+	if err != nil {
+		t.Fatalf("advice wasn't cached: %v", err)
+	}
+	stored, err := decodeCachedMatchup(value)
+	if err != nil || !reflect.DeepEqual(stored, matchup) {
+		t.Errorf("cached %+v, want the returned %+v", stored, matchup)
+	}
+}
+
+func TestMatchupHandlerRefreshWithoutAdminToken(t *testing.T) {
+	const query = "/api/matchup?champ=lux&opp=zed&role=mid&refresh=true"
+	serve := func(s *matchupService, admin bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, query, nil)
+		if admin {
+			r.Header.Set(adminTokenHeader, testAdminToken)
+		}
+		w := httptest.NewRecorder()
+		s.MatchupHandler(w, r)
+		return w
+	}
+
+	t.Run("negative result is regenerated", func(t *testing.T) {
+		c := withTestConfig(t)
+		s, searcher, _ := newTestService(thread("aaa111"))
+		rdb, err := ensureRedis()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := newMatchupRequest(context.Background(), luxZed)
+		if _, err := setCachedMatchup(context.Background(), rdb, req.key, models.CachedMatchup{Advice: noAdviceMessage}); err != nil {
+			t.Fatal(err)
+		}
+
+		if w := serve(s, false); w.Code != http.StatusOK {
+			t.Fatalf("code = %d: %s", w.Code, w.Body)
+		}
+		if searcher.calls.Load() != 1 {
+			t.Errorf("searched %d times, want the matchup regenerated", searcher.calls.Load())
+		}
+		value, err := testRedis.Get(req.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored, err := decodeCachedMatchup(value); err != nil || isNegative(stored) {
+			t.Errorf("cached %q, want the new advice in place of the negative result", stored.Advice)
+		}
+		if ttl := testRedis.TTL(req.key); ttl != c.CacheTTL {
+			t.Errorf("new advice cached for %s, want CACHE_TTL %s", ttl, c.CacheTTL)
+		}
+	})
+
+	t.Run("advice needs the admin token", func(t *testing.T) {
+		withTestConfig(t)
+		s, searcher, _ := newTestService(thread("aaa111"))
+		cacheLuxZed(t, time.Hour)
+
+		if w := serve(s, false); w.Code != http.StatusForbidden {
+			t.Errorf("code = %d, want %d", w.Code, http.StatusForbidden)
+		}
+		if searcher.calls.Load() != 0 {
+			t.Errorf("searched %d times, want the cached advice left alone", searcher.calls.Load())
+		}
+
+		if w := serve(s, true); w.Code != http.StatusOK {
+			t.Errorf("admin refresh: code = %d: %s", w.Code, w.Body)
+		}
+		if searcher.calls.Load() != 1 {
+			t.Errorf("searched %d times, want the admin's refresh to regenerate", searcher.calls.Load())
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/logging"
+	"server/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// sorted set of request counts per matchup, across patches
+const popularKey = "popular_matchups"
+
+const (
+	defaultPopularLimit = 10
+	maxPopularLimit     = 100
+)
+
+// popularMatchup is one entry in the most requested list
+type popularMatchup struct {
+	Champion string `json:"champ"`
+	Opponent string `json:"opp"`
+	Role     string `json:"role"`
+	Requests int64  `json:"requests"`
+}
+
+// popularMember names a matchup in the counter. It's separate from the cache
+// key so counts carry over between patches, and uses a delimiter that can't
+// appear in a champion or role name so it can be split back apart.
+func popularMember(q models.Query) string {
+	return q.Champion + "/" + q.Opponent + "/" + q.Role
+}
+
+// recordRequest counts a request for a canonically oriented matchup. It runs
+// in the background since nothing waits on it, and a failure only costs one
+// count.
+func recordRequest(ctx context.Context, rdb *redis.Client, q models.Query) {
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+
+		if err := rdb.ZIncrBy(ctx, popularKey, 1, popularMember(q)).Err(); err != nil {
+			logging.FromContext(ctx).Warn("failed to count matchup request", "error", err)
+		}
+	}()
+}
+
+// PopularHandler returns the most requested matchups, most requested first,
+// to help pick which ones are worth warming
+func PopularHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonResponse(w, http.StatusMethodNotAllowed, map[string]string{"error": fmt.Sprintf("Method %s not allowed", r.Method)})
+		return
+	}
+
+	limit := defaultPopularLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPopularLimit {
+			jsonResponse(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("limit must be between 1 and %d", maxPopularLimit)})
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		jsonResponse(w, http.StatusServiceUnavailable, map[string]string{"error": "Redis client not initialized"})
+		return
+	}
+
+	counts, err := rdb.ZRevRangeWithScores(ctx, popularKey, 0, int64(limit-1)).Result()
+	if err != nil {
+		jsonResponse(w, http.StatusBadGateway, map[string]string{"error": fmt.Sprintf("Redis error: %s", err)})
+		return
+	}
+
+	matchups := []popularMatchup{}
+	for _, count := range counts {
+		member, _ := count.Member.(string)
+		parts := strings.Split(member, "/")
+		if len(parts) != 3 {
+			continue
+		}
+		matchups = append(matchups, popularMatchup{
+			Champion: parts[0],
+			Opponent: parts[1],
+			Role:     parts[2],
+			Requests: int64(count.Score),
+		})
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"matchups": matchups})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitError is returned when a client has used up its compute budget
+type rateLimitError struct {
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("Too many requests, retry in %d seconds", retryAfterSeconds(e.retryAfter))
+}
+
+func retryAfterSeconds(d time.Duration) int {
+	return max(int(math.Ceil(d.Seconds())), 1)
+}
+
+type visitor struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ipLimiter hands out a token bucket per client ip. Only cache misses draw
+// from it, cache hits are cheap and never limited.
+type ipLimiter struct {
+	mu       sync.Mutex
+	visitors map[string]*visitor
+	rps      rate.Limit
+	burst    int
+	// closed by stop to end the cleanup loop
+	done chan struct{}
+}
+
+// forget clients that haven't been seen in a while so the map doesn't grow forever
+const visitorIdleTimeout = 10 * time.Minute
+
+// set up in configure, once .env has been loaded
+var limiter *ipLimiter
+
+// newIPLimiter hands out rps tokens a second with bursts of up to burst per
+// client (RATE_LIMIT_RPS and RATE_LIMIT_BURST). An RPS of 0 disables limiting.
+func newIPLimiter(rps float64, burst int) *ipLimiter {
+	l := &ipLimiter{
+		visitors: make(map[string]*visitor),
+		rps:      rate.Limit(rps),
+		burst:    burst,
+		done:     make(chan struct{}),
+	}
+
+	go l.cleanup()
+
+	return l
+}
+
+// allow takes a token for ip, returning how long to wait if there isn't one
+func (l *ipLimiter) allow(ip string) (bool, time.Duration) {
+	if l.rps == 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	v, ok := l.visitors[ip]
+	if !ok {
+		v = &visitor{limiter: rate.NewLimiter(l.rps, l.burst)}
+		l.visitors[ip] = v
+	}
+	v.lastSeen = time.Now()
+	l.mu.Unlock()
+
+	reservation := v.limiter.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		// don't hold the token, the client is being turned away
+		reservation.Cancel()
+		return false, delay
+	}
+
+	return true, 0
+}
+
+// stop ends the cleanup loop, for when configure replaces the limiter
+func (l *ipLimiter) stop() {
+	close(l.done)
+}
+
+func (l *ipLimiter) cleanup() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.done:
+			return
+		}
+
+		l.mu.Lock()
+		for ip, v := range l.visitors {
+			if time.Since(v.lastSeen) > visitorIdleTimeout {
+				delete(l.visitors, ip)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// clientIP returns the caller's address. Behind a load balancer
+// (TRUST_PROXY=true) that's the last X-Forwarded-For entry, the one the load
+// balancer appended. Anything before it was sent by the client and could be
+// made up to get a fresh rate limit on every request.
+func clientIP(r *http.Request) string {
+	if cfg.TrustProxy {
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			entries := strings.Split(values[len(values)-1], ",")
+			if ip := strings.TrimSpace(entries[len(entries)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trustProxy bool
+		forwarded  []string
+		want       string
+	}{
+		{name: "direct", want: "192.0.2.1"},
+		{name: "forwarded header ignored without TRUST_PROXY", forwarded: []string{"203.0.113.7"}, want: "192.0.2.1"},
+		{name: "load balancer's entry", trustProxy: true, forwarded: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "spoofed entries before it", trustProxy: true, forwarded: []string{"10.9.8.7, 198.51.100.2, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "split across headers", trustProxy: true, forwarded: []string{"10.9.8.7", "203.0.113.7"}, want: "203.0.113.7"},
+		{name: "empty last entry", trustProxy: true, forwarded: []string{"10.9.8.7, "}, want: "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := withTestConfig(t)
+			c.TrustProxy = tt.trustProxy
+
+			r := httptest.NewRequest(http.MethodGet, "/api/matchup", nil)
+			r.RemoteAddr = "192.0.2.1:52100"
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpoofedForwardedForIsStillLimited(t *testing.T) {
+	withTestConfig(t, "TRUST_PROXY", "true", "RATE_LIMIT_RPS", "0.001", "RATE_LIMIT_BURST", "1")
+	s, _, _ := newTestService(thread("aaa111"))
+
+	// a different made up address each time, in front of the load balancer's
+	serve := func(query, spoofed string) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/matchup?"+query, nil)
+		r.Header.Set("X-Forwarded-For", spoofed+", 203.0.113.7")
+		w := httptest.NewRecorder()
+		s.MatchupHandler(w, r)
+		return w.Code
+	}
+
+	if code := serve("champ=lux&opp=zed&role=mid", "10.0.0.1"); code != http.StatusOK {
+		t.Fatalf("first request: code = %d, want %d", code, http.StatusOK)
+	}
+	// another cache miss, so it has to be generated and draws from the limit
+	if code := serve("champ=lux&opp=ahri&role=mid", "10.0.0.2"); code != http.StatusTooManyRequests {
+		t.Errorf("second request: code = %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestConfigureStopsTheReplacedLimiter(t *testing.T) {
+	// without the l1 cache, whose expiry goroutine the lru package never stops
+	c := withTestConfig(t, "L1_CACHE_SIZE", "0")
+
+	before := runtime.NumGoroutine()
+	const reloads = 50
+	for range reloads {
+		configure(c)
+	}
+
+	// the replaced limiters' cleanup loops exit soon after, not necessarily
+	// by the time configure returns
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines after %d reloads, up from %d", n, reloads, before)
+	}
+}
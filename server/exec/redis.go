@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	rdb     *redis.Client
+	redisMu sync.Mutex
+)
+
+// how long initRedis waits on redis to answer. A var so tests don't have to
+// wait on it.
+var redisDialTimeout = 5 * time.Second
+
+func initRedis() (*redis.Client, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr: cfg.RedisEndpoint,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisDialTimeout)
+	defer cancel()
+
+	_, err := client.Ping(ctx).Result()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %v", err)
+	}
+
+	return client, nil
+}
+
+// ensureRedis returns the shared Redis client, lazily connecting if startup
+// (or the background reconnect loop) hasn't managed to yet. The connection is
+// made without holding redisMu, so while redis is down requests time out side
+// by side instead of queueing up behind each other's dial.
+func ensureRedis() (*redis.Client, error) {
+	redisMu.Lock()
+	client := rdb
+	redisMu.Unlock()
+	if client != nil {
+		return client, nil
+	}
+
+	client, err := initRedis()
+	if err != nil {
+		return nil, err
+	}
+
+	redisMu.Lock()
+	defer redisMu.Unlock()
+	if rdb != nil {
+		// another request connected first, keep the one everyone's using
+		client.Close()
+		return rdb, nil
+	}
+	rdb = client
+
+	return rdb, nil
+}
+
+// the wait before reconnectRedis first retries, doubled after every failure up
+// to maxRedisBackoff. A var so tests don't have to wait on it.
+var redisBackoff = time.Second
+
+const maxRedisBackoff = 30 * time.Second
+
+// reconnectRedis keeps retrying the connection with exponential backoff until
+// it succeeds, so a Redis outage at boot doesn't require a restart
+func reconnectRedis() {
+	backoff := redisBackoff
+
+	for {
+		time.Sleep(backoff)
+
+		_, err := ensureRedis()
+		if err == nil {
+			slog.Info("connected to redis")
+			return
+		}
+
+		backoff = min(backoff*2, maxRedisBackoff)
+		slog.Warn("redis reconnect failed", "retry_in", backoff, "error", err)
+	}
+}
+
+func closeRedis() error {
+	redisMu.Lock()
+	defer redisMu.Unlock()
+
+	if rdb == nil {
+		return nil
+	}
+
+	err := rdb.Close()
+	rdb = nil
+	return err
+}
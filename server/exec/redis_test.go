@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyRedis listens in front of testRedis, hanging up on the first down
+// connections as if redis were still starting, then proxying the rest
+type flakyRedis struct {
+	net.Listener
+	down  atomic.Int32
+	conns atomic.Int32
+}
+
+func startFlakyRedis(t *testing.T, down int32) *flakyRedis {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &flakyRedis{Listener: l}
+	f.down.Store(down)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if f.conns.Add(1) <= f.down.Load() {
+				conn.Close()
+				continue
+			}
+			go f.proxy(conn)
+		}
+	}()
+	return f
+}
+
+func (f *flakyRedis) proxy(conn net.Conn) {
+	defer conn.Close()
+	upstream, err := net.Dial("tcp", testRedis.Addr())
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func TestReconnectRedisWaitsForRedis(t *testing.T) {
+	const down = 20
+	flaky := startFlakyRedis(t, down)
+	withTestConfig(t, "REDIS_ENDPOINT", flaky.Addr().String())
+
+	oldBackoff := redisBackoff
+	redisBackoff = time.Millisecond
+	t.Cleanup(func() { redisBackoff = oldBackoff })
+
+	if _, err := ensureRedis(); err == nil {
+		t.Fatal("connected to redis while it was down")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		reconnectRedis()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("still not connected after %d connections", flaky.conns.Load())
+	}
+
+	if n := flaky.conns.Load(); n <= down {
+		t.Errorf("connected after %d connections, redis was down for %d", n, down)
+	}
+
+	client, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRedis.Set("reconnected", "yes")
+	if got, err := client.Get(context.Background(), "reconnected").Result(); err != nil || got != "yes" {
+		t.Errorf("GET through the reconnected client = %q, %v", got, err)
+	}
+}
+
+func TestMatchupHandlerWhileRedisIsDown(t *testing.T) {
+	flaky := startFlakyRedis(t, 1<<30)
+	withTestConfig(t, "REDIS_ENDPOINT", flaky.Addr().String())
+	s, _, _ := newTestService()
+
+	w := httptest.NewRecorder()
+	s.MatchupHandler(w, httptest.NewRequest(http.MethodGet, "/api/matchup?champ=lux&opp=zed&role=mid", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("code = %d with redis down, want %d: %s", w.Code, http.StatusServiceUnavailable, w.Body)
+	}
+
+	// the next request connects lazily once redis is back
+	flaky.down.Store(0)
+	w = httptest.NewRecorder()
+	s.MatchupHandler(w, httptest.NewRequest(http.MethodGet, "/api/matchup?champ=lux&opp=zed&role=mid", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("code = %d with redis back, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+}
+
+func TestEnsureRedisDialsOutsideTheLock(t *testing.T) {
+	// accepts connections but never answers, like a redis that's hung
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(io.Discard, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	withTestConfig(t, "REDIS_ENDPOINT", l.Addr().String())
+
+	const timeout = 200 * time.Millisecond
+	oldTimeout := redisDialTimeout
+	redisDialTimeout = timeout
+	t.Cleanup(func() { redisDialTimeout = oldTimeout })
+
+	const callers = 5
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ensureRedis(); err == nil {
+				t.Error("connected to a redis that never answers")
+			}
+		}()
+	}
+
+	// nothing else waits on the dials either
+	time.Sleep(timeout / 4)
+	closed := time.Now()
+	if err := closeRedis(); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(closed); waited > timeout/2 {
+		t.Errorf("closeRedis waited %s for the dials in progress", waited)
+	}
+
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > callers*timeout/2 {
+		t.Errorf("%d requests took %s with a %s dial timeout, want them to time out side by side", callers, elapsed, timeout)
+	}
+}
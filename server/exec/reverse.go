@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"server/logging"
+	"server/models"
+	"server/summarize"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// appended to a matchup's key for its advice rewritten from the other side
+const reversedSuffix = "~reversed"
+
+// orient returns matchup from the side the client asked for along with the
+// query it's for, when REWRITE_REVERSED is on. The rewritten advice is cached
+// under its own key, and if it can't be made the canonical advice and query
+// are returned unchanged.
+func (s *matchupService) orient(ctx context.Context, rdb *redis.Client, req matchupRequest, matchup models.CachedMatchup) (models.CachedMatchup, models.Query) {
+	q := req.query
+	if !req.reversed || !cfg.RewriteReversed || matchup.Advice == noAdviceMessage {
+		return matchup, q
+	}
+
+	// req.key already ends in the language, so rewrites into different
+	// languages are flown and cached apart
+	key := req.key + reversedSuffix
+	reversed := models.Query{Champion: q.Opponent, Opponent: q.Champion, Role: q.Role, Patch: q.Patch}
+
+	if !req.refresh {
+		if cached, err := getCachedMatchup(ctx, rdb, key); err == nil {
+			return cached, reversed
+		}
+	}
+
+	// far cheaper than a pipeline run, but still a bedrock call
+	if !req.unlimited {
+		if ok, _ := limiter.allow(req.clientIP); !ok {
+			return matchup, q
+		}
+	}
+
+	res, err, _ := inflight.Do(key, func() (interface{}, error) {
+		rewriteCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cfg.SourceTimeout)
+		defer cancel()
+
+		result, err := s.deps.summarizer.RewritePerspective(rewriteCtx, matchup.Advice, reversed.Champion, reversed.Opponent, req.lang)
+		logUsage(ctx, result.Usage)
+		if err != nil {
+			return nil, err
+		}
+
+		rewritten := models.CachedMatchup{
+			Advice:      result.Summary,
+			Points:      result.Points,
+			Sources:     matchup.Sources,
+			GeneratedAt: time.Now().Unix(),
+			Patch:       matchup.Patch,
+			Models:      matchup.Models,
+		}
+		return cacheGenerated(rewriteCtx, rdb, key, rewritten, req.refresh), nil
+	})
+	if err != nil {
+		if !errors.Is(err, summarize.ErrIrrelevantSource) {
+			logging.FromContext(ctx).Warn("couldn't rewrite reversed matchup, serving the canonical side", "key", key, "error", err)
+		}
+		return matchup, q
+	}
+
+	return res.(models.CachedMatchup), reversed
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"server/models"
+)
+
+func TestOrientRewritesReversedMatchups(t *testing.T) {
+	withTestConfig(t, "REWRITE_REVERSED", "true")
+	s, _, summarizer := newTestService()
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// asked from Zed's side, the advice is generated for Lux
+	req := newMatchupRequest(ctx, models.Query{Champion: "Zed", Opponent: "Lux", Role: "mid"})
+	canonical := models.CachedMatchup{Advice: "• Lux should respect Zed's level 2 [Sources: [a]]", Sources: []string{"a"}}
+
+	matchup, q := s.orient(ctx, rdb, req, canonical)
+	if q.Champion != "Zed" || q.Opponent != "Lux" {
+		t.Errorf("oriented to %s vs %s, want Zed vs Lux", q.Champion, q.Opponent)
+	}
+	if matchup.Advice != "• Zed should punish Lux [Sources: [rewrite]]" || len(matchup.Points) != 1 {
+		t.Errorf("got %+v, want the rewritten advice", matchup)
+	}
+	if !testRedis.Exists(req.key + reversedSuffix) {
+		t.Error("the rewrite wasn't cached")
+	}
+
+	// the cached rewrite is served from then on
+	s.orient(ctx, rdb, req, canonical)
+	if summarizer.calls.Load() != 1 {
+		t.Errorf("rewrote %d times, want once", summarizer.calls.Load())
+	}
+}
+
+func TestOrientRateLimit(t *testing.T) {
+	withTestConfig(t, "REWRITE_REVERSED", "true", "RATE_LIMIT_RPS", "0.001", "RATE_LIMIT_BURST", "1")
+	s, _, summarizer := newTestService()
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	canonical := models.CachedMatchup{Advice: "• advice [Sources: [a]]", Sources: []string{"a"}}
+
+	reversedRequest := func(champion string, unlimited bool) matchupRequest {
+		req := newMatchupRequest(ctx, models.Query{Champion: champion, Opponent: "Ahri", Role: "mid"})
+		req.clientIP, req.unlimited = "203.0.113.7", unlimited
+		if !req.reversed {
+			t.Fatalf("%s vs Ahri isn't reversed", champion)
+		}
+		return req
+	}
+
+	// unlimited rewrites leave the client's one token alone
+	for _, champion := range []string{"Zed", "Yasuo"} {
+		if _, q := s.orient(ctx, rdb, reversedRequest(champion, true), canonical); q.Champion != champion {
+			t.Errorf("unlimited %s vs Ahri wasn't rewritten", champion)
+		}
+	}
+	if _, q := s.orient(ctx, rdb, reversedRequest("Syndra", false), canonical); q.Champion != "Syndra" {
+		t.Error("the client's first rewrite was rate limited")
+	}
+
+	// once it's spent the canonical side is served instead
+	if _, q := s.orient(ctx, rdb, reversedRequest("Viktor", false), canonical); q.Champion != "Ahri" {
+		t.Errorf("oriented to %s, want the canonical side once rate limited", q.Champion)
+	}
+	if summarizer.calls.Load() != 3 {
+		t.Errorf("rewrote %d times, want 3", summarizer.calls.Load())
+	}
+}
+
+func TestOrientRewritesInTheRequestedLanguage(t *testing.T) {
+	withTestConfig(t, "REWRITE_REVERSED", "true")
+	s, _, summarizer := newTestService()
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	canonical := models.CachedMatchup{Advice: "• Lux debe respetar el nivel 2 de Zed [Sources: [a]]", Sources: []string{"a"}}
+
+	// asked for at the same time, neither language waits on the other's flight
+	langs := []string{"es", "fr"}
+	got := make([]models.CachedMatchup, len(langs))
+	var wg sync.WaitGroup
+	for i, lang := range langs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := newMatchupRequest(ctx, models.Query{Champion: "Zed", Opponent: "Lux", Role: "mid"}).inLanguage(lang)
+			got[i], _ = s.orient(ctx, rdb, req, canonical)
+		}()
+	}
+	wg.Wait()
+
+	for i, lang := range langs {
+		want := "• Zed should punish Lux (" + lang + ") [Sources: [rewrite]]"
+		if got[i].Advice != want {
+			t.Errorf("%s: advice = %q, want %q", lang, got[i].Advice, want)
+		}
+
+		req := newMatchupRequest(ctx, models.Query{Champion: "Zed", Opponent: "Lux", Role: "mid"}).inLanguage(lang)
+		if cached, err := getCachedMatchup(ctx, rdb, req.key+reversedSuffix); err != nil || cached.Advice != want {
+			t.Errorf("%s: cached rewrite = %q, %v, want %q", lang, cached.Advice, err, want)
+		}
+	}
+	if summarizer.calls.Load() != int32(len(langs)) {
+		t.Errorf("rewrote %d times, want once per language", summarizer.calls.Load())
+	}
+}
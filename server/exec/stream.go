@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"server/logging"
+	"server/metrics"
+	"server/models"
+)
+
+// writeEvent sends a single server-sent event and flushes it to the client
+func writeEvent(w http.ResponseWriter, flusher http.Flusher, event string, payload interface{}) {
+	data, _ := json.Marshal(payload)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	flusher.Flush()
+}
+
+// StreamHandler serves the same advice as MatchupHandler over server-sent
+// events, emitting a "summary" event per source as it finishes and a final
+// "done" event with the full advice. A client that disconnects stops
+// receiving events, the computation carries on for anyone else waiting on
+// the same matchup and is cancelled once nobody is.
+func (s *matchupService) StreamHandler(w http.ResponseWriter, r *http.Request) {
+	metrics.Requests.WithLabelValues("stream").Inc()
+
+	// bounded by the matchup compute budget
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.MatchupTimeout)
+	defer cancel()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Streaming not supported"})
+		return
+	}
+
+	rdb, err := ensureRedis()
+	if err != nil {
+		jsonResponse(w, http.StatusServiceUnavailable, map[string]string{"error": "Redis client not initialized"})
+		return
+	}
+
+	req, ok := matchupQuery(w, r)
+	if !ok {
+		return
+	}
+	q := req.query
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	matchup, _, err := s.getAdvice(ctx, rdb, req, func(summary string) {
+		writeEvent(w, flusher, "summary", map[string]string{"summary": summary, "perspective": q.Champion})
+	})
+	if err != nil {
+		if ctx.Err() == context.Canceled {
+			logging.FromContext(ctx).Info("client disconnected from stream", "key", req.key)
+			return
+		}
+		writeEvent(w, flusher, "error", map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeEvent(w, flusher, "done", models.MatchupResponse{
+		Advice:      matchup.Advice,
+		Perspective: q.Champion,
+		SourcesUsed: len(matchup.Sources),
+		Sources:     matchup.Sources,
+		Patch:       matchup.Patch,
+		GeneratedAt: generatedAt(matchup),
+		Models:      matchup.Models,
+	})
+}
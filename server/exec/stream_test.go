@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStreamDisconnectCancelsComputation(t *testing.T) {
+	withTestConfig(t)
+	s, _, summarizer := newTestService(thread("aaa111"))
+	summarizer.block = true
+
+	srv := httptest.NewServer(http.HandlerFunc(s.StreamHandler))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/matchup/stream?champ=lux&opp=zed&role=mid", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+		close(done)
+	}()
+	waitFor(t, "the summary to start", func() bool { return summarizer.calls.Load() > 0 })
+
+	// the only client hangs up, nobody is left to read the advice
+	cancel()
+	<-done
+	waitFor(t, "the computation to be cancelled", func() bool { return summarizer.cancelled.Load() == 1 })
+
+	// let it wind down before checking it didn't cache anything
+	key := newMatchupRequest(context.Background(), luxZed).key
+	inflight.Do(key, func() (interface{}, error) { return computeResult{}, nil })
+	if testRedis.Exists(key) {
+		t.Error("the abandoned computation was cached")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// set at build time by the Dockerfile, from its COMMIT build arg:
+//
+//	docker build --build-arg COMMIT=$(git rev-parse HEAD) .
+//
+// which builds with
+//
+//	go build -ldflags "-X main.commit=${COMMIT} -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" -o server ./exec
+var (
+	commit    string
+	buildTime string
+)
+
+// VersionHandler reports which build is running and the bedrock models it
+// summarizes with, GET /version, so a deploy can be checked from outside.
+// Without ldflags it falls back to the vcs info go build embeds from a git
+// checkout, and "unknown" when there's none of that either.
+func VersionHandler(w http.ResponseWriter, r *http.Request) {
+	version := map[string]interface{}{
+		"commit":          commit,
+		"build_time":      buildTime,
+		"go_version":      runtime.Version(),
+		"bedrock_region":  cfg.Bedrock.Region,
+		"model":           cfg.Bedrock.ModelID,
+		"qc_model":        cfg.Bedrock.QCModelID,
+		"fallback_models": append([]string{}, cfg.Bedrock.FallbackModelIDs...),
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && commit == "":
+				version["commit"] = setting.Value
+			case setting.Key == "vcs.time" && buildTime == "":
+				version["build_time"] = setting.Value
+			}
+		}
+	}
+
+	for _, key := range []string{"commit", "build_time"} {
+		if version[key] == "" {
+			version[key] = "unknown"
+		}
+	}
+
+	jsonResponse(w, http.StatusOK, version)
+}
@@ -0,0 +1,25 @@
+package logging
+
+import "testing"
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		secrets []string
+		want    string
+	}{
+		{"query string", "https://www.googleapis.com/customsearch/v1?q=lux&key=AIzaSy123&cx=abc", []string{"AIzaSy123"}, "https://www.googleapis.com/customsearch/v1?q=lux&key=REDACTED&cx=abc"},
+		{"escaped", "key=abc%2Fdef%2B1 failed", []string{"abc/def+1"}, "key=REDACTED failed"},
+		{"every occurrence", "abc then abc", []string{"abc"}, "REDACTED then REDACTED"},
+		{"several secrets", "id=one secret=two", []string{"one", "two"}, "id=REDACTED secret=REDACTED"},
+		{"empty secret", "nothing to hide", []string{""}, "nothing to hide"},
+		{"no secrets", "nothing to hide", nil, "nothing to hide"},
+	}
+
+	for _, tt := range tests {
+		if got := Redact(tt.s, tt.secrets...); got != tt.want {
+			t.Errorf("%s: Redact(%q) = %q, want %q", tt.name, tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Requests counts matchup requests by endpoint
+var Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "matchup_requests_total",
+	Help: "Matchup requests received, by endpoint.",
+}, []string{"endpoint"})
+
+// CacheLookups counts cache reads by result ("hit", "miss" or "error")
+var CacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "matchup_cache_lookups_total",
+	Help: "Matchup cache reads, by result.",
+}, []string{"result"})
+
+// StageDuration tracks how long each stage of generating advice takes
+var StageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "matchup_stage_duration_seconds",
+	Help:    "Time spent in each stage of generating advice.",
+	Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 45, 90},
+}, []string{"stage"})
+
+// SourceErrors counts failed calls to upstream services ("google", "brave", "reddit", "bedrock", "stats")
+var SourceErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "matchup_upstream_errors_total",
+	Help: "Failed calls to upstream services.",
+}, []string{"service"})
+
+// UnavailableSubreddits counts threads skipped because reddit won't show
+// their subreddit, by reason ("private", "banned", ...)
+var UnavailableSubreddits = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "matchup_unavailable_subreddits_total",
+	Help: "Threads skipped because their subreddit is private, banned or quarantined.",
+}, []string{"reason"})
+
+// SourcesUsed tracks how many sources went into each generated matchup
+var SourcesUsed = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "matchup_sources_used",
+	Help:    "Number of sources that contributed to generated advice.",
+	Buckets: prometheus.LinearBuckets(0, 1, 11),
+})
+
+// ObserveStage records the time since start against stage, meant to be deferred
+func ObserveStage(stage string, start time.Time) {
+	StageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
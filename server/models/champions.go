@@ -0,0 +1,269 @@
+package models
+
+import (
+	_ "embed"
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// same champion list the client uses for its combobox
+//
+//go:embed champions.json
+var championsJSON []byte
+
+type championEntry struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+}
+
+// community spellings that don't reduce to the display name on their own
+var championAliases = map[string]string{
+	"nunu":       "Nunu & Willump",
+	"renata":     "Renata Glasc",
+	"mundo":      "Dr. Mundo",
+	"monkeyking": "Wukong",
+	"jarvan":     "Jarvan IV",
+	"asol":       "Aurelion Sol",
+	"mf":         "Miss Fortune",
+	"tf":         "Twisted Fate",
+}
+
+// normalized key -> display name, e.g. "kaisa" -> "Kai'Sa"
+var champions = loadChampions()
+
+func loadChampions() map[string]string {
+	var entries []championEntry
+	if err := json.Unmarshal(championsJSON, &entries); err != nil {
+		panic("models: invalid embedded champions.json: " + err.Error())
+	}
+
+	names := make(map[string]string, len(entries)+len(championAliases))
+	for _, entry := range entries {
+		names[championKey(entry.Value)] = entry.Value
+	}
+	for alias, name := range championAliases {
+		names[championKey(alias)] = name
+	}
+
+	return names
+}
+
+// championKey lowercases a name and drops everything but letters and digits
+// so "Kai'Sa", "kai sa" and "kaisa" all compare equal
+func championKey(name string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// NormalizeChampion maps user input like "drmundo" to the canonical display
+// name ("Dr. Mundo"), reporting false if it isn't a known champion
+func NormalizeChampion(name string) (string, bool) {
+	canonical, ok := champions[championKey(name)]
+	return canonical, ok
+}
+
+func ValidChampion(name string) bool {
+	_, ok := NormalizeChampion(name)
+	return ok
+}
+
+// SearchSpellings returns the ways champion (a display name) is commonly
+// written in thread titles, display name first: "Kai'Sa" and "KaiSa", or
+// "Dr. Mundo" and "mundo". Search engines split words on punctuation, so an
+// exact phrase with the display name alone misses titles that write it run
+// together or by a nickname.
+func SearchSpellings(champion string) []string {
+	spellings := []string{champion}
+	if championKey(champion) == strings.ToLower(champion) {
+		// a single plain word is only ever written one way
+		return spellings
+	}
+
+	// an alias is how people actually shorten the longer names
+	var aliases []string
+	for alias, name := range championAliases {
+		if name == champion {
+			aliases = append(aliases, alias)
+		}
+	}
+	if len(aliases) > 0 {
+		sort.Strings(aliases)
+		return append(spellings, aliases[0])
+	}
+
+	compact := strings.NewReplacer("'", "", "’", "", ".", "").Replace(champion)
+	if compact != champion {
+		spellings = append(spellings, compact)
+	}
+	return spellings
+}
+
+// ClosestChampions returns up to n champion names ordered by edit distance
+// from name, for suggesting corrections to typos
+func ClosestChampions(name string, n int) []string {
+	key := championKey(name)
+
+	type candidate struct {
+		name     string
+		distance int
+	}
+
+	// aliases mean a champion can have several keys, keep the closest one
+	distances := make(map[string]int)
+	for k, champ := range champions {
+		d := levenshtein(key, k)
+		if prev, ok := distances[champ]; !ok || d < prev {
+			distances[champ] = d
+		}
+	}
+
+	candidates := make([]candidate, 0, len(distances))
+	for champ, d := range distances {
+		candidates = append(candidates, candidate{champ, d})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	n = min(len(candidates), n)
+	closest := make([]string, n)
+	for i := range closest {
+		closest[i] = candidates[i].name
+	}
+	return closest
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+// MentionsChampion reports whether text refers to champion (a display name)
+// by any spelling NormalizeChampion accepts, e.g. "Kaisa", "DrMundo" or
+// "mundo". Names are matched as whole words so "Vi" doesn't match "review".
+func MentionsChampion(text string, champion string) bool {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\'' && r != '’' && r != '.'
+	})
+
+	// the longest names ("Nunu & Willump", "Aurelion Sol") are two words
+	const maxWords = 2
+	for i := range words {
+		var key string
+		for j := i; j < len(words) && j < i+maxWords; j++ {
+			word := strings.TrimSuffix(strings.TrimSuffix(words[j], "'s"), "’s")
+			key += championKey(word)
+			if champions[key] == champion {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ChampionSuggestion is a champion matching a partially typed name
+type ChampionSuggestion struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+// SuggestChampions returns up to n champions for a search-as-you-type query,
+// best match first: exact names, then prefixes, then substrings, then close
+// misspellings of a prefix. Aliases count, so "mundo" finds Dr. Mundo.
+func SuggestChampions(query string, n int) []ChampionSuggestion {
+	q := championKey(query)
+
+	// lower is better, champions that don't match at all are left out
+	ranks := make(map[string]int)
+	for k, champ := range champions {
+		rank, ok := matchRank(q, k)
+		if !ok {
+			continue
+		}
+		if prev, seen := ranks[champ]; !seen || rank < prev {
+			ranks[champ] = rank
+		}
+	}
+
+	names := make([]string, 0, len(ranks))
+	for champ := range ranks {
+		names = append(names, champ)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if ranks[names[i]] != ranks[names[j]] {
+			return ranks[names[i]] < ranks[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	aliases := make(map[string][]string)
+	for alias, champ := range championAliases {
+		aliases[champ] = append(aliases[champ], alias)
+	}
+
+	n = min(len(names), n)
+	suggestions := make([]ChampionSuggestion, n)
+	for i := range suggestions {
+		champAliases := aliases[names[i]]
+		sort.Strings(champAliases)
+		if champAliases == nil {
+			champAliases = []string{}
+		}
+		suggestions[i] = ChampionSuggestion{Name: names[i], Aliases: champAliases}
+	}
+	return suggestions
+}
+
+// matchRank scores how well a champion key matches a query key
+func matchRank(q, key string) (int, bool) {
+	switch {
+	case q == "" || q == key:
+		return 0, true
+	case strings.HasPrefix(key, q):
+		return 1, true
+	case strings.Contains(key, q):
+		return 2, true
+	}
+
+	// allow a typo or two in what's been typed so far, once there's enough of
+	// it for that to mean anything
+	qr, kr := []rune(q), []rune(key)
+	if len(qr) < 3 || len(kr) < len(qr) {
+		return 0, false
+	}
+	if d := levenshtein(q, string(kr[:len(qr)])); d <= len(qr)/2 {
+		return 2 + d, true
+	}
+
+	return 0, false
+}
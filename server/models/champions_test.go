@@ -0,0 +1,64 @@
+package models
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMentionsChampion(t *testing.T) {
+	tests := []struct {
+		text     string
+		champion string
+		want     bool
+	}{
+		{"Lux vs Zed mid, how do I survive level 6?", "Zed", true},
+		{"how to play against zed", "Zed", true},
+		{"Zed's shadow always catches me", "Zed", true},
+		{"Kai'Sa vs Ezreal", "Kai'Sa", true},
+		{"kaisa build into ezreal", "Kai'Sa", true},
+		{"Dr. Mundo top is unkillable", "Dr. Mundo", true},
+		{"DrMundo vs Darius", "Dr. Mundo", true},
+		{"mundo goes where he pleases", "Dr. Mundo", true},
+		{"Aurelion Sol mid guide", "Aurelion Sol", true},
+		{"asol matchups tier list", "Aurelion Sol", true},
+		{"Nunu & Willump jungle clear", "Nunu & Willump", true},
+		// whole words only
+		{"patch review: what changed for mid", "Vi", false},
+		{"Zedd concert tonight", "Zed", false},
+		{"Lux vs Ahri mid", "Zed", false},
+		{"", "Lux", false},
+	}
+
+	for _, tt := range tests {
+		if got := MentionsChampion(tt.text, tt.champion); got != tt.want {
+			t.Errorf("MentionsChampion(%q, %q) = %v, want %v", tt.text, tt.champion, got, tt.want)
+		}
+	}
+}
+
+func TestSearchSpellings(t *testing.T) {
+	tests := []struct {
+		champion string
+		want     []string
+	}{
+		// one plain word
+		{"Lux", []string{"Lux"}},
+		// apostrophes are dropped
+		{"Kai'Sa", []string{"Kai'Sa", "KaiSa"}},
+		{"Vel'Koz", []string{"Vel'Koz", "VelKoz"}},
+		// periods too, unless there's an alias people use instead
+		{"Dr. Mundo", []string{"Dr. Mundo", "mundo"}},
+		// spaces are kept, with the alias where there is one
+		{"Lee Sin", []string{"Lee Sin"}},
+		{"Miss Fortune", []string{"Miss Fortune", "mf"}},
+		{"Aurelion Sol", []string{"Aurelion Sol", "asol"}},
+		// an ampersand only ever goes by the alias
+		{"Nunu & Willump", []string{"Nunu & Willump", "nunu"}},
+	}
+
+	for _, tt := range tests {
+		if got := SearchSpellings(tt.champion); !slices.Equal(got, tt.want) {
+			t.Errorf("SearchSpellings(%q) = %q, want %q", tt.champion, got, tt.want)
+		}
+	}
+}
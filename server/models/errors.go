@@ -0,0 +1,19 @@
+package models
+
+import "fmt"
+
+// UpstreamError is returned by the stage packages when google, brave, reddit
+// or bedrock fails, so handlers can tell a dependency being down (502) apart
+// from a bug on our side (500)
+type UpstreamError struct {
+	Service string
+	Err     error
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Service, e.Err)
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
@@ -4,6 +4,55 @@ type Query struct {
 	Champion string `json:"champ"`
 	Opponent string `json:"opp"`
 	Role     string `json:"role"`
+	// game patch the advice is for, set by the server rather than the client
+	Patch string `json:"-"`
+}
+
+// CachedMatchup is what gets stored in Redis for a matchup
+type CachedMatchup struct {
+	Advice      string   `json:"advice"`
+	Sources     []string `json:"sources"`
+	GeneratedAt int64    `json:"generated_at"` // unix seconds
+	Patch       string   `json:"patch"`
+	// bedrock models that summarized it, missing for older entries
+	Models []string `json:"models,omitempty"`
+	// the advice as the points the models recorded, missing for older
+	// entries and negative results
+	Points []AdvicePoint `json:"points,omitempty"`
+	// unix seconds when the redis entry expires, missing for older entries
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+type MatchupResponse struct {
+	Advice      string   `json:"advice"`
+	Perspective string   `json:"perspective"`
+	SourcesUsed int      `json:"sources_used"`
+	Sources     []string `json:"sources"`
+	Patch       string   `json:"patch,omitempty"`
+	// RFC3339, missing for advice cached before it was recorded
+	GeneratedAt string `json:"generated_at,omitempty"`
+	// the bedrock models that wrote the advice, more than one if some
+	// sources fell back from the primary
+	Models []string `json:"models,omitempty"`
+}
+
+// AdvicePoint is a single piece of matchup advice and the threads it came from
+type AdvicePoint struct {
+	Text    string   `json:"text"`
+	Sources []string `json:"sources"`
+}
+
+type AdviceResponse struct {
+	Points   []AdvicePoint `json:"points"`
+	Champion string        `json:"champion"`
+	Opponent string        `json:"opponent"`
+	Role     string        `json:"role"`
+	Patch    string        `json:"patch,omitempty"`
+	// RFC3339, missing for advice cached before it was recorded
+	GeneratedAt string `json:"generated_at,omitempty"`
+	// the bedrock models that wrote the advice, more than one if some
+	// sources fell back from the primary
+	Models []string `json:"models,omitempty"`
 }
 
 type SearchResponse struct {
@@ -0,0 +1,50 @@
+package models
+
+import "strings"
+
+// the five lanes roles are normalized to
+const (
+	RoleTop     = "top"
+	RoleJungle  = "jungle"
+	RoleMid     = "mid"
+	RoleBot     = "bot"
+	RoleSupport = "support"
+)
+
+// RoleAny is for flex picks that meet in more than one lane, advice for it
+// comes from threads about any of them
+const RoleAny = "any"
+
+var roleAliases = map[string]string{
+	"top":      RoleTop,
+	"toplane":  RoleTop,
+	"jg":       RoleJungle,
+	"jgl":      RoleJungle,
+	"jungle":   RoleJungle,
+	"jungler":  RoleJungle,
+	"mid":      RoleMid,
+	"middle":   RoleMid,
+	"midlane":  RoleMid,
+	"bot":      RoleBot,
+	"bottom":   RoleBot,
+	"botlane":  RoleBot,
+	"adc":      RoleBot,
+	"ad carry": RoleBot,
+	"sup":      RoleSupport,
+	"supp":     RoleSupport,
+	"support":  RoleSupport,
+	"any":      RoleAny,
+	"flex":     RoleAny,
+}
+
+// NormalizeRole maps common lane aliases ("jg", "adc", "supp") to one of the
+// five canonical roles, and "any", "flex" or no role at all to RoleAny,
+// reporting false for anything unrecognized
+func NormalizeRole(role string) (string, bool) {
+	if strings.TrimSpace(role) == "" {
+		return RoleAny, true
+	}
+
+	normalized, ok := roleAliases[strings.ToLower(strings.TrimSpace(role))]
+	return normalized, ok
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"net/url"
+	"strings"
+)
+
+// mainsWeight is the SUBREDDIT_WEIGHTS key for the advised champion's own
+// mains subreddit (r/<champion>mains), other champions' mains subs are
+// weighted like any other subreddit
+const mainsWeight = "mains"
+
+// how much a thread from each subreddit counts for when sources are ranked,
+// anything unlisted counts 1. The champion's own mains sub ranks first, its
+// regulars have played the matchup more than anyone.
+var defaultSubredditWeights = map[string]float64{
+	mainsWeight:       2,
+	"summonerschool":  1.5,
+	"leagueoflegends": 0.75,
+}
+
+var subredditWeights = defaultSubredditWeights
+
+// SetSubredditWeights sets the configured SUBREDDIT_WEIGHTS over the
+// defaults, it must be called before any source is ranked
+func SetSubredditWeights(overrides map[string]float64) {
+	weights := make(map[string]float64, len(defaultSubredditWeights)+len(overrides))
+	for name, weight := range defaultSubredditWeights {
+		weights[name] = weight
+	}
+	for name, weight := range overrides {
+		weights[strings.ToLower(name)] = weight
+	}
+	subredditWeights = weights
+}
+
+// SubredditWeight is how authoritative a subreddit is for advice on playing
+// champion (a display name, or empty when it doesn't matter). Sources are
+// ranked by it, and ones weighted 0 are dropped.
+func SubredditWeight(subreddit string, champion string) float64 {
+	name := strings.ToLower(subreddit)
+
+	if prefix, ok := strings.CutSuffix(name, "mains"); ok && champion != "" {
+		if mained, ok := NormalizeChampion(prefix); ok && mained == champion {
+			return subredditWeights[mainsWeight]
+		}
+	}
+
+	if weight, ok := subredditWeights[name]; ok {
+		return weight
+	}
+	return 1
+}
+
+// MainsSubreddit is the name of a champion's mains subreddit, e.g.
+// "kaisamains" for Kai'Sa
+func MainsSubreddit(champion string) string {
+	return championKey(champion) + "mains"
+}
+
+// LinkWeight is the SubredditWeight of the subreddit a reddit link or
+// permalink is in. Ones that don't say, like redd.it short links, count as
+// unlisted.
+func LinkWeight(link string, champion string) float64 {
+	u, err := url.Parse(link)
+	if err != nil {
+		return 1
+	}
+
+	segments := strings.FieldsFunc(u.Path, func(r rune) bool { return r == '/' })
+	if len(segments) < 2 || segments[0] != "r" {
+		return 1
+	}
+	return SubredditWeight(segments[1], champion)
+}
@@ -0,0 +1,53 @@
+package models
+
+import "testing"
+
+func TestSubredditWeight(t *testing.T) {
+	defer SetSubredditWeights(nil)
+
+	tests := []struct {
+		name      string
+		overrides map[string]float64
+		subreddit string
+		champion  string
+		want      float64
+	}{
+		{"champion's own mains sub ranks first", nil, "LuxMains", "Lux", 2},
+		{"summonerschool", nil, "summonerschool", "Lux", 1.5},
+		{"leagueoflegends", nil, "leagueoflegends", "Lux", 0.75},
+		{"unlisted", nil, "lolgaming", "Lux", 1},
+		{"another champion's mains sub", nil, "ZedMains", "Lux", 1},
+		{"mains sub without a champion", nil, "LuxMains", "", 1},
+		{"overridden", map[string]float64{"summonerschool": 3}, "SummonerSchool", "Lux", 3},
+		{"overridden mains", map[string]float64{"mains": 0.5}, "luxmains", "Lux", 0.5},
+		{"dropped", map[string]float64{"leagueoflegends": 0}, "leagueoflegends", "Lux", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetSubredditWeights(tt.overrides)
+			if got := SubredditWeight(tt.subreddit, tt.champion); got != tt.want {
+				t.Errorf("SubredditWeight(%q, %q) = %v, want %v", tt.subreddit, tt.champion, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkWeight(t *testing.T) {
+	tests := []struct {
+		link string
+		want float64
+	}{
+		{"https://www.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed/", 1.5},
+		{"https://old.reddit.com/r/LuxMains/comments/abc123/", 2},
+		{"/r/leagueoflegends/comments/abc123/lux_vs_zed/", 0.75},
+		{"https://redd.it/abc123", 1},
+		{"not a link", 1},
+	}
+
+	for _, tt := range tests {
+		if got := LinkWeight(tt.link, "Lux"); got != tt.want {
+			t.Errorf("LinkWeight(%q) = %v, want %v", tt.link, got, tt.want)
+		}
+	}
+}
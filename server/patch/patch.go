@@ -0,0 +1,113 @@
+package patch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"server/logging"
+)
+
+const versionsURL = "https://ddragon.leagueoflegends.com/api/versions.json"
+
+const (
+	// patches ship every two weeks, an hour is plenty fresh
+	refreshInterval = time.Hour
+	// don't hammer data dragon if it's down
+	retryInterval = time.Minute
+)
+
+// no data dragon lookups in mock mode
+var mockMode bool
+
+// Configure turns patch lookups off in mock mode, it must be called before
+// Current
+func Configure(mock bool) {
+	mockMode = mock
+}
+
+var current struct {
+	sync.Mutex
+	patch      string
+	checkedAt  time.Time
+	ok         bool
+	refreshing bool
+}
+
+// Current returns the live patch as "major.minor" (e.g. "14.20"), refreshed
+// from data dragon at most hourly. If data dragon can't be reached the last
+// known patch is returned, or "" if there's never been one. Only one caller
+// does the refresh, everyone else gets the last known patch meanwhile.
+func Current(ctx context.Context) string {
+	if mockMode {
+		return ""
+	}
+
+	current.Lock()
+	wait := refreshInterval
+	if !current.ok {
+		wait = retryInterval
+	}
+	if current.refreshing || time.Since(current.checkedAt) < wait {
+		patch := current.patch
+		current.Unlock()
+		return patch
+	}
+	current.refreshing = true
+	current.Unlock()
+
+	patch, err := fetch(ctx)
+
+	current.Lock()
+	defer current.Unlock()
+	current.refreshing = false
+	current.checkedAt = time.Now()
+	if err != nil {
+		logging.FromContext(ctx).Warn("couldn't fetch current patch", "error", err, "using", current.patch)
+		current.ok = false
+		return current.patch
+	}
+
+	current.patch, current.ok = patch, true
+	return patch
+}
+
+func fetch(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, versionsURL, http.NoBody)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	// newest first, e.g. ["14.20.1", "14.19.1", ...]
+	var versions []string
+	if err := json.NewDecoder(resp.Body).Decode(&versions); err != nil {
+		return "", fmt.Errorf("failed to decode versions: %v", err)
+	}
+	if len(versions) == 0 {
+		return "", fmt.Errorf("no versions listed")
+	}
+
+	parts := strings.Split(versions[0], ".")
+	if len(parts) < 2 {
+		return "", fmt.Errorf("unexpected version format %q", versions[0])
+	}
+
+	return parts[0] + "." + parts[1], nil
+}
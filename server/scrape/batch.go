@@ -0,0 +1,163 @@
+package scrape
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"server/logging"
+	"server/metrics"
+	"server/models"
+)
+
+// reddit's /api/info takes up to 100 fullnames per call
+const maxInfoIDs = 100
+
+// ScrapeBatch scrapes several threads with one token, looking all their posts
+// up in a single /api/info call first so removed or empty threads are dropped
+// before their comment trees are fetched. If that lookup fails every thread
+// is fetched anyway, the same as calling Scrape on each.
+//
+// posts[i] is the thread for items[i], left zero (with an empty Permalink) if
+// it couldn't be scraped. An error is only returned if none could.
+func ScrapeBatch(ctx context.Context, items []models.SearchItem) ([]Post, error) {
+	posts := make([]Post, len(items))
+
+	if mockMode() {
+		for i, item := range items {
+			data, err := mockScrape(item)
+			if err == nil {
+				json.Unmarshal(data, &posts[i])
+			}
+		}
+		return posts, nil
+	}
+
+	defer metrics.ObserveStage("scrape", time.Now())
+
+	type thread struct {
+		postID    string
+		subreddit string
+	}
+	threads := make([]thread, len(items))
+	var ids []string
+	for i, item := range items {
+		postID, subreddit, err := getPostInfo(item)
+		if err != nil {
+			logging.FromContext(ctx).Warn("skipping unscrapeable link", "link", item.Link, "error", err)
+			continue
+		}
+		threads[i] = thread{postID: postID, subreddit: subreddit}
+		ids = append(ids, postID)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("none of the %d links are reddit threads", len(items))
+	}
+
+	token, httpClient, err := getToken(ctx)
+	if err != nil {
+		metrics.SourceErrors.WithLabelValues("reddit").Inc()
+		return nil, &models.UpstreamError{Service: "reddit", Err: fmt.Errorf("error getting token: %w", err)}
+	}
+
+	skip, err := unusablePosts(ctx, httpClient, token, ids)
+	if err != nil {
+		logging.FromContext(ctx).Warn("post lookup failed, scraping every thread", "error", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(items))
+	for i, t := range threads {
+		if t.postID == "" {
+			errs[i] = ErrBadURL
+			continue
+		}
+		if skip[t.postID] {
+			errs[i] = fmt.Errorf("post %s is removed or empty", t.postID)
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, t thread) {
+			defer wg.Done()
+
+			post, err := scrapeThread(ctx, httpClient, token, t.postID, t.subreddit)
+			if err != nil {
+				logging.FromContext(ctx).Warn("scraping failed", "link", items[i].Link, "error", err)
+				errs[i] = err
+				return
+			}
+			posts[i] = *post
+		}(i, t)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err == nil {
+			return posts, nil
+		}
+	}
+	return nil, fmt.Errorf("none of the %d threads could be scraped, last error: %w", len(items), errs[len(errs)-1])
+}
+
+// unusablePosts looks the posts up in one /api/info call and returns the ids
+// of those that were removed or have neither a body nor comments
+func unusablePosts(ctx context.Context, httpClient *http.Client, token TokenResponse, ids []string) (map[string]bool, error) {
+	fullnames := make([]string, 0, min(len(ids), maxInfoIDs))
+	for _, id := range ids[:min(len(ids), maxInfoIDs)] {
+		fullnames = append(fullnames, "t3_"+id)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://oauth.reddit.com/api/info?id="+strings.Join(fullnames, ","), http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("couldnt make request: %w", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+
+	response, err := doReddit(ctx, httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, statusError(response.StatusCode, "looking up posts")
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	var listing struct {
+		Data struct {
+			Children []struct {
+				Data map[string]interface{} `json:"data"`
+			} `json:"children"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &listing); err != nil {
+		return nil, fmt.Errorf("couldnt unmarshall json: %w", err)
+	}
+
+	skip := make(map[string]bool)
+	for _, child := range listing.Data.Children {
+		id, err := getString(child.Data, "id")
+		if err != nil {
+			continue
+		}
+
+		removed := child.Data["removed_by_category"] != nil
+		numComments, _ := getInt(child.Data, "num_comments")
+		if removed || (numComments == 0 && postBody(child.Data) == "") {
+			skip[id] = true
+		}
+	}
+
+	return skip, nil
+}
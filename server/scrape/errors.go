@@ -0,0 +1,101 @@
+package scrape
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrBadURL is returned for links that aren't reddit threads
+	ErrBadURL = errors.New("not a reddit thread link")
+	// ErrRedditAuth means reddit rejected our credentials or token, which will
+	// fail every scrape until fixed rather than just the one thread
+	ErrRedditAuth = errors.New("reddit rejected our credentials")
+	// ErrRedditRateLimited is matched by any RateLimitedError as well as a 429
+	ErrRedditRateLimited = errors.New("reddit rate limit reached")
+	// ErrPostNotFound means the thread is gone, only that one source is lost
+	ErrPostNotFound = errors.New("reddit post not found")
+	// ErrPostForbidden means reddit won't show us the thread (removed, or
+	// private to its author), only that one source is lost
+	ErrPostForbidden = errors.New("reddit post is forbidden")
+	// ErrSubredditUnavailable is matched by any SubredditUnavailableError
+	ErrSubredditUnavailable = errors.New("subreddit is unavailable")
+)
+
+// SubredditUnavailableError is returned for a thread in a subreddit reddit
+// won't show us, one that's gone private, been banned or quarantined. Only
+// that source is lost, our credentials are fine.
+type SubredditUnavailableError struct {
+	// reddit's reason, lowercased, e.g. "private" or "banned"
+	Reason string
+}
+
+func (e *SubredditUnavailableError) Error() string {
+	return fmt.Sprintf("subreddit is unavailable: %s", e.Reason)
+}
+
+func (e *SubredditUnavailableError) Is(target error) bool {
+	return target == ErrSubredditUnavailable
+}
+
+// reasons reddit gives for refusing a subreddit's content
+var subredditReasons = map[string]bool{
+	"private":              true,
+	"banned":               true,
+	"quarantined":          true,
+	"gold_only":            true,
+	"subreddit_notallowed": true,
+}
+
+// subredditError reads the JSON error body reddit sends with a 403 or 404,
+// either {"reason": "private", ...} or the older
+// {"json": {"errors": [["SUBREDDIT_NOTALLOWED", ...]]}}, returning a
+// SubredditUnavailableError if it's about the subreddit and nil otherwise
+func subredditError(body []byte) *SubredditUnavailableError {
+	var redditErr struct {
+		Reason string `json:"reason"`
+		JSON   struct {
+			Errors [][]interface{} `json:"errors"`
+		} `json:"json"`
+	}
+	if err := json.Unmarshal(body, &redditErr); err != nil {
+		return nil
+	}
+
+	reasons := []string{redditErr.Reason}
+	for _, e := range redditErr.JSON.Errors {
+		if len(e) > 0 {
+			if code, ok := e[0].(string); ok {
+				reasons = append(reasons, code)
+			}
+		}
+	}
+
+	for _, reason := range reasons {
+		if reason = strings.ToLower(reason); subredditReasons[reason] {
+			return &SubredditUnavailableError{Reason: reason}
+		}
+	}
+	return nil
+}
+
+// statusError describes a non-200 from reddit while doing action, matching
+// one of the errors above where the status says which it is. A 403 is about
+// the thing asked for, not our token, which reddit answers with a 401.
+func statusError(code int, action string) error {
+	switch code {
+	case http.StatusUnauthorized:
+		return fmt.Errorf("%w: status %d when %s", ErrRedditAuth, code, action)
+	case http.StatusForbidden:
+		return fmt.Errorf("%w: status %d when %s", ErrPostForbidden, code, action)
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: status %d when %s", ErrPostNotFound, code, action)
+	case http.StatusTooManyRequests:
+		return fmt.Errorf("%w: status %d when %s", ErrRedditRateLimited, code, action)
+	default:
+		return fmt.Errorf("unexpected status code when %s: %d", action, code)
+	}
+}
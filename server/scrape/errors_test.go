@@ -0,0 +1,129 @@
+package scrape
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"server/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// redditErrorClient answers every request with status and the body in
+// testdata/reddit_errors/fixture
+func redditErrorClient(t *testing.T, status int, fixture string) *http.Client {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", "reddit_errors", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(string(body))),
+			Request:    r,
+		}, nil
+	})}
+}
+
+// unavailableCount is how many threads have been skipped for reason
+func unavailableCount(t *testing.T, reason string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "matchup_unavailable_subreddits_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "reason" && label.GetValue() == reason {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestScrapeThreadSubredditUnavailable(t *testing.T) {
+	tests := []struct {
+		fixture    string
+		status     int
+		wantReason string
+	}{
+		{"private_403.json", http.StatusForbidden, "private"},
+		{"quarantined_403.json", http.StatusForbidden, "quarantined"},
+		{"banned_404.json", http.StatusNotFound, "banned"},
+		{"notallowed_403.json", http.StatusForbidden, "subreddit_notallowed"},
+	}
+
+	token := TokenResponse{AccessToken: "token", ExpiresIn: 3600}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			before := unavailableCount(t, tt.wantReason)
+
+			_, err := scrapeThread(context.Background(), redditErrorClient(t, tt.status, tt.fixture), token, "abc123", "summonerschool")
+			var unavailable *SubredditUnavailableError
+			if !errors.As(err, &unavailable) || unavailable.Reason != tt.wantReason {
+				t.Fatalf("err = %v, want a SubredditUnavailableError for %q", err, tt.wantReason)
+			}
+			if !errors.Is(err, ErrSubredditUnavailable) {
+				t.Errorf("err = %v doesn't match ErrSubredditUnavailable", err)
+			}
+			// only the one source is lost, it isn't reddit failing us
+			var upstream *models.UpstreamError
+			if errors.Is(err, ErrRedditAuth) || errors.As(err, &upstream) {
+				t.Errorf("err = %v, want it told apart from a credentials problem", err)
+			}
+			if got := unavailableCount(t, tt.wantReason); got != before+1 {
+				t.Errorf("counted %v threads for %q, want %v", got, tt.wantReason, before+1)
+			}
+		})
+	}
+}
+
+func TestScrapeThreadOtherRedditErrors(t *testing.T) {
+	token := TokenResponse{AccessToken: "token", ExpiresIn: 3600}
+
+	// a 403 that isn't about the subreddit is about the thread, our token is fine
+	_, err := scrapeThread(context.Background(), redditErrorClient(t, http.StatusForbidden, "forbidden_403.json"), token, "abc123", "summonerschool")
+	var upstream *models.UpstreamError
+	if !errors.Is(err, ErrPostForbidden) || errors.Is(err, ErrRedditAuth) || errors.As(err, &upstream) || errors.Is(err, ErrSubredditUnavailable) {
+		t.Errorf("forbidden: err = %v, want ErrPostForbidden", err)
+	}
+
+	// a revoked token is
+	_, err = scrapeThread(context.Background(), redditErrorClient(t, http.StatusUnauthorized, "forbidden_403.json"), token, "abc123", "summonerschool")
+	if !errors.Is(err, ErrRedditAuth) || !errors.As(err, &upstream) {
+		t.Errorf("unauthorized: err = %v, want an upstream ErrRedditAuth", err)
+	}
+
+	_, err = scrapeThread(context.Background(), redditErrorClient(t, http.StatusNotFound, "not_found_404.json"), token, "abc123", "summonerschool")
+	if !errors.Is(err, ErrPostNotFound) || errors.Is(err, ErrSubredditUnavailable) {
+		t.Errorf("not found: err = %v, want ErrPostNotFound", err)
+	}
+}
+
+func TestSubredditErrorIgnoresOtherBodies(t *testing.T) {
+	for _, body := range []string{
+		"",
+		"<html>Forbidden</html>",
+		`{"reason": "rate_limited"}`,
+		`{"json": {"errors": [["USER_REQUIRED", "please login to do that"]]}}`,
+		`{"json": {"errors": [[42]]}}`,
+	} {
+		if got := subredditError([]byte(body)); got != nil {
+			t.Errorf("subredditError(%q) = %v, want nil", body, got)
+		}
+	}
+}
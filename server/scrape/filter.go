@@ -0,0 +1,52 @@
+package scrape
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	defaultFilteredAuthors = []string{"[deleted]", "AutoModerator"}
+	defaultFilteredBodies  = []string{"[deleted]", "[removed]"}
+)
+
+// FILTERED_AUTHORS and FILTERED_BODIES as sets, built by Configure since
+// they're checked for every comment
+var filteredAuthors, filteredBodies map[string]bool
+
+// filterList is a configured list as a set, or the defaults when it's unset
+func filterList(values []string, defaults []string) map[string]bool {
+	if values == nil {
+		values = defaults
+	}
+
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[strings.TrimSpace(value)] = true
+	}
+	return set
+}
+
+// isFilteredComment reports whether a comment is deleted, removed or written
+// by a bot, configurable via FILTERED_AUTHORS and FILTERED_BODIES. These add
+// nothing to the summary but still cost tokens.
+//
+// Replies to a filtered comment are kept and moved up to take its place,
+// since a [deleted] parent often has useful discussion underneath it.
+func isFilteredComment(commentData map[string]interface{}) bool {
+	author, _ := getString(commentData, "author")
+	if filteredAuthors[author] {
+		return true
+	}
+
+	body, _ := getString(commentData, "body")
+	return filteredBodies[strings.TrimSpace(body)]
+}
+
+// isTooShort reports whether a comment's cleaned body is shorter than
+// MIN_COMMENT_LENGTH characters (0 keeps everything). One liners take up
+// comment slots better spent on actual advice. Replies are moved up the same
+// way as for filtered comments, a short question often gets a long answer.
+func isTooShort(content string) bool {
+	return utf8.RuneCountInString(strings.TrimSpace(content)) < settings.MinCommentLength
+}
@@ -0,0 +1,154 @@
+package scrape
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"server/config"
+)
+
+// a comment listing where the top comment is too short to keep but has a
+// substantive reply, alongside one substantive and one short top level comment
+const shortCommentListing = `{
+	"kind": "Listing",
+	"data": {
+		"children": [
+			{
+				"kind": "t1",
+				"data": {
+					"name": "t1_short",
+					"author": "someone",
+					"body": "ban him",
+					"created_utc": 1700000000,
+					"permalink": "/r/summonerschool/comments/abc123/lux_vs_zed/short/",
+					"score": 400,
+					"replies": {
+						"kind": "Listing",
+						"data": {
+							"children": [
+								{
+									"kind": "t1",
+									"data": {
+										"name": "t1_reply",
+										"author": "someone_else",
+										"body": "Or just hold your E until he commits his W, then root him.",
+										"created_utc": 1700000100,
+										"permalink": "/r/summonerschool/comments/abc123/lux_vs_zed/reply/",
+										"score": 150,
+										"replies": ""
+									}
+								}
+							]
+						}
+					}
+				}
+			},
+			{
+				"kind": "t1",
+				"data": {
+					"name": "t1_long",
+					"author": "third",
+					"body": "Buy a seeker's armguard and play for level 6 spikes.",
+					"created_utc": 1700000200,
+					"permalink": "/r/summonerschool/comments/abc123/lux_vs_zed/long/",
+					"score": 90,
+					"replies": ""
+				}
+			},
+			{
+				"kind": "t1",
+				"data": {
+					"name": "t1_stat",
+					"author": "fourth",
+					"body": "stat check",
+					"created_utc": 1700000300,
+					"permalink": "/r/summonerschool/comments/abc123/lux_vs_zed/stat/",
+					"score": 12,
+					"replies": ""
+				}
+			}
+		]
+	}
+}`
+
+func TestParseCommentsDropsShortComments(t *testing.T) {
+	defer func(s config.Reddit) { settings = s }(settings)
+	settings = config.Reddit{MinCommentLength: 20}
+
+	var listing map[string]interface{}
+	if err := json.Unmarshal([]byte(shortCommentListing), &listing); err != nil {
+		t.Fatal(err)
+	}
+
+	var more []string
+	comments, err := parseComments(context.Background(), listing, &more)
+	if err != nil {
+		t.Fatalf("parseComments: %v", err)
+	}
+
+	// the short comment's reply takes its place at the top level
+	want := []string{"t1_reply", "t1_long"}
+	if len(comments) != len(want) {
+		t.Fatalf("got %d comments, want %d: %+v", len(comments), len(want), comments)
+	}
+	for i, id := range want {
+		if comments[i].ID != id {
+			t.Errorf("comment %d is %s, want %s", i, comments[i].ID, id)
+		}
+	}
+}
+
+func TestParseCommentsKeepsShortCommentsWhenDisabled(t *testing.T) {
+	defer func(s config.Reddit) { settings = s }(settings)
+	settings = config.Reddit{MinCommentLength: 0}
+
+	var listing map[string]interface{}
+	if err := json.Unmarshal([]byte(shortCommentListing), &listing); err != nil {
+		t.Fatal(err)
+	}
+
+	var more []string
+	comments, err := parseComments(context.Background(), listing, &more)
+	if err != nil {
+		t.Fatalf("parseComments: %v", err)
+	}
+
+	if len(comments) != 3 {
+		t.Fatalf("got %d comments, want all 3: %+v", len(comments), comments)
+	}
+	if len(comments[0].Replies) != 1 || comments[0].Replies[0].ID != "t1_reply" {
+		t.Errorf("reply wasn't kept under its parent: %+v", comments[0].Replies)
+	}
+}
+
+func TestIsFilteredCommentFollowsConfigure(t *testing.T) {
+	defer Configure(settings)
+
+	comment := func(author, body string) map[string]interface{} {
+		return map[string]interface{}{"author": author, "body": body}
+	}
+
+	Configure(config.Reddit{})
+	for _, c := range []map[string]interface{}{
+		comment("[deleted]", "Hold your E for his W"),
+		comment("AutoModerator", "Please read the rules"),
+		comment("someone", " [removed] "),
+	} {
+		if !isFilteredComment(c) {
+			t.Errorf("defaults kept %v", c)
+		}
+	}
+	if isFilteredComment(comment("someone", "Hold your E for his W")) {
+		t.Error("defaults dropped an ordinary comment")
+	}
+
+	// reconfiguring replaces the defaults
+	Configure(config.Reddit{FilteredAuthors: []string{" LeagueBot "}, FilteredBodies: []string{}})
+	if !isFilteredComment(comment("LeagueBot", "Patch notes are out")) {
+		t.Error("the configured author wasn't filtered")
+	}
+	if isFilteredComment(comment("AutoModerator", "Please read the rules")) || isFilteredComment(comment("someone", "[removed]")) {
+		t.Error("the defaults still applied after reconfiguring")
+	}
+}
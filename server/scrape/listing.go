@@ -0,0 +1,28 @@
+package scrape
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// commentsURL builds the listing url for a thread. REDDIT_COMMENT_LIMIT,
+// REDDIT_COMMENT_DEPTH and REDDIT_COMMENT_SORT control how many comments
+// reddit returns, how deep it nests replies and what it ranks them by, so it
+// does the sorting and trimming instead of us downloading the whole default
+// listing. The default top sort keeps the comments fetched the same from one
+// scrape to the next.
+func commentsURL(postID string, subreddit string) string {
+	base := fmt.Sprintf("https://oauth.reddit.com/r/%s/comments/%s", subreddit, postID)
+	if subreddit == "" {
+		// short links only have the id, reddit can look the post up without the subreddit
+		base = fmt.Sprintf("https://oauth.reddit.com/comments/%s", postID)
+	}
+
+	params := url.Values{}
+	params.Set("limit", strconv.Itoa(settings.CommentLimit))
+	params.Set("depth", strconv.Itoa(settings.CommentDepth))
+	params.Set("sort", settings.CommentSort)
+
+	return base + "?" + params.Encode()
+}
@@ -0,0 +1,49 @@
+package scrape
+
+import (
+	"testing"
+
+	"server/config"
+)
+
+func TestCommentsURL(t *testing.T) {
+	defer func(s config.Reddit) { settings = s }(settings)
+
+	tests := []struct {
+		name      string
+		reddit    config.Reddit
+		postID    string
+		subreddit string
+		want      string
+	}{
+		{
+			name:      "defaults",
+			reddit:    config.Reddit{CommentLimit: 100, CommentDepth: 5, CommentSort: "top"},
+			postID:    "abc123",
+			subreddit: "summonerschool",
+			want:      "https://oauth.reddit.com/r/summonerschool/comments/abc123?depth=5&limit=100&sort=top",
+		},
+		{
+			name:      "configured",
+			reddit:    config.Reddit{CommentLimit: 500, CommentDepth: 2, CommentSort: "best"},
+			postID:    "abc123",
+			subreddit: "leagueoflegends",
+			want:      "https://oauth.reddit.com/r/leagueoflegends/comments/abc123?depth=2&limit=500&sort=best",
+		},
+		{
+			name:   "short link without a subreddit",
+			reddit: config.Reddit{CommentLimit: 100, CommentDepth: 5, CommentSort: "top"},
+			postID: "abc123",
+			want:   "https://oauth.reddit.com/comments/abc123?depth=5&limit=100&sort=top",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings = tt.reddit
+			if got := commentsURL(tt.postID, tt.subreddit); got != tt.want {
+				t.Errorf("commentsURL(%q, %q) = %q, want %q", tt.postID, tt.subreddit, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package scrape
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	mdLink        = regexp.MustCompile(`\[([^\]]*)\]\((?:[^()]|\([^)]*\))*\)`)
+	mdSpoiler     = regexp.MustCompile(`>!(.*?)!<`)
+	mdSuperParens = regexp.MustCompile(`\^\(([^)]*)\)`)
+	mdSuper       = regexp.MustCompile(`\^(\S)`)
+	mdEmphasis    = regexp.MustCompile(`(\*\*|__|~~)(.+?)(\*\*|__|~~)`)
+	mdItalic      = regexp.MustCompile(`\*([^*\n]+)\*`)
+	mdCode        = regexp.MustCompile("`([^`]*)`")
+	mdQuote       = regexp.MustCompile(`(?m)^([ \t]*>)+[ \t]?`)
+	mdHeading     = regexp.MustCompile(`(?m)^[ \t]*#{1,6}[ \t]+`)
+	mdBlankLines  = regexp.MustCompile(`\n{3,}`)
+)
+
+// cleanMarkdown turns a reddit markdown body into plain text, dropping link
+// urls, emphasis, quotes and superscript so they don't cost tokens or get
+// mistaken for sources by the model
+func cleanMarkdown(s string) string {
+	// reddit escapes entities and sometimes escapes the escapes (&amp;gt;)
+	s = html.UnescapeString(html.UnescapeString(s))
+
+	s = mdLink.ReplaceAllString(s, "$1")
+	s = mdSpoiler.ReplaceAllString(s, "$1")
+	s = mdQuote.ReplaceAllString(s, "")
+	s = mdHeading.ReplaceAllString(s, "")
+	s = mdSuperParens.ReplaceAllString(s, "$1")
+	s = mdSuper.ReplaceAllString(s, "$1")
+	s = mdEmphasis.ReplaceAllString(s, "$2")
+	s = mdItalic.ReplaceAllString(s, "$1")
+	s = mdCode.ReplaceAllString(s, "$1")
+
+	// zero width spaces reddit uses to force blank lines
+	s = strings.ReplaceAll(s, "\u200b", "")
+	s = mdBlankLines.ReplaceAllString(s, "\n\n")
+
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,36 @@
+package scrape
+
+import "testing"
+
+func TestCleanMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "Hold your E until he uses W.", "Hold your E until he uses W."},
+		{"quote", "&gt; he always Qs first\n\nNo he doesn't", "he always Qs first\n\nNo he doesn't"},
+		{"nested quotes", "&gt;&gt; buy armguard\n&gt; only if he's ahead\n\nAlways buy it", "buy armguard\nonly if he's ahead\n\nAlways buy it"},
+		{"quote with spaces", "&gt; &gt; nested\n  &gt; indented", "nested\nindented"},
+		{"link", "See [this guide](https://www.reddit.com/r/LuxMains/comments/abc123/guide/) for more", "See this guide for more"},
+		{"link with parens in the url", "[Zed](https://en.wikipedia.org/wiki/Zed_(League)) is strong", "Zed is strong"},
+		{"bold and italics", "**never** walk up *before* level 3, __ever__", "never walk up before level 3, ever"},
+		{"strikethrough", "~~Zhonya rush~~ armguard first", "Zhonya rush armguard first"},
+		{"superscript", "gg ^(this is a joke) and ^so ^is ^this", "gg this is a joke and so is this"},
+		{"spoiler", "the trick is &gt;!flash after his R!&lt;", "the trick is flash after his R"},
+		{"code", "type `/mute all` and focus", "type /mute all and focus"},
+		{"heading", "## Early game\nShove and roam", "Early game\nShove and roam"},
+		{"double escaped", "Q &amp;amp; E combo &amp;gt; auto attacks", "Q & E combo > auto attacks"},
+		{"escaped ampersand", "Lux &amp; Zed", "Lux & Zed"},
+		{"zero width blank lines", "first\n\n\u200b\n\n\u200b\n\nsecond", "first\n\nsecond"},
+		{"surrounding whitespace", "  \n ok \n ", "ok"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cleanMarkdown(tt.in); got != tt.want {
+				t.Errorf("cleanMarkdown(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
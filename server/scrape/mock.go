@@ -0,0 +1,62 @@
+package scrape
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"server/models"
+)
+
+// mockMode reports whether MOCK_MODE=true, in which case scrapes return a
+// canned thread instead of calling reddit
+func mockMode() bool {
+	return settings.MockMode
+}
+
+// mockScrape builds a small fixed thread (one post, two comments, one reply)
+// whose permalink matches the search item's link
+func mockScrape(item models.SearchItem) ([]byte, error) {
+	postID, subreddit, err := getPostInfo(item)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	permalink := fmt.Sprintf("/r/%s/comments/%s/mock/", subreddit, postID)
+	post := Post{
+		Timestamp:   1700000000,
+		Content:     "Looking for advice on this matchup, what should I focus on in lane?",
+		Permalink:   permalink,
+		Title:       item.Title,
+		Score:       120,
+		UpvoteRatio: 0.95,
+		NumComments: 3,
+		Flair:       "Discussion",
+		Comments: []Comment{
+			{
+				ID:        "t1_mockc1",
+				Timestamp: 1700000600,
+				Content:   "Trade when their main cooldown is down and play around level 6.",
+				Permalink: permalink + "mockc1/",
+				Score:     85,
+				Replies: []Comment{
+					{
+						ID:        "t1_mockr1",
+						Timestamp: 1700001200,
+						Content:   "Also ward the river bush, the gank threat is real pre 6.",
+						Permalink: permalink + "mockr1/",
+						Score:     30,
+					},
+				},
+			},
+			{
+				ID:        "t1_mockc2",
+				Timestamp: 1700003600,
+				Content:   "Build an early defensive item if you fall behind.",
+				Permalink: permalink + "mockc2/",
+				Score:     40,
+			},
+		},
+	}
+
+	return json.MarshalIndent(post, "", "  ")
+}
@@ -0,0 +1,124 @@
+package scrape
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"server/logging"
+)
+
+// reddit won't expand more than 100 ids in one morechildren call
+const maxMoreChildren = 100
+
+// moreCommentsLimit is MORE_COMMENTS_LIMIT, the number of collapsed comments
+// to fetch per thread. 0 turns the follow-up request off.
+func moreCommentsLimit() int {
+	return min(settings.MoreCommentsLimit, maxMoreChildren)
+}
+
+// expandMoreComments fetches the comments hidden behind "more" stubs and
+// attaches them to their parents in the post's comment tree
+func expandMoreComments(ctx context.Context, httpClient *http.Client, token TokenResponse, post *Post, postID string) error {
+	limit := moreCommentsLimit()
+	if limit == 0 {
+		return nil
+	}
+
+	ids := post.more
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	params := url.Values{}
+	params.Set("api_type", "json")
+	params.Set("link_id", "t3_"+postID)
+	params.Set("children", strings.Join(ids, ","))
+	params.Set("sort", "top")
+
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://oauth.reddit.com/api/morechildren?"+params.Encode(), http.NoBody)
+	if err != nil {
+		return fmt.Errorf("couldnt make request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+
+	response, err := doReddit(ctx, httpClient, req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return statusError(response.StatusCode, "loading more comments")
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response body: %w", err)
+	}
+
+	var result struct {
+		JSON struct {
+			Data struct {
+				Things []struct {
+					Kind string                 `json:"kind"`
+					Data map[string]interface{} `json:"data"`
+				} `json:"things"`
+			} `json:"data"`
+		} `json:"json"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("couldnt unmarshall json: %w", err)
+	}
+
+	// things come back flat with parents before their replies, group them by
+	// the comment (or post) they reply to
+	children := make(map[string][]Comment)
+	// filtered comment id -> the parent its replies are moved up to
+	reparented := make(map[string]string)
+	for _, thing := range result.JSON.Data.Things {
+		if thing.Kind != "t1" {
+			continue
+		}
+
+		comment, err := parseComment(thing.Data)
+		if err != nil {
+			logging.FromContext(ctx).Warn("skipping collapsed comment", "error", err)
+			continue
+		}
+
+		parentID, err := getString(thing.Data, "parent_id")
+		if err != nil {
+			continue
+		}
+		if newParent, ok := reparented[parentID]; ok {
+			parentID = newParent
+		}
+
+		if isFilteredComment(thing.Data) || isTooShort(comment.Content) {
+			reparented[comment.ID] = parentID
+			continue
+		}
+
+		children[parentID] = append(children[parentID], comment)
+	}
+
+	post.Comments = attachReplies(append(post.Comments, children["t3_"+postID]...), children)
+
+	return nil
+}
+
+// attachReplies appends each comment's newly fetched replies, recursing so
+// replies to the fetched comments land in the right place too
+func attachReplies(comments []Comment, children map[string][]Comment) []Comment {
+	for i := range comments {
+		replies := append(comments[i].Replies, children[comments[i].ID]...)
+		comments[i].Replies = attachReplies(replies, children)
+	}
+	return comments
+}
@@ -0,0 +1,131 @@
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"server/logging"
+)
+
+const (
+	// start holding requests back once this few are left in the window
+	rateLimitLowWater = 5
+	// wait for the window to reset if it's this close, otherwise give up
+	maxRateLimitWait = 10 * time.Second
+)
+
+// RateLimitedError is returned when reddit's rate limit window is spent and
+// won't reset soon enough to wait for
+type RateLimitedError struct {
+	Reset time.Time
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("reddit rate limit reached, resets at %s", e.Reset.Format(time.RFC3339))
+}
+
+func (e *RateLimitedError) Is(target error) bool {
+	return target == ErrRedditRateLimited
+}
+
+// redditLimits tracks the most recent X-Ratelimit-* headers reddit sent. The
+// limit is per oauth client so it's shared by every scrape.
+var redditLimits struct {
+	sync.Mutex
+	known     bool
+	remaining float64
+	reset     time.Time
+}
+
+var (
+	redditSem     chan struct{}
+	redditSemOnce sync.Once
+)
+
+// semaphore caps concurrent reddit calls at REDDIT_MAX_CONCURRENCY
+func semaphore() chan struct{} {
+	redditSemOnce.Do(func() {
+		redditSem = make(chan struct{}, max(settings.MaxConcurrency, 1))
+	})
+
+	return redditSem
+}
+
+func userAgent() string {
+	return settings.UserAgent
+}
+
+// doReddit sends req once a concurrency slot is free and the rate limit
+// allows it, recording the rate limit headers from the response
+func doReddit(ctx context.Context, httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	sem := semaphore()
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-sem }()
+
+	if err := waitForRateLimit(ctx); err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", userAgent())
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	recordRateLimit(resp.Header)
+
+	return resp, nil
+}
+
+// waitForRateLimit sleeps until the window resets if we're nearly out of
+// requests, or returns a RateLimitedError if that's too far off
+func waitForRateLimit(ctx context.Context) error {
+	redditLimits.Lock()
+	low := redditLimits.known && redditLimits.remaining < rateLimitLowWater
+	reset := redditLimits.reset
+	redditLimits.Unlock()
+
+	wait := time.Until(reset)
+	if !low || wait <= 0 {
+		return nil
+	}
+	if wait > maxRateLimitWait {
+		return &RateLimitedError{Reset: reset}
+	}
+
+	logging.FromContext(ctx).Warn("reddit rate limit nearly spent, waiting for reset", "wait", wait)
+
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// recordRateLimit reads X-Ratelimit-Remaining and X-Ratelimit-Reset (seconds
+// until the window resets), ignoring responses that don't carry them
+func recordRateLimit(header http.Header) {
+	remaining, err := strconv.ParseFloat(header.Get("X-Ratelimit-Remaining"), 64)
+	if err != nil {
+		return
+	}
+	resetIn, err := strconv.ParseFloat(header.Get("X-Ratelimit-Reset"), 64)
+	if err != nil {
+		return
+	}
+
+	redditLimits.Lock()
+	defer redditLimits.Unlock()
+	redditLimits.known = true
+	redditLimits.remaining = remaining
+	redditLimits.reset = time.Now().Add(time.Duration(resetIn * float64(time.Second)))
+}
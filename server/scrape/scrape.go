@@ -1,20 +1,25 @@
 package scrape
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"html"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
-	"os"
+	"regexp"
+	"server/config"
+	"server/logging"
+	"server/metrics"
 	"server/models"
 	"strings"
-
-	"github.com/joho/godotenv"
+	"sync"
+	"time"
 )
 
 type Comment struct {
+	ID        string // reddit fullname, e.g. t1_abc123
 	Timestamp int64
 	Content   string
 	Permalink string
@@ -23,46 +28,147 @@ type Comment struct {
 }
 
 type Post struct {
-	Timestamp int64
-	Content   string
-	Permalink string
-	Title     string
-	Score     int
-	Comments  []Comment
+	Timestamp   int64
+	Content     string
+	Permalink   string
+	Title       string
+	Score       int
+	UpvoteRatio float64
+	NumComments int
+	Flair       string // e.g. "Discussion" or "Meme", empty if unflaired
+	Awards      int
+	Comments    []Comment
+
+	// ids of comments collapsed behind "load more" stubs
+	more []string
+}
+
+var settings config.Reddit
+
+// Configure sets the reddit credentials, user agent and comment filters, it
+// must be called before anything is scraped
+func Configure(c config.Reddit) {
+	settings = c
+	filteredAuthors = filterList(c.FilteredAuthors, defaultFilteredAuthors)
+	filteredBodies = filterList(c.FilteredBodies, defaultFilteredBodies)
 }
 
 type TokenResponse struct {
 	AccessToken string `json:"access_token"`
 	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"` // seconds
 }
 
+// reddit tokens last about an hour so they're shared across scrapes
+type tokenCache struct {
+	mu         sync.Mutex
+	token      TokenResponse
+	httpClient *http.Client
+	expiresAt  time.Time
+}
+
+// re-authenticate once the cached token is this close to expiring
+const tokenRefreshWindow = 60 * time.Second
+
+var cachedToken tokenCache
+
 func getPostInfo(searchItem models.SearchItem) (string, string, error) {
+	return ParsePostURL(searchItem.Link)
+}
 
-	splitUrl := strings.Split(searchItem.Link[8:], "/")
+// ParsePostURL extracts the post id and subreddit from a reddit thread link.
+// Any reddit.com host works (www, old, np, mobile), as do redd.it short links,
+// which don't say which subreddit the post is in so that comes back empty.
+func ParsePostURL(link string) (string, string, error) {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || u.Host == "" {
+		return "", "", fmt.Errorf("%w: url %q is not a valid link", ErrBadURL, link)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	segments := strings.FieldsFunc(u.Path, func(r rune) bool { return r == '/' })
+
+	var postID, subreddit string
+	switch {
+	case host == "redd.it" && len(segments) >= 1:
+		postID = segments[0]
+	case host == "reddit.com" || strings.HasSuffix(host, ".reddit.com"):
+		if len(segments) >= 4 && segments[0] == "r" && segments[2] == "comments" {
+			postID, subreddit = segments[3], segments[1]
+		} else if len(segments) >= 2 && segments[0] == "comments" {
+			postID = segments[1]
+		}
+	}
 
-	if splitUrl[0] == "www.reddit.com" && splitUrl[1] == "r" && splitUrl[3] == "comments" {
-		return splitUrl[4], splitUrl[2], nil
+	// both end up in the oauth api path, so don't let anything odd through
+	if !postIDPattern.MatchString(postID) || (subreddit != "" && !subredditPattern.MatchString(subreddit)) {
+		return "", "", fmt.Errorf("%w: %q", ErrBadURL, link)
 	}
 
-	return "", "", fmt.Errorf("url: %s was not formatted properly", searchItem.Link[0:8])
+	return postID, subreddit, nil
+}
+
+// CanonicalPostURL rewrites a reddit thread link to a single form,
+// https://www.reddit.com/r/<subreddit>/comments/<id>, dropping the host
+// variant, slug, query string and trailing slash. Short links have no
+// subreddit and become https://www.reddit.com/comments/<id>.
+func CanonicalPostURL(link string) (string, error) {
+	postID, subreddit, err := ParsePostURL(link)
+	if err != nil {
+		return "", err
+	}
 
+	if subreddit == "" {
+		return "https://www.reddit.com/comments/" + postID, nil
+	}
+	return fmt.Sprintf("https://www.reddit.com/r/%s/comments/%s", subreddit, postID), nil
 }
 
-// returns the http client too to preserve the cache because that makes it faster I think
-func getToken() (TokenResponse, *http.Client, error) {
+var (
+	// post ids are base36
+	postIDPattern    = regexp.MustCompile(`^[a-z0-9]+$`)
+	subredditPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+)
 
-	// environment variable stuff
-	err := godotenv.Load(".env")
+// returns the cached token while it's still fresh, otherwise fetches a new one
+func getToken(ctx context.Context) (TokenResponse, *http.Client, error) {
+	cachedToken.mu.Lock()
+	defer cachedToken.mu.Unlock()
+
+	if cachedToken.httpClient != nil && time.Until(cachedToken.expiresAt) > tokenRefreshWindow {
+		return cachedToken.token, cachedToken.httpClient, nil
+	}
+
+	token, httpClient, err := fetchToken(ctx)
 	if err != nil {
-		log.Printf("error loading .env: %s", err)
 		return TokenResponse{}, &http.Client{}, err
 	}
 
-	redditClientID := os.Getenv("REDDIT_CLIENT_ID")
-	redditClientSecret := os.Getenv("REDDIT_CLIENT_SECRET")
-	redditUsername := os.Getenv("REDDIT_CLIENT_USERNAME")
-	redditPassword := os.Getenv("REDDIT_CLIENT_PASSWORD")
-	redditAppName := os.Getenv("REDDIT_APP_NAME")
+	cachedToken.token = token
+	cachedToken.httpClient = httpClient
+	cachedToken.expiresAt = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+
+	return token, httpClient, nil
+}
+
+// forgetToken drops token from the cache if it's still the cached one, so a
+// token reddit has stopped accepting isn't reused until it expires
+func forgetToken(token TokenResponse) {
+	cachedToken.mu.Lock()
+	defer cachedToken.mu.Unlock()
+
+	if cachedToken.token.AccessToken == token.AccessToken {
+		cachedToken.httpClient = nil
+	}
+}
+
+// returns the http client too to preserve the cache because that makes it faster I think
+func fetchToken(ctx context.Context) (TokenResponse, *http.Client, error) {
+
+	redditClientID := settings.ClientID
+	redditClientSecret := settings.ClientSecret
+	redditUsername := settings.Username
+	redditPassword := settings.Password
 
 	// prep http client & oauth2 stuff
 	httpClient := &http.Client{}
@@ -71,32 +177,37 @@ func getToken() (TokenResponse, *http.Client, error) {
 	data.Set("username", redditUsername)
 	data.Set("password", redditPassword)
 
-	req, err := http.NewRequest("POST", "https://www.reddit.com/api/v1/access_token", strings.NewReader(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://www.reddit.com/api/v1/access_token", strings.NewReader(data.Encode()))
 	if err != nil {
-		log.Printf("error creating request: %s", err)
+		logging.FromContext(ctx).Error("couldn't create reddit token request", "error", err)
 		return TokenResponse{}, &http.Client{}, err
 	}
 
 	req.SetBasicAuth(redditClientID, redditClientSecret)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("User-Agent", fmt.Sprintf("%s by /u/%s", redditAppName, redditUsername))
+	req.Header.Set("User-Agent", userAgent())
 
 	// send & deal with request
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		log.Fatalf("error making request: %s", err)
+		logging.FromContext(ctx).Warn("reddit token request failed", "error", err)
 		return TokenResponse{}, &http.Client{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("error response: %s", resp.Status)
-		return TokenResponse{}, &http.Client{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		logging.FromContext(ctx).Warn("reddit refused a token", "status", resp.StatusCode)
+		// a bad client id or secret comes back as a 401, a suspended app as a
+		// 403, either way it's our credentials
+		if resp.StatusCode == http.StatusForbidden {
+			return TokenResponse{}, &http.Client{}, fmt.Errorf("%w: status %d when getting a token", ErrRedditAuth, resp.StatusCode)
+		}
+		return TokenResponse{}, &http.Client{}, statusError(resp.StatusCode, "getting a token")
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("couldnt read body: %s", err)
+		logging.FromContext(ctx).Warn("couldn't read reddit token response", "error", err)
 		return TokenResponse{}, &http.Client{}, err
 
 	}
@@ -105,15 +216,23 @@ func getToken() (TokenResponse, *http.Client, error) {
 	var token TokenResponse
 	err = json.Unmarshal(body, &token)
 	if err != nil {
-		log.Printf("error decoding response: %s", err)
+		logging.FromContext(ctx).Warn("couldn't decode reddit token response", "error", err)
 		return TokenResponse{}, &http.Client{}, err
 	}
 
+	// a wrong username or password is a 200 with an error instead of a token
+	if token.AccessToken == "" {
+		return TokenResponse{}, &http.Client{}, fmt.Errorf("%w: no access token in the response", ErrRedditAuth)
+	}
+
 	return token, httpClient, nil
 
 }
 
-func parseJson(data []interface{}) (*Post, error) {
+// parseJson reads reddit's [post listing, comment listing] response. Every
+// field is type checked before use, so malformed or truncated JSON comes back
+// as an error (or a skipped comment) rather than a panic.
+func parseJson(ctx context.Context, data []interface{}) (*Post, error) {
 
 	if len(data) < 2 {
 		return nil, fmt.Errorf("insufficient data")
@@ -134,7 +253,7 @@ func parseJson(data []interface{}) (*Post, error) {
 		return nil, err
 	}
 
-	comments, err := parseComments(commentsData)
+	comments, err := parseComments(ctx, commentsData, &post.more)
 	if err != nil {
 		return nil, err
 	}
@@ -146,28 +265,106 @@ func parseJson(data []interface{}) (*Post, error) {
 }
 
 func parsePost(postData map[string]interface{}) (*Post, error) {
-	children, ok := postData["data"].(map[string]interface{})["children"].([]interface{})
+	listing, ok := postData["data"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid post data structure")
+	}
+
+	children, ok := listing["children"].([]interface{})
 	if !ok || len(children) == 0 {
 		return nil, fmt.Errorf("invalid post children data")
 	}
 
-	postMap, ok := children[0].(map[string]interface{})["data"].(map[string]interface{})
+	child, ok := children[0].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid post child data")
+	}
+
+	postMap, ok := child["data"].(map[string]interface{})
 	if !ok {
 		return nil, fmt.Errorf("invalid post map data")
 	}
 
-	post := &Post{
-		Timestamp: int64(postMap["created_utc"].(float64)),
-		Content:   postMap["selftext"].(string),
-		Permalink: postMap["permalink"].(string),
-		Title:     postMap["title"].(string),
-		Score:     int(postMap["score"].(float64)),
+	post := &Post{}
+	var err error
+
+	post.Timestamp, err = getInt64(postMap, "created_utc")
+	if err != nil {
+		return nil, err
+	}
+
+	post.Permalink, err = getString(postMap, "permalink")
+	if err != nil {
+		return nil, err
+	}
+
+	post.Title, err = getString(postMap, "title")
+	if err != nil {
+		return nil, err
+	}
+	post.Title = html.UnescapeString(post.Title)
+
+	post.Score, err = getInt(postMap, "score")
+	if err != nil {
+		return nil, err
+	}
+
+	post.Content = cleanMarkdown(postBody(postMap))
+
+	// consensus signals, not every listing includes them so they're optional
+	if ratio, err := getFloat64(postMap, "upvote_ratio"); err == nil {
+		post.UpvoteRatio = ratio
+	}
+	if numComments, err := getInt(postMap, "num_comments"); err == nil {
+		post.NumComments = numComments
+	}
+	// null when the post has no flair
+	if flair, err := getString(postMap, "link_flair_text"); err == nil {
+		post.Flair = html.UnescapeString(flair)
+	}
+	if awards, err := getInt(postMap, "total_awards_received"); err == nil {
+		post.Awards = awards
 	}
 
 	return post, nil
 }
 
-func parseComments(commentsData map[string]interface{}) ([]Comment, error) {
+// postBody finds the text of a post. Crossposts keep theirs on the original
+// post and galleries only have per-image captions. Link and image posts have
+// none at all, leaving the title and comments to carry the thread.
+func postBody(postMap map[string]interface{}) string {
+	if selftext, _ := getString(postMap, "selftext"); selftext != "" {
+		return selftext
+	}
+
+	if parents, ok := postMap["crosspost_parent_list"].([]interface{}); ok && len(parents) > 0 {
+		if parent, ok := parents[0].(map[string]interface{}); ok {
+			if selftext, _ := getString(parent, "selftext"); selftext != "" {
+				return selftext
+			}
+			postMap = parent
+		}
+	}
+
+	if gallery, ok := postMap["gallery_data"].(map[string]interface{}); ok {
+		items, _ := gallery["items"].([]interface{})
+		var captions []string
+		for _, item := range items {
+			if item, ok := item.(map[string]interface{}); ok {
+				if caption, _ := getString(item, "caption"); caption != "" {
+					captions = append(captions, caption)
+				}
+			}
+		}
+		return strings.Join(captions, "\n")
+	}
+
+	return ""
+}
+
+// parseComments walks a comment listing, collecting the ids referenced by any
+// "more" stubs into more so they can be fetched separately
+func parseComments(ctx context.Context, commentsData map[string]interface{}, more *[]string) ([]Comment, error) {
 	data, ok := commentsData["data"].(map[string]interface{})
 	if !ok {
 		return nil, fmt.Errorf("invalid comments data structure")
@@ -190,23 +387,39 @@ func parseComments(commentsData map[string]interface{}) ([]Comment, error) {
 			continue // Skip invalid comment data
 		}
 
+		if kind, _ := childMap["kind"].(string); kind == "more" {
+			ids, _ := commentData["children"].([]interface{})
+			for _, id := range ids {
+				if id, ok := id.(string); ok {
+					*more = append(*more, id)
+				}
+			}
+			continue
+		}
+
 		comment, err := parseComment(commentData)
 		if err != nil {
 			// Log the error but continue processing other comments
-			log.Printf("Error parsing comment: %v", err)
+			logging.FromContext(ctx).Warn("skipping comment", "error", err)
 			continue
 		}
 
 		replies, ok := commentData["replies"].(map[string]interface{})
 		if ok {
-			subComments, err := parseComments(replies)
+			subComments, err := parseComments(ctx, replies, more)
 			if err == nil {
 				comment.Replies = subComments
 			} else {
-				log.Printf("Error parsing replies: %v", err)
+				logging.FromContext(ctx).Warn("skipping replies", "comment", comment.ID, "error", err)
 			}
 		}
 
+		if isFilteredComment(commentData) || isTooShort(comment.Content) {
+			// re-parent the replies so they aren't lost with their parent
+			comments = append(comments, comment.Replies...)
+			continue
+		}
+
 		comments = append(comments, comment)
 	}
 
@@ -217,6 +430,9 @@ func parseComment(commentData map[string]interface{}) (Comment, error) {
 	var comment Comment
 	var err error
 
+	// only needed to attach replies fetched from morechildren
+	comment.ID, _ = getString(commentData, "name")
+
 	comment.Timestamp, err = getInt64(commentData, "created_utc")
 	if err != nil {
 		return Comment{}, err
@@ -226,6 +442,7 @@ func parseComment(commentData map[string]interface{}) (Comment, error) {
 	if err != nil {
 		return Comment{}, err
 	}
+	comment.Content = cleanMarkdown(comment.Content)
 
 	comment.Permalink, err = getString(commentData, "permalink")
 	if err != nil {
@@ -267,6 +484,18 @@ func getString(m map[string]interface{}, key string) (string, error) {
 	return s, nil
 }
 
+func getFloat64(m map[string]interface{}, key string) (float64, error) {
+	v, ok := m[key]
+	if !ok {
+		return 0, fmt.Errorf("key %s not found", key)
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected type for key %s", key)
+	}
+	return f, nil
+}
+
 func getInt(m map[string]interface{}, key string) (int, error) {
 	v, ok := m[key]
 	if !ok {
@@ -282,70 +511,105 @@ func getInt(m map[string]interface{}, key string) (int, error) {
 	}
 }
 
-func Scrape(item models.SearchItem) ([]byte, error) {
+func Scrape(ctx context.Context, item models.SearchItem) ([]byte, error) {
+	if mockMode() {
+		return mockScrape(item)
+	}
+
+	defer metrics.ObserveStage("scrape", time.Now())
 
-	err := godotenv.Load(".env")
+	postID, subreddit, err := getPostInfo(item)
 	if err != nil {
-		log.Printf("error loading .env: %s", err)
-		return []byte{}, fmt.Errorf("%s", err)
+		return []byte{}, err
 	}
 
-	redditAppName := os.Getenv("REDDIT_APP_NAME")
-	redditUsername := os.Getenv("REDDIT_CLIENT_USERNAME")
+	token, httpClient, err := getToken(ctx)
+	if err != nil {
+		metrics.SourceErrors.WithLabelValues("reddit").Inc()
+		return []byte{}, &models.UpstreamError{Service: "reddit", Err: fmt.Errorf("error getting token: %w", err)}
+	}
 
-	postID, subreddit, err := getPostInfo(item)
+	post, err := scrapeThread(ctx, httpClient, token, postID, subreddit)
 	if err != nil {
-		return []byte{}, fmt.Errorf("%s", err)
+		return []byte{}, err
 	}
 
-	token, httpClient, err := getToken()
+	postJson, err := json.MarshalIndent(post, "", "  ")
 	if err != nil {
-		return []byte{}, fmt.Errorf("error getting token: %s", err)
+		return []byte{}, fmt.Errorf("error marshalling to JSON: %w", err)
+
 	}
 
-	url := fmt.Sprintf("https://oauth.reddit.com/r/%s/comments/%s", subreddit, postID)
-	fmt.Println(url)
+	return postJson, nil
+
+}
 
-	req, err := http.NewRequest("GET", url, http.NoBody)
+// scrapeThread fetches and parses a thread's post and comment tree
+func scrapeThread(ctx context.Context, httpClient *http.Client, token TokenResponse, postID string, subreddit string) (*Post, error) {
+	url := commentsURL(postID, subreddit)
+	logging.FromContext(ctx).Debug("fetching post", "url", url)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, http.NoBody)
 	if err != nil {
-		return []byte{}, fmt.Errorf("couldnt make request: %s", err)
+		return nil, fmt.Errorf("couldnt make request: %w", err)
 	}
 
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
-	req.Header.Set("User-Agent", fmt.Sprintf("%s by /u/%s", redditAppName, redditUsername))
 
-	response, err := httpClient.Do(req)
+	response, err := doReddit(ctx, httpClient, req)
 	if err != nil {
-		return []byte{}, fmt.Errorf("%s", err)
+		metrics.SourceErrors.WithLabelValues("reddit").Inc()
+		return nil, &models.UpstreamError{Service: "reddit", Err: err}
 	}
 	defer response.Body.Close()
 
-	if response.StatusCode != http.StatusOK {
-		return []byte{}, fmt.Errorf("unexpected status code when reading post: %d", response.StatusCode)
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden, http.StatusNotFound:
+		// reddit says why in the body when it's the subreddit that's the problem
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		if unavailable := subredditError(body); unavailable != nil {
+			metrics.UnavailableSubreddits.WithLabelValues(unavailable.Reason).Inc()
+			return nil, fmt.Errorf("post %s: %w", postID, unavailable)
+		}
+		// the thread is gone or hidden from us, reddit itself is fine
+		return nil, statusError(response.StatusCode, "reading post")
+	case http.StatusUnauthorized:
+		// the token was revoked early, make the next scrape get a new one
+		forgetToken(token)
+		fallthrough
+	default:
+		metrics.SourceErrors.WithLabelValues("reddit").Inc()
+		return nil, &models.UpstreamError{Service: "reddit", Err: statusError(response.StatusCode, "reading post")}
 	}
 
 	bodyBytes, err := io.ReadAll(response.Body)
 	if err != nil {
-		return []byte{}, fmt.Errorf("error reading response body: %w", err)
+		return nil, fmt.Errorf("error reading response body: %w", err)
 	}
 
 	var result []interface{}
 	err = json.Unmarshal(bodyBytes, &result)
 	if err != nil {
-		return []byte{}, fmt.Errorf("couldnt unmarshall json: %s", err)
+		return nil, fmt.Errorf("couldnt unmarshall json: %w", err)
 	}
 
-	post, err := parseJson(result)
+	post, err := parseJson(ctx, result)
 	if err != nil {
-		return []byte{}, fmt.Errorf("couldnt parse json: %s", err)
+		return nil, fmt.Errorf("couldnt parse json: %w", err)
 	}
 
-	postJson, err := json.MarshalIndent(post, "", "  ")
-	if err != nil {
-		return []byte{}, fmt.Errorf("error marshalling to JSON: %s", err)
-
+	if len(post.more) > 0 {
+		// not fatal, we still have whatever comments were expanded
+		if err := expandMoreComments(ctx, httpClient, token, post, postID); err != nil {
+			logging.FromContext(ctx).Warn("couldn't load more comments", "post_id", postID, "error", err)
+		}
 	}
 
-	return postJson, nil
+	// nothing for the summarizer to work with, don't spend a source on it
+	if post.Content == "" && len(post.Comments) == 0 {
+		return nil, fmt.Errorf("post %s has no body or comments", postID)
+	}
 
+	return post, nil
 }
@@ -0,0 +1,349 @@
+package scrape
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// FuzzParseJson feeds parseJson arbitrary reddit-shaped JSON, seeded from
+// testdata/fuzz/FuzzParseJson with a full listing, "more" stubs, removed
+// comments and fields of the wrong type. However broken the response, it
+// has to come back as an error rather than a panic.
+func FuzzParseJson(f *testing.F) {
+	f.Fuzz(func(t *testing.T, body []byte) {
+		var data []interface{}
+		if err := json.Unmarshal(body, &data); err != nil {
+			return
+		}
+
+		post, err := parseJson(context.Background(), data)
+		if err == nil && post == nil {
+			t.Fatalf("parseJson returned neither a post nor an error for %s", body)
+		}
+	})
+}
+
+func TestCanonicalPostURL(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"https://www.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed_mid/", "https://www.reddit.com/r/summonerschool/comments/abc123"},
+		{"https://www.reddit.com/r/summonerschool/comments/abc123", "https://www.reddit.com/r/summonerschool/comments/abc123"},
+		{"https://old.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed_mid/?utm_source=share&utm_medium=web2x", "https://www.reddit.com/r/summonerschool/comments/abc123"},
+		{"https://REDDIT.com/r/summonerschool/comments/abc123/#comments", "https://www.reddit.com/r/summonerschool/comments/abc123"},
+		{"http://np.reddit.com/r/LuxMains/comments/def456/zed/kx9f2a1/", "https://www.reddit.com/r/LuxMains/comments/def456"},
+		{"https://www.reddit.com/comments/abc123/", "https://www.reddit.com/comments/abc123"},
+		{"https://redd.it/abc123", "https://www.reddit.com/comments/abc123"},
+	}
+
+	for _, tt := range tests {
+		got, err := CanonicalPostURL(tt.link)
+		if err != nil || got != tt.want {
+			t.Errorf("CanonicalPostURL(%q) = %q, %v, want %q", tt.link, got, err, tt.want)
+		}
+	}
+}
+
+func TestCanonicalPostURLRejectsOtherLinks(t *testing.T) {
+	for _, link := range []string{
+		"",
+		"not a link",
+		"https://www.reddit.com/r/summonerschool/",
+		"https://www.youtube.com/watch?v=abc123",
+		"https://www.reddit.com/r/summonerschool/comments/ABC-123/",
+	} {
+		if got, err := CanonicalPostURL(link); !errors.Is(err, ErrBadURL) {
+			t.Errorf("CanonicalPostURL(%q) = %q, %v, want ErrBadURL", link, got, err)
+		}
+	}
+}
+
+// roundTripFunc stands in for the network in tests
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestGetTokenReturnsTransportErrors(t *testing.T) {
+	oldTransport := http.DefaultTransport
+	http.DefaultTransport = roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("dial tcp: connection refused")
+	})
+	t.Cleanup(func() {
+		http.DefaultTransport = oldTransport
+		cachedToken = tokenCache{}
+	})
+	cachedToken = tokenCache{}
+
+	if _, _, err := getToken(context.Background()); err == nil {
+		t.Fatal("getToken succeeded with reddit unreachable")
+	}
+	if cachedToken.httpClient != nil {
+		t.Error("a failed token request was cached")
+	}
+}
+
+func TestGetTokenRefusedIsAuth(t *testing.T) {
+	oldTransport := http.DefaultTransport
+	t.Cleanup(func() {
+		http.DefaultTransport = oldTransport
+		cachedToken = tokenCache{}
+	})
+
+	// unlike a thread, a 403 from the token endpoint is about our app
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		http.DefaultTransport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+		})
+		cachedToken = tokenCache{}
+
+		if _, _, err := getToken(context.Background()); !errors.Is(err, ErrRedditAuth) {
+			t.Errorf("status %d: err = %v, want ErrRedditAuth", status, err)
+		}
+	}
+}
+
+// listingOf wraps a post's data the way reddit lists it
+func listingOf(t *testing.T, postData string) map[string]interface{} {
+	t.Helper()
+	body := `{"kind": "Listing", "data": {"children": [{"kind": "t3", "data": ` + postData + `}]}}`
+
+	var listing map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &listing); err != nil {
+		t.Fatal(err)
+	}
+	return listing
+}
+
+// postListing is a self post's listing with extra fields added to the post
+func postListing(t *testing.T, extra string) map[string]interface{} {
+	t.Helper()
+	return listingOf(t, `{
+		"created_utc": 1700000000,
+		"permalink": "/r/summonerschool/comments/abc123/lux_vs_zed/",
+		"title": "Lux vs Zed",
+		"score": 412,
+		"selftext": "How do I survive his level 6?"`+extra+`
+	}`)
+}
+
+func TestParsePostConsensusSignals(t *testing.T) {
+	tests := []struct {
+		name            string
+		extra           string
+		wantRatio       float64
+		wantNumComments int
+	}{
+		{"present", `, "upvote_ratio": 0.94, "num_comments": 210`, 0.94, 210},
+		{"missing", "", 0, 0},
+		{"null", `, "upvote_ratio": null, "num_comments": null`, 0, 0},
+		{"only the ratio", `, "upvote_ratio": 0.5`, 0.5, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			post, err := parsePost(postListing(t, tt.extra))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if post.UpvoteRatio != tt.wantRatio || post.NumComments != tt.wantNumComments {
+				t.Errorf("upvote ratio %v and %d comments, want %v and %d", post.UpvoteRatio, post.NumComments, tt.wantRatio, tt.wantNumComments)
+			}
+			if post.Score != 412 || post.Title != "Lux vs Zed" {
+				t.Errorf("parsed %+v", post)
+			}
+		})
+	}
+}
+
+func TestParsePostLinkAndNullFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		post     string
+		wantBody string
+	}{
+		{
+			name:     "link post",
+			post:     `{"created_utc": 1700000000, "permalink": "/r/leagueoflegends/comments/abc123/zed_montage/", "title": "Zed montage", "score": 90, "selftext": "", "url": "https://youtu.be/abc", "is_self": false}`,
+			wantBody: "",
+		},
+		{
+			name:     "null selftext and flair",
+			post:     `{"created_utc": 1700000000, "permalink": "/r/leagueoflegends/comments/abc123/zed_montage/", "title": "Zed montage", "score": 90, "selftext": null, "link_flair_text": null, "upvote_ratio": null, "total_awards_received": null}`,
+			wantBody: "",
+		},
+		{
+			name:     "no selftext at all",
+			post:     `{"created_utc": 1700000000.0, "permalink": "/r/summonerschool/comments/abc123/lux_vs_zed/", "title": "Lux vs Zed", "score": 3}`,
+			wantBody: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			post, err := parsePost(listingOf(t, tt.post))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if post.Content != tt.wantBody || post.Flair != "" || post.Awards != 0 {
+				t.Errorf("parsed %+v", post)
+			}
+		})
+	}
+}
+
+func TestParsePostRejectsBrokenPosts(t *testing.T) {
+	tests := []struct {
+		name    string
+		listing string
+	}{
+		{"missing title", `{"kind": "Listing", "data": {"children": [{"kind": "t3", "data": {"created_utc": 1700000000, "permalink": "/r/a/comments/abc123/x/", "score": 1}}]}}`},
+		{"null title", `{"kind": "Listing", "data": {"children": [{"kind": "t3", "data": {"created_utc": 1700000000, "permalink": "/r/a/comments/abc123/x/", "title": null, "score": 1}}]}}`},
+		{"null created", `{"kind": "Listing", "data": {"children": [{"kind": "t3", "data": {"created_utc": null, "permalink": "/r/a/comments/abc123/x/", "title": "x", "score": 1}}]}}`},
+		{"score as a string", `{"kind": "Listing", "data": {"children": [{"kind": "t3", "data": {"created_utc": 1700000000, "permalink": "/r/a/comments/abc123/x/", "title": "x", "score": "1"}}]}}`},
+		{"permalink as a number", `{"kind": "Listing", "data": {"children": [{"kind": "t3", "data": {"created_utc": 1700000000, "permalink": 7, "title": "x", "score": 1}}]}}`},
+		{"null post", `{"kind": "Listing", "data": {"children": [{"kind": "t3", "data": null}]}}`},
+		{"no children", `{"kind": "Listing", "data": {"children": []}}`},
+		{"children not a list", `{"kind": "Listing", "data": {"children": {}}}`},
+		{"no data", `{"kind": "Listing"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var listing map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.listing), &listing); err != nil {
+				t.Fatal(err)
+			}
+			if post, err := parsePost(listing); err == nil {
+				t.Errorf("parsed %+v, want an error", post)
+			}
+		})
+	}
+}
+
+func TestParsePostURLHosts(t *testing.T) {
+	tests := []struct {
+		name          string
+		link          string
+		wantID        string
+		wantSubreddit string
+	}{
+		{"www", "https://www.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed/", "abc123", "summonerschool"},
+		{"old", "https://old.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed/", "abc123", "summonerschool"},
+		{"np", "https://np.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed/", "abc123", "summonerschool"},
+		{"mobile", "https://m.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed/", "abc123", "summonerschool"},
+		{"no www", "https://reddit.com/r/summonerschool/comments/abc123/lux_vs_zed/", "abc123", "summonerschool"},
+		{"uppercase host", "https://WWW.Reddit.COM/r/LuxMains/comments/abc123/", "abc123", "LuxMains"},
+		{"short link", "https://redd.it/abc123", "abc123", ""},
+		{"subreddit-less", "https://www.reddit.com/comments/abc123/lux_vs_zed/", "abc123", ""},
+		{"comment permalink", "https://www.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed/kx9f2a1/", "abc123", "summonerschool"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, subreddit, err := ParsePostURL(tt.link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != tt.wantID || subreddit != tt.wantSubreddit {
+				t.Errorf("ParsePostURL(%q) = %q, %q, want %q, %q", tt.link, id, subreddit, tt.wantID, tt.wantSubreddit)
+			}
+		})
+	}
+}
+
+func TestParsePostURLRejectsOtherHosts(t *testing.T) {
+	for _, link := range []string{
+		"https://notreddit.com/r/summonerschool/comments/abc123/",
+		"https://reddit.com.example.com/r/summonerschool/comments/abc123/",
+		"https://www.youtube.com/watch?v=abc123",
+		"https://redd.it/",
+		"https://www.reddit.com/r/summonerschool/",
+		"https://www.reddit.com/user/someone/comments/abc123/",
+	} {
+		if _, _, err := ParsePostURL(link); !errors.Is(err, ErrBadURL) {
+			t.Errorf("ParsePostURL(%q) err = %v, want ErrBadURL", link, err)
+		}
+	}
+}
+
+func TestParsePostURLMalformed(t *testing.T) {
+	tests := []struct {
+		link   string
+		wantID string
+	}{
+		{"http://www.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed/", "abc123"},
+		{"https://www.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed/?utm_source=share&utm_medium=web2x", "abc123"},
+		{"https://www.reddit.com/r/summonerschool/comments/abc123?context=3", "abc123"},
+		{"https://www.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed/#comments", "abc123"},
+		{"  https://www.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed/\n", "abc123"},
+	}
+	for _, tt := range tests {
+		if id, _, err := ParsePostURL(tt.link); err != nil || id != tt.wantID {
+			t.Errorf("ParsePostURL(%q) = %q, %v, want %q", tt.link, id, err, tt.wantID)
+		}
+	}
+
+	// none of these may panic, they used to be sliced at 8
+	for _, link := range []string{"", "h", "https:/", "https://", "reddit", "www.reddit.com/r/a/comments/abc123", "://bad", "https://www.reddit.com/r/a/comments/abc-123/", "https://www.reddit.com/r/a b/comments/abc123/"} {
+		_, _, err := ParsePostURL(link)
+		if !errors.Is(err, ErrBadURL) {
+			t.Errorf("ParsePostURL(%q) err = %v, want ErrBadURL", link, err)
+		} else if !strings.Contains(err.Error(), link) {
+			t.Errorf("ParsePostURL(%q) err = %v, want it to say which link", link, err)
+		}
+	}
+}
+
+// hangingTransport never answers, a request only ends when its context does
+var hangingTransport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+	<-r.Context().Done()
+	return nil, r.Context().Err()
+})
+
+// cancelSoon is a context cancelled shortly after the call starts
+func cancelSoon(t *testing.T) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestGetTokenStopsWhenCancelled(t *testing.T) {
+	oldTransport := http.DefaultTransport
+	http.DefaultTransport = hangingTransport
+	t.Cleanup(func() {
+		http.DefaultTransport = oldTransport
+		cachedToken = tokenCache{}
+	})
+	cachedToken = tokenCache{}
+
+	start := time.Now()
+	_, _, err := getToken(cancelSoon(t))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s to notice the cancellation", elapsed)
+	}
+}
+
+func TestScrapeThreadStopsWhenCancelled(t *testing.T) {
+	client := &http.Client{Transport: hangingTransport}
+	token := TokenResponse{AccessToken: "token", ExpiresIn: 3600}
+
+	start := time.Now()
+	_, err := scrapeThread(cancelSoon(t), client, token, "abc123", "summonerschool")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s to notice the cancellation", elapsed)
+	}
+}
@@ -0,0 +1,75 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"server/logging"
+	"server/models"
+)
+
+// braveProvider searches with the brave search api, which has a more
+// generous free tier than google custom search
+type braveProvider struct{}
+
+func (braveProvider) Name() string { return "brave" }
+
+type braveResponse struct {
+	Web struct {
+		Results []struct {
+			Title       string `json:"title"`
+			URL         string `json:"url"`
+			Description string `json:"description"`
+		} `json:"results"`
+	} `json:"web"`
+}
+
+func (braveProvider) Search(ctx context.Context, query string) (models.SearchResponse, error) {
+	apiKey := settings.BraveAPIKey
+	if apiKey == "" {
+		return models.SearchResponse{}, fmt.Errorf("BRAVE_SEARCH_API_KEY is not set")
+	}
+
+	searchURL := fmt.Sprintf("https://api.search.brave.com/res/v1/web/search?q=%s&count=%d",
+		url.QueryEscape(query), resultCount())
+
+	logging.FromContext(ctx).Debug("searching brave", "url", searchURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, searchURL, http.NoBody)
+	if err != nil {
+		return models.SearchResponse{}, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Subscription-Token", apiKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return models.SearchResponse{}, &models.UpstreamError{Service: "brave", Err: fmt.Errorf("failed to make request: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return models.SearchResponse{}, &models.UpstreamError{Service: "brave", Err: fmt.Errorf("brave search returned %d: %s", resp.StatusCode, body)}
+	}
+
+	var braveResults braveResponse
+	if err := json.NewDecoder(resp.Body).Decode(&braveResults); err != nil {
+		return models.SearchResponse{}, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	var searchResults models.SearchResponse
+	for _, result := range braveResults.Web.Results {
+		searchResults.Items = append(searchResults.Items, models.SearchItem{
+			Title:   result.Title,
+			Link:    result.URL,
+			Snippet: result.Description,
+		})
+	}
+
+	return searchResults, nil
+}
@@ -0,0 +1,46 @@
+package search
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"server/models"
+)
+
+// mockMode reports whether MOCK_MODE=true, in which case searches return
+// canned results instead of calling google
+func mockMode() bool {
+	return settings.MockMode
+}
+
+// mockSearch returns two fake reddit threads for the matchup, one in
+// r/summonerschool and one in r/leagueoflegends, with ids derived from the
+// query so different matchups don't share cached threads
+func mockSearch(q models.Query) models.SearchResponse {
+	slug := strings.ToLower(strings.ReplaceAll(fmt.Sprintf("%s_vs_%s_%s", q.Champion, q.Opponent, q.Role), " ", "_"))
+
+	return models.SearchResponse{
+		Items: []models.SearchItem{
+			{
+				Title:   fmt.Sprintf("%s vs %s %sguide", q.Champion, q.Opponent, roleTerm(q)),
+				Link:    fmt.Sprintf("https://www.reddit.com/r/summonerschool/comments/%s/%s/", mockPostID(slug, 1), slug),
+				Snippet: fmt.Sprintf("How do I play %s into %s?", q.Champion, q.Opponent),
+			},
+			{
+				Title:   fmt.Sprintf("%s vs %s matchup discussion", q.Opponent, q.Champion),
+				Link:    fmt.Sprintf("https://www.reddit.com/r/leagueoflegends/comments/%s/%s/", mockPostID(slug, 2), slug),
+				Snippet: fmt.Sprintf("Tips for the %s vs %s lane", q.Champion, q.Opponent),
+			},
+		},
+	}
+}
+
+// mockPostID is a reddit-style id for the nth mock thread about slug, a short
+// hash so each matchup's threads get their own ids (and survive
+// dedupeByPostID) but the same matchup always gets the same ones
+func mockPostID(slug string, n int) string {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s/%d", slug, n)
+	return fmt.Sprintf("%x", h.Sum32())
+}
@@ -0,0 +1,93 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"server/config"
+	"server/logging"
+	"server/metrics"
+	"server/models"
+)
+
+var (
+	settings config.Search
+	// subreddits whose threads are dropped, built from settings.Blocklist
+	blocklist map[string]bool
+)
+
+// Configure sets the search provider, its keys and the subreddit blocklist,
+// it must be called before anything is searched
+func Configure(c config.Search) {
+	settings = c
+	blocklist = newBlocklist(c.Blocklist)
+}
+
+// Provider is a web search backend, queries use google's operators (quoted
+// phrases, OR, site:) which the others understand too
+type Provider interface {
+	Name() string
+	Search(ctx context.Context, query string) (models.SearchResponse, error)
+}
+
+// googleProvider searches with google custom search
+type googleProvider struct{}
+
+func (googleProvider) Name() string { return "google" }
+
+func (googleProvider) Search(ctx context.Context, query string) (models.SearchResponse, error) {
+	return searchPage(ctx, query, resultCount())
+}
+
+// MultiProvider tries each provider in order, moving on to the next when one
+// fails (e.g. google's daily quota running out)
+type MultiProvider []Provider
+
+func (m MultiProvider) Name() string {
+	names := make([]string, len(m))
+	for i, p := range m {
+		names[i] = p.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (m MultiProvider) Search(ctx context.Context, query string) (models.SearchResponse, error) {
+	if len(m) == 0 {
+		return models.SearchResponse{}, fmt.Errorf("no search providers configured")
+	}
+
+	var err error
+	for _, p := range m {
+		var results models.SearchResponse
+		results, err = p.Search(ctx, query)
+		if err == nil {
+			return results, nil
+		}
+
+		metrics.SourceErrors.WithLabelValues(p.Name()).Inc()
+		logging.FromContext(ctx).Warn("search provider failed", "provider", p.Name(), "error", err)
+	}
+
+	return models.SearchResponse{}, fmt.Errorf("all search providers failed, last error: %w", err)
+}
+
+// providers builds the provider chain. The configured provider is the
+// primary, and the other is added as a fallback when its key is set.
+func providers() MultiProvider {
+	google, brave := Provider(googleProvider{}), Provider(braveProvider{})
+
+	if settings.Provider == "brave" {
+		chain := MultiProvider{brave}
+		if settings.GoogleAPIKey != "" {
+			chain = append(chain, google)
+		}
+		return chain
+	}
+
+	chain := MultiProvider{google}
+	if settings.BraveAPIKey != "" {
+		chain = append(chain, brave)
+	}
+	return chain
+}
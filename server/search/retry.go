@@ -0,0 +1,162 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"server/logging"
+	"server/models"
+)
+
+const (
+	searchMaxAttempts   = 3
+	searchRetryMaxDelay = 5 * time.Second
+)
+
+// the most the first retry waits, doubled for each one after up to
+// searchRetryMaxDelay. A var so tests don't have to wait on it.
+var searchRetryBaseDelay = 500 * time.Millisecond
+
+// QuotaError is returned when google's daily query quota has run out, which
+// retrying won't fix until the quota resets
+type QuotaError struct {
+	Reason string
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("search quota exceeded: %s", e.Reason)
+}
+
+// RetryAfter is how long until the quota resets, google does that at
+// midnight pacific time
+func (e *QuotaError) RetryAfter() time.Duration {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		return time.Hour
+	}
+
+	now := time.Now().In(loc)
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
+	return midnight.Sub(now)
+}
+
+// googleError is the error body google apis return alongside non-200 statuses
+type googleError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Errors  []struct {
+			Reason string `json:"reason"`
+		} `json:"errors"`
+	} `json:"error"`
+}
+
+// quotaReasons are the 403 reasons google uses for an exhausted quota
+var quotaReasons = map[string]bool{
+	"dailyLimitExceeded": true,
+	"quotaExceeded":      true,
+}
+
+// getWithRetry GETs url, retrying 429s and 5xxs with jittered exponential
+// backoff (or the server's Retry-After, if it sent one). Any other non-200
+// is returned as an error carrying google's message and reason rather than
+// being decoded as an empty result. secret is masked in anything logged or
+// returned.
+func getWithRetry(ctx context.Context, url string, secret string) (*http.Response, error) {
+	var err error
+	for attempt := 0; attempt < searchMaxAttempts; attempt++ {
+		var resp *http.Response
+		var retryAfter time.Duration
+		resp, retryAfter, err = get(ctx, url, secret)
+		if err == nil {
+			return resp, nil
+		}
+		if retryAfter < 0 || attempt == searchMaxAttempts-1 {
+			return nil, err
+		}
+
+		delay := retryAfter
+		if delay == 0 {
+			delay = time.Duration(rand.Int63n(int64(min(searchRetryBaseDelay<<attempt, searchRetryMaxDelay))))
+		}
+
+		logging.FromContext(ctx).Warn("search request failed, retrying",
+			"attempt", attempt+1, "max_attempts", searchMaxAttempts, "delay", delay, "error", err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return nil, err
+}
+
+// get makes a single request. On failure it also returns how long to wait
+// before retrying: 0 to pick a backoff, or -1 if it isn't worth retrying.
+func get(ctx context.Context, url string, secret string) (*http.Response, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to create request: %s", logging.Redact(err.Error(), secret))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, -1, ctx.Err()
+		}
+		// the error repeats the url, key included
+		return nil, 0, &models.UpstreamError{Service: "google", Err: fmt.Errorf("failed to make request: %s", logging.Redact(err.Error(), secret))}
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, 0, nil
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	// fall back to the raw body if it isn't google's usual error shape
+	var apiErr googleError
+	message := string(body)
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Error.Message != "" {
+		message = apiErr.Error.Message
+		if len(apiErr.Error.Errors) > 0 && apiErr.Error.Errors[0].Reason != "" {
+			message = fmt.Sprintf("%s (%s)", message, apiErr.Error.Errors[0].Reason)
+		}
+	}
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+	err = &models.UpstreamError{Service: "google", Err: fmt.Errorf("search returned %d: %s", resp.StatusCode, logging.Redact(message, secret))}
+
+	switch {
+	case resp.StatusCode == http.StatusForbidden:
+		for _, e := range apiErr.Error.Errors {
+			if quotaReasons[e.Reason] {
+				return nil, -1, &QuotaError{Reason: e.Reason}
+			}
+		}
+		return nil, -1, err
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, parseRetryAfter(resp.Header.Get("Retry-After")), err
+	default:
+		return nil, -1, err
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds, returning 0 if
+// it's missing or in a form we don't handle
+func parseRetryAfter(v string) time.Duration {
+	seconds, err := strconv.Atoi(v)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return min(time.Duration(seconds)*time.Second, searchRetryMaxDelay)
+}
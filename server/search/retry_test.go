@@ -0,0 +1,169 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"server/config"
+	"server/models"
+)
+
+func TestSearchPageSurfacesErrorStatuses(t *testing.T) {
+	oldDelay := searchRetryBaseDelay
+	searchRetryBaseDelay = time.Millisecond
+	t.Cleanup(func() { searchRetryBaseDelay = oldDelay })
+
+	tests := []struct {
+		name      string
+		code      int
+		body      string
+		wantErr   string
+		wantQuota bool
+		wantCalls int
+	}{
+		{
+			name:      "forbidden",
+			code:      http.StatusForbidden,
+			body:      `{"error": {"code": 403, "message": "Custom Search API has not been used in project 123 before", "errors": [{"reason": "accessNotConfigured"}]}}`,
+			wantErr:   "search returned 403: Custom Search API has not been used in project 123 before (accessNotConfigured)",
+			wantCalls: 1,
+		},
+		{
+			name:      "quota",
+			code:      http.StatusForbidden,
+			body:      `{"error": {"code": 403, "message": "Quota exceeded for quota metric 'Queries'", "errors": [{"reason": "dailyLimitExceeded"}]}}`,
+			wantQuota: true,
+			wantCalls: 1,
+		},
+		{
+			name:      "bad query",
+			code:      http.StatusBadRequest,
+			body:      `{"error": {"code": 400, "message": "Request contains an invalid argument.", "errors": [{"reason": "badRequest"}]}}`,
+			wantErr:   "search returned 400: Request contains an invalid argument. (badRequest)",
+			wantCalls: 1,
+		},
+		{
+			name:      "not google's error shape",
+			code:      http.StatusBadGateway,
+			body:      "<html>bad gateway</html>",
+			wantErr:   "search returned 502: <html>bad gateway</html>",
+			wantCalls: searchMaxAttempts,
+		},
+		{
+			name:      "empty body",
+			code:      http.StatusServiceUnavailable,
+			wantErr:   "search returned 503: Service Unavailable",
+			wantCalls: searchMaxAttempts,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withSettings(t, config.Search{Provider: "google", GoogleAPIKey: "key", GoogleCSEID: "cse", ResultCount: 4})
+			calls := 0
+			withTransport(t, roundTripFunc(func(*http.Request) (*http.Response, error) {
+				calls++
+				return stringResponse(tt.code, tt.body), nil
+			}))
+
+			results, err := searchPage(context.Background(), "query", 10)
+			if err == nil {
+				t.Fatalf("got %+v and no error", results)
+			}
+
+			var quotaErr *QuotaError
+			if isQuota := errors.As(err, &quotaErr); isQuota != tt.wantQuota {
+				t.Errorf("err = %v, quota error %v, want %v", err, isQuota, tt.wantQuota)
+			}
+			if tt.wantErr != "" {
+				var upstreamErr *models.UpstreamError
+				if !errors.As(err, &upstreamErr) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want an upstream error with %q", err, tt.wantErr)
+				}
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("made %d requests, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestSearchPageRetriesServerErrors(t *testing.T) {
+	oldDelay := searchRetryBaseDelay
+	searchRetryBaseDelay = time.Millisecond
+	t.Cleanup(func() { searchRetryBaseDelay = oldDelay })
+	withSettings(t, config.Search{Provider: "google", GoogleAPIKey: "key", GoogleCSEID: "cse", ResultCount: 4})
+
+	calls := 0
+	withTransport(t, roundTripFunc(func(*http.Request) (*http.Response, error) {
+		calls++
+		if calls == 1 {
+			return stringResponse(http.StatusInternalServerError, `{"error": {"code": 500, "message": "Backend Error"}}`), nil
+		}
+		return googleResponse(t, []models.SearchItem{{Title: "Lux vs Zed", Link: "https://www.reddit.com/r/summonerschool/comments/aaa111/lux_vs_zed/"}}), nil
+	}))
+
+	results, err := searchPage(context.Background(), "query", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Items) != 1 || calls != 2 {
+		t.Errorf("got %d results after %d requests, want 1 after a retry", len(results.Items), calls)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"2", 2 * time.Second},
+		{"3600", searchRetryMaxDelay},
+		{"-1", 0},
+		{"Wed, 21 Oct 2015 07:28:00 GMT", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestSearchFailsOnForbidden(t *testing.T) {
+	withSettings(t, config.Search{Provider: "google", GoogleAPIKey: "key", GoogleCSEID: "cse", ResultCount: 4})
+	withTransport(t, roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return stringResponse(http.StatusForbidden, `{"error": {"code": 403, "message": "The caller does not have permission", "errors": [{"reason": "forbidden"}]}}`), nil
+	}))
+
+	// rather than no results, which reads as nobody discussing the matchup
+	results, err := Search(context.Background(), models.Query{Champion: "Lux", Opponent: "Zed", Role: "mid"})
+	if err == nil || !strings.Contains(err.Error(), "The caller does not have permission") {
+		t.Errorf("got %+v, err = %v, want google's error", results, err)
+	}
+}
+
+func TestSearchStopsWhenCancelled(t *testing.T) {
+	withSettings(t, config.Search{Provider: "google", GoogleAPIKey: "key", GoogleCSEID: "cse", ResultCount: 4})
+	withTransport(t, roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		<-r.Context().Done()
+		return nil, r.Context().Err()
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	if _, err := Search(ctx, models.Query{Champion: "Lux", Opponent: "Zed", Role: "mid"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s to notice the cancellation", elapsed)
+	}
+}
@@ -1,37 +1,110 @@
 package search
 
 import (
+	"cmp"
+	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"net/url"
-	"os"
+	"server/logging"
+	"server/metrics"
 	"server/models"
+	"server/scrape"
+	"slices"
 	"strings"
-
-	"github.com/joho/godotenv"
+	"time"
 )
 
-func Search(q models.Query) (models.SearchResponse, error) {
-	err := godotenv.Load(".env")
+// resultCount is SEARCH_RESULT_COUNT, the number of results asked for, at
+// most the 10 google returns per request
+func resultCount() int {
+	return settings.ResultCount
+}
+
+func Search(ctx context.Context, q models.Query) (models.SearchResponse, error) {
+	if mockMode() {
+		return mockSearch(q), nil
+	}
+
+	defer metrics.ObserveStage("search", time.Now())
+
+	searchResults, err := providers().Search(ctx, buildQuery(q))
 	if err != nil {
-		return models.SearchResponse{}, fmt.Errorf(".env file not found: %s", err)
+		return models.SearchResponse{}, err
+	}
+
+	// filter irrelevant results
+	filteredItems := filterSearchResults(searchResults.Items, q.Champion, q.Opponent)
+
+	if len(filteredItems) < settings.MinResults {
+		logging.FromContext(ctx).Info("few search results, broadening query", "results", len(filteredItems))
+
+		// the first pass already succeeded, so a failure here just means
+		// making do with what we have
+		broad, err := providers().Search(ctx, buildBroadQuery(q))
+		if err != nil {
+			logging.FromContext(ctx).Warn("broadened search failed", "error", err)
+		} else {
+			filteredItems = filterSearchResults(append(filteredItems, broad.Items...), q.Champion, q.Opponent)
+			// every result costs a scrape and a summary, keep to the usual count
+			filteredItems = filteredItems[:min(len(filteredItems), resultCount())]
+		}
 	}
 
-	API_KEY := os.Getenv("CUSTOM_SEARCH_API_KEY")
-	CSE_ID := os.Getenv("CUSTOM_SEARCH_CSE_ID")
+	searchResults.Items = filteredItems
+	return searchResults, nil
+}
+
+// buildQuery matches threads titled with the matchup in either order, people
+// write "Lux vs Morgana" and "Morgana vs Lux" about equally, and with any of
+// the champions' common spellings ("Kaisa vs Velkoz")
+func buildQuery(q models.Query) string {
+	// spellings are paired up rather than crossed, google stops reading
+	// queries after 32 words
+	champions, opponents := models.SearchSpellings(q.Champion), models.SearchSpellings(q.Opponent)
+	var phrases []string
+	for i := range max(len(champions), len(opponents)) {
+		champion, opponent := champions[min(i, len(champions)-1)], opponents[min(i, len(opponents)-1)]
+		phrases = append(phrases,
+			fmt.Sprintf("\"%s vs %s\"", champion, opponent),
+			fmt.Sprintf("\"%s vs %s\"", opponent, champion))
+	}
+
+	return fmt.Sprintf("(%s) %ssite:reddit.com", strings.Join(phrases, " OR "), roleTerm(q))
+}
+
+// buildBroadQuery drops the exact phrase for rare matchups without a
+// dedicated "vs" thread, and sticks to r/summonerschool and both champions'
+// mains subs, where general questions about a lane tend to mention both
+// champions anyway
+func buildBroadQuery(q models.Query) string {
+	return fmt.Sprintf("%s %s %s(site:reddit.com/r/summonerschool OR site:reddit.com/r/%s OR site:reddit.com/r/%s)",
+		q.Champion, q.Opponent, roleTerm(q), models.MainsSubreddit(q.Champion), models.MainsSubreddit(q.Opponent))
+}
+
+// roleTerm is the role followed by a space for the query, or nothing for
+// RoleAny so threads about the matchup in any lane match
+func roleTerm(q models.Query) string {
+	if q.Role == models.RoleAny {
+		return ""
+	}
+	return q.Role + " "
+}
+
+// searchPage fetches the first page of num results
+func searchPage(ctx context.Context, searchQuery string, num int) (models.SearchResponse, error) {
+	API_KEY := settings.GoogleAPIKey
+	CSE_ID := settings.GoogleCSEID
 
-	// better query
-	searchQuery := fmt.Sprintf("\"%s vs %s\" %s site:reddit.com", q.Champion, q.Opponent, q.Role)
 	searchURL := fmt.Sprintf("https://www.googleapis.com/customsearch/v1?q=%s&key=%s&cx=%s&num=%d",
 		url.QueryEscape(searchQuery),
-		API_KEY, CSE_ID, 4) // Increased to 10 to have more results to filter
+		API_KEY, CSE_ID, num)
 
-	fmt.Println(searchURL)
+	logging.FromContext(ctx).Debug("searching google", "url", logging.Redact(searchURL, API_KEY))
 
-	resp, err := http.Get(searchURL)
+	resp, err := getWithRetry(ctx, searchURL, API_KEY)
 	if err != nil {
-		return models.SearchResponse{}, fmt.Errorf("failed to make request: %v", err)
+		return models.SearchResponse{}, err
 	}
 	defer resp.Body.Close()
 
@@ -40,25 +113,87 @@ func Search(q models.Query) (models.SearchResponse, error) {
 		return models.SearchResponse{}, fmt.Errorf("failed to decode response: %v", err)
 	}
 
-	// filter irrelevant results
-	filteredItems := filterSearchResults(searchResults.Items, q.Champion, q.Opponent)
-	searchResults.Items = filteredItems
-
 	return searchResults, nil
 }
 
+// filterSearchResults drops blocked and duplicate threads, along with any
+// whose title and snippet don't name both champions (in either order), which
+// are usually about some other matchup that happened to rank. What's left is
+// ordered by subreddit authority so trimming to the result count keeps the
+// most trusted threads.
 func filterSearchResults(items []models.SearchItem, champion, opponent string) []models.SearchItem {
 	var filteredItems []models.SearchItem
 	for _, item := range items {
-		if isRelevantResult(item) {
+		if isRelevantResult(item) && mentionsMatchup(item, champion, opponent) && models.LinkWeight(item.Link, champion) > 0 {
 			filteredItems = append(filteredItems, item)
 		}
 	}
-	return filteredItems
+
+	// stable, so google's ranking decides between equally trusted subreddits
+	slices.SortStableFunc(filteredItems, func(a, b models.SearchItem) int {
+		return cmp.Compare(models.LinkWeight(b.Link, champion), models.LinkWeight(a.Link, champion))
+	})
+	return dedupeByPostID(filteredItems)
 }
 
-func isRelevantResult(item models.SearchItem) bool {
+// dedupeByPostID keeps the first item for each reddit thread, with its link
+// made canonical. Google often returns the same post under several slug
+// variants, and once the broadened search falls back to another provider the
+// same thread can come back from both with a different host or query string.
+func dedupeByPostID(items []models.SearchItem) []models.SearchItem {
+	seen := make(map[string]bool)
+	var dedupedItems []models.SearchItem
+	for _, item := range items {
+		postID, _, err := scrape.ParsePostURL(item.Link)
+		if err != nil {
+			// not a thread link we can identify, let the scraper decide
+			dedupedItems = append(dedupedItems, item)
+			continue
+		}
+
+		if seen[postID] {
+			continue
+		}
+		seen[postID] = true
+
+		// the link parsed above so this can't fail
+		item.Link, _ = scrape.CanonicalPostURL(item.Link)
+		dedupedItems = append(dedupedItems, item)
+	}
+	return dedupedItems
+}
 
-	return !strings.Contains(item.Link, "NoStupidQuestions") || strings.Contains(item.Link, "RelayForReddit")
+// subreddits we never want advice from unless configured otherwise
+var defaultBlocklist = []string{"NoStupidQuestions"}
+
+// newBlocklist is names (SUBREDDIT_BLOCKLIST or blocklist.json) as a set of
+// lowercase names, or the default list if none are configured
+func newBlocklist(names []string) map[string]bool {
+	if names == nil {
+		names = defaultBlocklist
+	}
+
+	blocklist := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "r/"))
+		if name != "" {
+			blocklist[name] = true
+		}
+	}
+	return blocklist
+}
+
+func mentionsMatchup(item models.SearchItem, champion, opponent string) bool {
+	text := item.Title + " " + item.Snippet
+	return models.MentionsChampion(text, champion) && models.MentionsChampion(text, opponent)
+}
+
+func isRelevantResult(item models.SearchItem) bool {
+	_, subreddit, err := scrape.ParsePostURL(item.Link)
+	if err != nil {
+		// can't tell which subreddit it's from so don't block it here
+		return true
+	}
 
+	return !blocklist[strings.ToLower(subreddit)]
 }
@@ -0,0 +1,479 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"server/config"
+	"server/models"
+	"server/scrape"
+)
+
+func TestFilterSearchResultsPrefersAuthoritativeSubreddits(t *testing.T) {
+	defer models.SetSubredditWeights(nil)
+	models.SetSubredditWeights(nil)
+
+	items := []models.SearchItem{
+		{Title: "Lux vs Zed is unplayable", Link: "https://www.reddit.com/r/leagueoflegends/comments/aaa111/lux_vs_zed/"},
+		{Title: "How do I beat Zed as Lux?", Link: "https://www.reddit.com/r/lolgaming/comments/bbb222/lux_zed/"},
+		{Title: "Lux vs Zed mid guide", Link: "https://www.reddit.com/r/summonerschool/comments/ccc333/lux_vs_zed_mid/"},
+		{Title: "Zed matchup as Lux", Link: "https://www.reddit.com/r/LuxMains/comments/ddd444/zed_matchup/"},
+	}
+
+	got := filterSearchResults(items, "Lux", "Zed")
+
+	want := []string{"ddd444", "ccc333", "bbb222", "aaa111"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(got), len(want), got)
+	}
+	for i, id := range want {
+		if postID, _, _ := scrape.ParsePostURL(got[i].Link); postID != id {
+			t.Errorf("result %d is %s, want %s", i, got[i].Link, id)
+		}
+	}
+
+	// trimming to the result count keeps the most trusted threads
+	trimmed := got[:2]
+	for _, item := range trimmed {
+		if models.LinkWeight(item.Link, "Lux") < 1.5 {
+			t.Errorf("%s was kept over a more authoritative thread", item.Link)
+		}
+	}
+}
+
+func TestFilterSearchResultsDropsZeroWeightSubreddits(t *testing.T) {
+	defer models.SetSubredditWeights(nil)
+	models.SetSubredditWeights(map[string]float64{"leagueoflegends": 0})
+
+	items := []models.SearchItem{
+		{Title: "Lux vs Zed is unplayable", Link: "https://www.reddit.com/r/leagueoflegends/comments/aaa111/lux_vs_zed/"},
+		{Title: "Lux vs Zed mid guide", Link: "https://www.reddit.com/r/summonerschool/comments/ccc333/lux_vs_zed_mid/"},
+	}
+
+	got := filterSearchResults(items, "Lux", "Zed")
+	if len(got) != 1 || got[0].Link != "https://www.reddit.com/r/summonerschool/comments/ccc333" {
+		t.Errorf("got %+v, want only the summonerschool thread", got)
+	}
+}
+
+func TestFilterSearchResultsDedupesSlugVariants(t *testing.T) {
+	defer models.SetSubredditWeights(nil)
+	models.SetSubredditWeights(nil)
+
+	items := []models.SearchItem{
+		{Title: "Lux vs Zed mid guide", Link: "https://www.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed_mid/"},
+		{Title: "Lux vs Zed mid guide", Link: "https://www.reddit.com/r/summonerschool/comments/abc123/"},
+		{Title: "Lux vs Zed mid guide", Link: "https://www.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed_mid/kx9f2a1/"},
+		{Title: "Lux vs Zed mid guide", Link: "https://www.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed"},
+		{Title: "Zed vs Lux, any tips?", Link: "https://www.reddit.com/r/summonerschool/comments/def456/zed_vs_lux_any_tips/"},
+	}
+
+	got := filterSearchResults(items, "Lux", "Zed")
+
+	want := []string{
+		"https://www.reddit.com/r/summonerschool/comments/abc123",
+		"https://www.reddit.com/r/summonerschool/comments/def456",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(got), len(want), got)
+	}
+	for i, link := range want {
+		if got[i].Link != link {
+			t.Errorf("result %d is %s, want %s", i, got[i].Link, link)
+		}
+	}
+}
+
+func TestConfigureRebuildsBlocklist(t *testing.T) {
+	items := []models.SearchItem{
+		{Title: "Lux vs Zed", Link: "https://www.reddit.com/r/NoStupidQuestions/comments/aaa111/lux_vs_zed/"},
+		{Title: "Lux vs Zed", Link: "https://www.reddit.com/r/leagueoflegends/comments/bbb222/lux_vs_zed/"},
+	}
+
+	tests := []struct {
+		name      string
+		blocklist []string
+		want      []string
+	}{
+		{"default", nil, []string{"bbb222"}},
+		{"configured", []string{" r/LeagueOfLegends "}, []string{"aaa111"}},
+		{"empty", []string{}, []string{"aaa111", "bbb222"}},
+	}
+
+	// each configure replaces the last one's list
+	for _, tt := range tests {
+		withSettings(t, config.Search{Blocklist: tt.blocklist})
+
+		var got []string
+		for _, item := range filterSearchResults(items, "Lux", "Zed") {
+			postID, _, _ := scrape.ParsePostURL(item.Link)
+			got = append(got, postID)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: kept %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBuildQuery(t *testing.T) {
+	tests := []struct {
+		q    models.Query
+		want string
+	}{
+		{models.Query{Champion: "Lux", Opponent: "Zed", Role: "mid"}, `("Lux vs Zed" OR "Zed vs Lux") mid site:reddit.com`},
+		{models.Query{Champion: "Lux", Opponent: "Morgana", Role: models.RoleAny}, `("Lux vs Morgana" OR "Morgana vs Lux") site:reddit.com`},
+		// each champion's other spelling is paired with the other's, or its only one
+		{models.Query{Champion: "Kai'Sa", Opponent: "Lux", Role: models.RoleAny}, `("Kai'Sa vs Lux" OR "Lux vs Kai'Sa" OR "KaiSa vs Lux" OR "Lux vs KaiSa") site:reddit.com`},
+		{models.Query{Champion: "Kai'Sa", Opponent: "Dr. Mundo", Role: models.RoleAny}, `("Kai'Sa vs Dr. Mundo" OR "Dr. Mundo vs Kai'Sa" OR "KaiSa vs mundo" OR "mundo vs KaiSa") site:reddit.com`},
+		{models.Query{Champion: "Lee Sin", Opponent: "Nunu & Willump", Role: models.RoleAny}, `("Lee Sin vs Nunu & Willump" OR "Nunu & Willump vs Lee Sin" OR "Lee Sin vs nunu" OR "nunu vs Lee Sin") site:reddit.com`},
+	}
+
+	for _, tt := range tests {
+		if got := buildQuery(tt.q); got != tt.want {
+			t.Errorf("buildQuery(%+v) = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestBuildQueryFitsGoogleWordLimit(t *testing.T) {
+	// the longest names, each with a second spelling
+	q := models.Query{Champion: "Nunu & Willump", Opponent: "Aurelion Sol", Role: "jungle"}
+
+	// OR counts as a word, site:reddit.com as one
+	if words := len(strings.Fields(buildQuery(q))); words > 32 {
+		t.Errorf("query for %+v is %d words, google ignores everything past 32", q, words)
+	}
+}
+
+func TestFilterSearchResultsKeepsBothOrderings(t *testing.T) {
+	items := []models.SearchItem{
+		{Title: "Lux vs Morgana bot lane", Link: "https://www.reddit.com/r/summonerschool/comments/aaa111/lux_vs_morgana/"},
+		{Title: "Morgana vs Lux, who wins?", Link: "https://www.reddit.com/r/summonerschool/comments/bbb222/morgana_vs_lux/"},
+	}
+
+	if got := filterSearchResults(items, "Lux", "Morgana"); len(got) != 2 {
+		t.Errorf("got %+v, want threads titled in either order", got)
+	}
+}
+
+func TestFilterSearchResultsRequiresBothChampions(t *testing.T) {
+	items := []models.SearchItem{
+		{Title: "Lux vs Zed mid, how do I survive his level 6?", Link: "https://www.reddit.com/r/summonerschool/comments/aaa111/lux_vs_zed_mid/"},
+		{Title: "Struggling in lane", Snippet: "Every game as Lux I get one shot by Zed before I can E", Link: "https://www.reddit.com/r/summonerschool/comments/bbb222/struggling_in_lane/"},
+		{Title: "ZED'S SHADOWS on lux are broken", Link: "https://www.reddit.com/r/leagueoflegends/comments/ccc333/zeds_shadows/"},
+		// only one of them
+		{Title: "Lux vs Syndra mid", Snippet: "Syndra outranges me all game", Link: "https://www.reddit.com/r/summonerschool/comments/ddd444/lux_vs_syndra/"},
+		{Title: "How to play Zed into control mages", Link: "https://www.reddit.com/r/summonerschool/comments/eee555/zed_control_mages/"},
+		// neither
+		{Title: "Ahri vs Syndra mid", Link: "https://www.reddit.com/r/summonerschool/comments/fff666/ahri_vs_syndra/"},
+		// names inside other words don't count
+		{Title: "Zedd concert was deluxe", Link: "https://www.reddit.com/r/summonerschool/comments/ggg777/zedd/"},
+	}
+
+	got := filterSearchResults(items, "Lux", "Zed")
+
+	want := map[string]bool{"aaa111": true, "bbb222": true, "ccc333": true}
+	if len(got) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(got), len(want), got)
+	}
+	for _, item := range got {
+		if postID, _, _ := scrape.ParsePostURL(item.Link); !want[postID] {
+			t.Errorf("%s (%q) was kept", item.Link, item.Title)
+		}
+	}
+}
+
+func TestDedupeByPostIDAcrossProviders(t *testing.T) {
+	items := []models.SearchItem{
+		// google
+		{Title: "Lux vs Zed mid guide", Link: "https://www.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed_mid/"},
+		{Title: "Zed vs Lux", Link: "https://www.reddit.com/r/LuxMains/comments/def456/zed_vs_lux/"},
+		// the same threads from brave
+		{Title: "Lux vs Zed mid guide", Link: "https://old.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed_mid/?utm_source=share"},
+		{Title: "Zed vs Lux", Link: "https://WWW.REDDIT.COM/r/LuxMains/comments/def456"},
+		{Title: "Lux vs Zed mid guide", Link: "https://redd.it/abc123"},
+		{Title: "Lux vs Zed, new thread", Link: "https://np.reddit.com/r/summonerschool/comments/ghi789/lux_vs_zed/#comments"},
+	}
+
+	got := dedupeByPostID(items)
+
+	want := []string{
+		"https://www.reddit.com/r/summonerschool/comments/abc123",
+		"https://www.reddit.com/r/LuxMains/comments/def456",
+		"https://www.reddit.com/r/summonerschool/comments/ghi789",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(got), len(want), got)
+	}
+	for i, link := range want {
+		if got[i].Link != link {
+			t.Errorf("result %d is %s, want %s", i, got[i].Link, link)
+		}
+	}
+}
+
+func TestMockSearchIDsAreDerivedFromTheMatchup(t *testing.T) {
+	seen := make(map[string]string)
+	for _, q := range []models.Query{
+		{Champion: "Lux", Opponent: "Zed", Role: "mid"},
+		{Champion: "Zed", Opponent: "Lux", Role: "mid"},
+		{Champion: "Lux", Opponent: "Zed", Role: "support"},
+		{Champion: "Miss Fortune", Opponent: "Kai'Sa", Role: "bot"},
+	} {
+		items := mockSearch(q).Items
+		if got := dedupeByPostID(items); len(got) != len(items) {
+			t.Errorf("%+v: %d of %d mock threads survived deduping", q, len(got), len(items))
+		}
+
+		for _, item := range items {
+			postID, _, err := scrape.ParsePostURL(item.Link)
+			if err != nil {
+				t.Fatalf("%+v: mock link %s: %v", q, item.Link, err)
+			}
+			if other, ok := seen[postID]; ok {
+				t.Errorf("%+v: %s shares id %s with %s", q, item.Link, postID, other)
+			}
+			seen[postID] = item.Link
+		}
+
+		if again := mockSearch(q).Items; again[0].Link != items[0].Link {
+			t.Errorf("%+v: mock link changed from %s to %s", q, items[0].Link, again[0].Link)
+		}
+	}
+}
+
+func TestSearchDedupesBroadenedResultsFromFallbackProvider(t *testing.T) {
+	withSettings(t, config.Search{Provider: "google", GoogleAPIKey: "key", GoogleCSEID: "cse", BraveAPIKey: "brave", ResultCount: 4, MinResults: 3})
+
+	q := models.Query{Champion: "Lux", Opponent: "Zed", Role: "mid"}
+	withTransport(t, roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Host == "api.search.brave.com" {
+			return stringResponse(http.StatusOK, `{"web": {"results": [
+				{"title": "Lux vs Zed mid guide", "url": "https://old.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed_mid/?utm_source=share"},
+				{"title": "Zed matchup as Lux", "url": "https://www.reddit.com/r/LuxMains/comments/def456/zed_matchup/"}
+			]}}`), nil
+		}
+
+		// google's quota runs out after the first pass
+		if r.URL.Query().Get("q") == buildBroadQuery(q) {
+			return stringResponse(http.StatusForbidden, `{"error": {"code": 403, "errors": [{"reason": "dailyLimitExceeded"}]}}`), nil
+		}
+		return googleResponse(t, []models.SearchItem{
+			{Title: "Lux vs Zed mid guide", Link: "https://www.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed_mid/"},
+		}), nil
+	}))
+
+	results, err := Search(context.Background(), q)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+
+	want := map[string]bool{
+		"https://www.reddit.com/r/summonerschool/comments/abc123": true,
+		"https://www.reddit.com/r/LuxMains/comments/def456":       true,
+	}
+	if len(results.Items) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(results.Items), len(want), results.Items)
+	}
+	for _, item := range results.Items {
+		if !want[item.Link] {
+			t.Errorf("unexpected result %s", item.Link)
+		}
+	}
+}
+
+func TestBuildBroadQuery(t *testing.T) {
+	tests := []struct {
+		q    models.Query
+		want string
+	}{
+		{
+			models.Query{Champion: "Lux", Opponent: "Zed", Role: "mid"},
+			"Lux Zed mid (site:reddit.com/r/summonerschool OR site:reddit.com/r/luxmains OR site:reddit.com/r/zedmains)",
+		},
+		{
+			models.Query{Champion: "Kai'Sa", Opponent: "Dr. Mundo", Role: models.RoleAny},
+			"Kai'Sa Dr. Mundo (site:reddit.com/r/summonerschool OR site:reddit.com/r/kaisamains OR site:reddit.com/r/drmundomains)",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := buildBroadQuery(tt.q); got != tt.want {
+			t.Errorf("buildBroadQuery(%+v) = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestSearchBroadensOnlyWhenThin(t *testing.T) {
+	q := models.Query{Champion: "Lux", Opponent: "Zed", Role: "mid"}
+	broad := buildBroadQuery(q)
+
+	tests := []struct {
+		name       string
+		firstPass  []models.SearchItem
+		wantBroad  bool
+		wantResult int
+	}{
+		{
+			name: "enough results",
+			firstPass: []models.SearchItem{
+				{Title: "Lux vs Zed mid", Link: "https://www.reddit.com/r/summonerschool/comments/aaa111/lux_vs_zed/"},
+				{Title: "Zed vs Lux tips", Link: "https://www.reddit.com/r/leagueoflegends/comments/bbb222/zed_vs_lux/"},
+			},
+			wantResult: 2,
+		},
+		{
+			name: "one result",
+			firstPass: []models.SearchItem{
+				{Title: "Lux vs Zed mid", Link: "https://www.reddit.com/r/summonerschool/comments/aaa111/lux_vs_zed/"},
+			},
+			wantBroad:  true,
+			wantResult: 2,
+		},
+		{
+			name: "only irrelevant results",
+			firstPass: []models.SearchItem{
+				{Title: "Ahri vs Zed mid", Link: "https://www.reddit.com/r/summonerschool/comments/ccc333/ahri_vs_zed/"},
+				{Title: "Lux vs Zed", Link: "https://www.reddit.com/r/NoStupidQuestions/comments/ddd444/lux_vs_zed/"},
+			},
+			wantBroad:  true,
+			wantResult: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withSettings(t, config.Search{Provider: "google", GoogleAPIKey: "key", GoogleCSEID: "cse", ResultCount: 4, MinResults: 2})
+
+			var queries []string
+			withTransport(t, roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				query := r.URL.Query().Get("q")
+				queries = append(queries, query)
+
+				items := tt.firstPass
+				if query == broad {
+					items = []models.SearchItem{
+						{Title: "Zed matchup as Lux", Link: "https://www.reddit.com/r/LuxMains/comments/eee555/zed_matchup/"},
+					}
+				}
+				return googleResponse(t, items), nil
+			}))
+
+			results, err := Search(context.Background(), q)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+
+			if broadened := len(queries) == 2 && queries[1] == broad; broadened != tt.wantBroad {
+				t.Errorf("queries were %q, broadened = %v, want %v", queries, broadened, tt.wantBroad)
+			}
+			if len(results.Items) != tt.wantResult {
+				t.Errorf("got %d results, want %d: %+v", len(results.Items), tt.wantResult, results.Items)
+			}
+		})
+	}
+}
+
+func TestSearchNeverLogsAPIKey(t *testing.T) {
+	// escapes differently in a url, both forms have to be masked
+	const apiKey = "AIzaSy/test+key"
+
+	var logs bytes.Buffer
+	oldLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(oldLogger) })
+
+	oldDelay := searchRetryBaseDelay
+	searchRetryBaseDelay = time.Millisecond
+	t.Cleanup(func() { searchRetryBaseDelay = oldDelay })
+
+	q := models.Query{Champion: "Lux", Opponent: "Zed", Role: "mid"}
+	tests := []struct {
+		name string
+		rt   roundTripFunc
+	}{
+		{"success", func(*http.Request) (*http.Response, error) {
+			return googleResponse(t, []models.SearchItem{{Title: "Lux vs Zed", Link: "https://www.reddit.com/r/summonerschool/comments/aaa111/lux_vs_zed/"}}), nil
+		}},
+		// the client's error repeats the url it was fetching
+		{"unreachable", func(r *http.Request) (*http.Response, error) {
+			return nil, errors.New("dial tcp: connection refused")
+		}},
+		{"key echoed back", func(r *http.Request) (*http.Response, error) {
+			return stringResponse(http.StatusBadRequest, `{"error": {"code": 400, "message": "API key `+apiKey+` not valid", "errors": [{"reason": "badRequest"}]}}`), nil
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			withSettings(t, config.Search{Provider: "google", GoogleAPIKey: apiKey, GoogleCSEID: "cse", ResultCount: 4})
+			withTransport(t, tt.rt)
+
+			_, err := Search(context.Background(), q)
+			if logs.Len() == 0 {
+				t.Fatal("nothing was logged")
+			}
+
+			out := logs.String()
+			if err != nil {
+				out += err.Error()
+			}
+			for _, leaked := range []string{apiKey, url.QueryEscape(apiKey)} {
+				if strings.Contains(out, leaked) {
+					t.Errorf("the api key was logged or returned:\n%s", out)
+				}
+			}
+		})
+	}
+}
+
+// roundTripFunc stands in for the network in tests
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// withTransport sends the default client's requests to rt for the rest of
+// the test
+func withTransport(t *testing.T, rt http.RoundTripper) {
+	t.Helper()
+	old := http.DefaultClient.Transport
+	http.DefaultClient.Transport = rt
+	t.Cleanup(func() { http.DefaultClient.Transport = old })
+}
+
+func withSettings(t *testing.T, s config.Search) {
+	t.Helper()
+	old := settings
+	Configure(s)
+	t.Cleanup(func() { Configure(old) })
+}
+
+// googleResponse is a custom search response listing items
+func googleResponse(t *testing.T, items []models.SearchItem) *http.Response {
+	t.Helper()
+	body, err := json.Marshal(models.SearchResponse{Items: items})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stringResponse(http.StatusOK, string(body))
+}
+
+func stringResponse(code int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: code,
+		Status:     http.StatusText(code),
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
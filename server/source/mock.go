@@ -0,0 +1,25 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"server/models"
+)
+
+// mockFetcher is used in MOCK_MODE and returns the same record for every
+// matchup
+type mockFetcher struct{}
+
+func (mockFetcher) Name() string { return "stats" }
+
+func (mockFetcher) Fetch(ctx context.Context, q models.Query) (Stats, error) {
+	slug := strings.ToLower(strings.ReplaceAll(fmt.Sprintf("%s/%s/%s", q.Champion, q.Opponent, q.Role), " ", "_"))
+
+	return Stats{
+		WinRate: 0.512,
+		Games:   4821,
+		Link:    "stats.example.com/matchups/" + slug,
+	}, nil
+}
@@ -0,0 +1,113 @@
+// Package source fetches matchup data from outside reddit, currently head to
+// head win rates from a stats API
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"server/config"
+	"server/metrics"
+	"server/models"
+)
+
+// below this many games a win rate is mostly noise
+const minGames = 100
+
+// ErrNoData is returned when there aren't enough games for the matchup
+var ErrNoData = errors.New("not enough games for this matchup")
+
+// Stats is a champion's record against an opponent in one role
+type Stats struct {
+	// fraction of games the champion won, between 0 and 1
+	WinRate float64 `json:"win_rate"`
+	Games   int     `json:"games"`
+	// page the numbers can be checked on, cited as the point's source
+	Link string `json:"url"`
+}
+
+// Fetcher looks up the stats for a matchup from the champion's side
+type Fetcher interface {
+	Name() string
+	Fetch(ctx context.Context, q models.Query) (Stats, error)
+}
+
+// New returns the configured fetcher, or nil when stats are turned off
+func New(c config.Stats) Fetcher {
+	if !c.Enabled {
+		return nil
+	}
+	if c.MockMode {
+		return mockFetcher{}
+	}
+	return &apiFetcher{url: c.APIURL, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// apiFetcher queries a JSON stats API with champion, opponent and role
+// parameters, expecting a Stats object back
+type apiFetcher struct {
+	url    string
+	client *http.Client
+}
+
+func (*apiFetcher) Name() string { return "stats" }
+
+func (f *apiFetcher) Fetch(ctx context.Context, q models.Query) (Stats, error) {
+	defer metrics.ObserveStage("stats", time.Now())
+
+	params := url.Values{}
+	params.Set("champion", q.Champion)
+	params.Set("opponent", q.Opponent)
+	params.Set("role", q.Role)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url+"?"+params.Encode(), http.NoBody)
+	if err != nil {
+		return Stats{}, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return Stats{}, fmt.Errorf("stats request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return Stats{}, ErrNoData
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Stats{}, fmt.Errorf("unexpected status code from stats api: %d", resp.StatusCode)
+	}
+
+	var stats Stats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return Stats{}, fmt.Errorf("failed to decode stats: %v", err)
+	}
+
+	if stats.Games < minGames {
+		return Stats{}, ErrNoData
+	}
+	if stats.WinRate < 0 || stats.WinRate > 1 {
+		return Stats{}, fmt.Errorf("win rate %v is out of range", stats.WinRate)
+	}
+
+	return stats, nil
+}
+
+// Point phrases stats as an advice point like the ones from the reddit
+// summaries
+func Point(q models.Query, stats Stats) models.AdvicePoint {
+	point := models.AdvicePoint{
+		Text: fmt.Sprintf("%s wins %.1f%% of %s games against %s, across %d games.",
+			q.Champion, stats.WinRate*100, q.Role, q.Opponent, stats.Games),
+		Sources: []string{},
+	}
+	if stats.Link != "" {
+		point.Sources = append(point.Sources, stats.Link)
+	}
+	return point
+}
@@ -0,0 +1,18 @@
+package summarize
+
+import "server/models"
+
+// trustedTag marks a post from a subreddit weighted above the rest, see
+// models.SubredditWeight, and trustedRule tells the model what it means
+const (
+	trustedTag  = "[trusted subreddit]"
+	trustedRule = `- Posts marked ` + trustedTag + ` are from communities known for sound matchup advice, give their advice more weight
+		`
+)
+
+// isTrusted reports whether a post's subreddit is weighted above the default
+// for advice on champion, which can be empty when formatting a thread outside
+// of a matchup
+func isTrusted(post Post, champion string) bool {
+	return models.LinkWeight(post.Permalink, champion) > 1
+}
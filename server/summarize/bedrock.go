@@ -0,0 +1,192 @@
+package summarize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"server/metrics"
+	"server/models"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+// messageRequest is one call to the anthropic messages API a prompt is sent
+// with: the system prompt, and text as the only user message
+type messageRequest struct {
+	System      string
+	Text        string
+	MaxTokens   int
+	Temperature float64
+}
+
+type messageBody struct {
+	AnthropicVersion string        `json:"anthropic_version"`
+	MaxTokens        int           `json:"max_tokens"`
+	System           string        `json:"system"`
+	Messages         []messageTurn `json:"messages"`
+	Temperature      float64       `json:"temperature"`
+	TopP             float64       `json:"top_p"`
+	Tools            []messageTool `json:"tools,omitempty"`
+	ToolChoice       *toolChoice   `json:"tool_choice,omitempty"`
+}
+
+// messageTool is a tool the model can answer with, its input matching
+// InputSchema
+type messageTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type toolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+type messageTurn struct {
+	Role    string           `json:"role"`
+	Content []messageContent `json:"content"`
+}
+
+type messageContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// responseContent is one block of a response, text or a tool call
+type responseContent struct {
+	Type  string          `json:"type"`
+	Text  string          `json:"text"`
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input"`
+}
+
+// messageResponse is the part of an anthropic messages response we read
+type messageResponse struct {
+	Content []responseContent `json:"content"`
+	Usage   struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+var errNoCompletion = errors.New("completion not found in the response")
+
+// completion is the text of the first text block in the response
+func (r messageResponse) completion() (string, error) {
+	for _, content := range r.Content {
+		if content.Type == "text" {
+			return content.Text, nil
+		}
+	}
+	return "", errNoCompletion
+}
+
+// toolInput is the input of the first call to the named tool in the response
+func (r messageResponse) toolInput(name string) (json.RawMessage, error) {
+	for _, content := range r.Content {
+		if content.Type == "tool_use" && content.Name == name {
+			return content.Input, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no %s call", errNoCompletion, name)
+}
+
+func (r messageResponse) usage() Usage {
+	return Usage{InputTokens: r.Usage.InputTokens, OutputTokens: r.Usage.OutputTokens}
+}
+
+// newInvoker builds the bedrock client model calls are made with, tests swap
+// it for a fake
+var newInvoker = func(ctx context.Context) (modelInvoker, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(settings.Region))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config, %v", err)
+	}
+	return bedrockruntime.NewFromConfig(cfg), nil
+}
+
+// invokeMessage sends req to the first of modelIDs that's available and
+// returns the completion, the usage (even when there's no completion) and
+// the model that answered. Failing to reach bedrock is an UpstreamError.
+func invokeMessage(ctx context.Context, modelIDs []string, req messageRequest) (string, Usage, string, error) {
+	result, modelID, err := invoke(ctx, modelIDs, newMessageBody(req))
+	if err != nil {
+		return "", Usage{}, "", err
+	}
+
+	completion, err := result.completion()
+	return completion, result.usage(), modelID, err
+}
+
+// invokePoints is invokeMessage for calls that answer with advice points,
+// which the model is made to record with pointsTool so they come back as
+// JSON matching its schema rather than bullet text
+func invokePoints(ctx context.Context, modelIDs []string, req messageRequest) ([]models.AdvicePoint, Usage, string, error) {
+	body := newMessageBody(req)
+	body.Tools = []messageTool{pointsTool}
+	body.ToolChoice = &toolChoice{Type: "tool", Name: pointsTool.Name}
+
+	result, modelID, err := invoke(ctx, modelIDs, body)
+	if err != nil {
+		return nil, Usage{}, "", err
+	}
+
+	input, err := result.toolInput(pointsTool.Name)
+	if err != nil {
+		return nil, result.usage(), modelID, err
+	}
+
+	var recorded recordedPoints
+	if err := json.Unmarshal(input, &recorded); err != nil {
+		return nil, result.usage(), modelID, fmt.Errorf("couldn't unmarshal the recorded points: %s", err)
+	}
+
+	return recorded.Points, result.usage(), modelID, nil
+}
+
+func newMessageBody(req messageRequest) messageBody {
+	return messageBody{
+		AnthropicVersion: "bedrock-2023-05-31",
+		MaxTokens:        req.MaxTokens,
+		System:           req.System,
+		Messages: []messageTurn{
+			{Role: "user", Content: []messageContent{{Type: "text", Text: req.Text}}},
+		},
+		Temperature: req.Temperature,
+		TopP:        settings.TopP,
+	}
+}
+
+func invoke(ctx context.Context, modelIDs []string, body messageBody) (messageResponse, string, error) {
+	client, err := newInvoker(ctx)
+	if err != nil {
+		return messageResponse{}, "", err
+	}
+
+	reqbody, err := json.Marshal(body)
+	if err != nil {
+		return messageResponse{}, "", fmt.Errorf("error creating request body: %v", err)
+	}
+
+	resp, modelID, err := invokeWithFallback(ctx, client, &bedrockruntime.InvokeModelInput{
+		ContentType: aws.String("application/json"),
+		Accept:      aws.String("application/json"),
+		Body:        reqbody,
+	}, modelIDs)
+	if err != nil {
+		metrics.SourceErrors.WithLabelValues("bedrock").Inc()
+		return messageResponse{}, "", &models.UpstreamError{Service: "bedrock", Err: fmt.Errorf("couldn't hit bedrock properly: %w", err)}
+	}
+
+	var result messageResponse
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
+		return messageResponse{}, "", fmt.Errorf("couldn't unmarshal the result: %s", err)
+	}
+
+	return result, modelID, nil
+}
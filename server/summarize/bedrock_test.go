@@ -0,0 +1,183 @@
+package summarize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"server/config"
+	"server/models"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+// fakeInvoker answers every model call with body
+type fakeInvoker struct {
+	body  string
+	calls int
+	// the last request body sent
+	request []byte
+}
+
+func (f *fakeInvoker) InvokeModel(ctx context.Context, params *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error) {
+	f.calls++
+	f.request = params.Body
+	return &bedrockruntime.InvokeModelOutput{Body: []byte(f.body)}, nil
+}
+
+// withInvoker sends the test's model calls to fake
+func withInvoker(t *testing.T, fake modelInvoker) {
+	t.Helper()
+	oldInvoker, oldSettings := newInvoker, settings
+	newInvoker = func(context.Context) (modelInvoker, error) { return fake, nil }
+	settings = config.Bedrock{ModelID: "model", QCModelID: "qc-model", MaxAttempts: 1}
+	t.Cleanup(func() { newInvoker, settings = oldInvoker, oldSettings })
+}
+
+func TestInvokeMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		wantErr error
+		usage   Usage
+	}{
+		{
+			name:  "text",
+			body:  `{"content":[{"type":"text","text":"• Dodge his W [Sources: [a]]"}],"usage":{"input_tokens":120,"output_tokens":30}}`,
+			want:  "• Dodge his W [Sources: [a]]",
+			usage: Usage{InputTokens: 120, OutputTokens: 30},
+		},
+		{
+			name:    "no content",
+			body:    `{"content":[],"usage":{"input_tokens":120,"output_tokens":0}}`,
+			wantErr: errNoCompletion,
+			usage:   Usage{InputTokens: 120},
+		},
+		{
+			name:    "missing content",
+			body:    `{"type":"error"}`,
+			wantErr: errNoCompletion,
+		},
+		{
+			name:    "no text block",
+			body:    `{"content":[{"type":"tool_use"}]}`,
+			wantErr: errNoCompletion,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withInvoker(t, &fakeInvoker{body: tt.body})
+
+			got, usage, modelID, err := invokeMessage(context.Background(), modelChain(), messageRequest{System: "system", Text: "text"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("completion = %q, want %q", got, tt.want)
+			}
+			if usage != tt.usage {
+				t.Errorf("usage = %+v, want %+v", usage, tt.usage)
+			}
+			if modelID != "model" {
+				t.Errorf("model = %q, want the summary model", modelID)
+			}
+		})
+	}
+}
+
+func TestInvokeMessageRejectsMalformedBody(t *testing.T) {
+	withInvoker(t, &fakeInvoker{body: `{"content": "not a list"}`})
+
+	if _, _, _, err := invokeMessage(context.Background(), modelChain(), messageRequest{}); err == nil {
+		t.Error("a malformed response was accepted")
+	}
+}
+
+func TestSummarizeSnippetsWithoutCompletion(t *testing.T) {
+	withInvoker(t, &fakeInvoker{body: `{"content":[]}`})
+
+	items := []models.SearchItem{
+		{Title: "Lux vs Zed", Link: "https://www.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed/", Snippet: "Hold your E until he uses W."},
+	}
+	if _, err := SummarizeSnippets(context.Background(), items, "Lux", "Zed", "mid", ""); !errors.Is(err, errNoCompletion) {
+		t.Errorf("err = %v, want %v", err, errNoCompletion)
+	}
+}
+
+func TestInvokePoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []models.AdvicePoint
+		wantErr error
+	}{
+		{
+			name: "recorded",
+			body: `{"content":[{"type":"tool_use","name":"record_advice","input":{"points":[{"text":"Dodge his W","sources":["www.reddit.com/r/summonerschool/comments/abc123"]}]}}]}`,
+			want: []models.AdvicePoint{{Text: "Dodge his W", Sources: []string{"www.reddit.com/r/summonerschool/comments/abc123"}}},
+		},
+		{
+			name:    "answered in text",
+			body:    `{"content":[{"type":"text","text":"• Dodge his W [Sources: [a]]"}]}`,
+			wantErr: errNoCompletion,
+		},
+		{
+			name:    "another tool",
+			body:    `{"content":[{"type":"tool_use","name":"search","input":{}}]}`,
+			wantErr: errNoCompletion,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withInvoker(t, &fakeInvoker{body: tt.body})
+
+			got, _, _, err := invokePoints(context.Background(), modelChain(), messageRequest{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("points = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvokePointsRejectsMalformedInput(t *testing.T) {
+	withInvoker(t, &fakeInvoker{body: `{"content":[{"type":"tool_use","name":"record_advice","input":{"points":"Dodge his W"}}]}`})
+
+	if _, _, _, err := invokePoints(context.Background(), modelChain(), messageRequest{}); err == nil {
+		t.Error("points that don't match the schema were accepted")
+	}
+}
+
+func TestInvokePointsAsksForTheTool(t *testing.T) {
+	var sent messageBody
+	withInvoker(t, invokerFunc(func(input *bedrockruntime.InvokeModelInput) string {
+		if err := json.Unmarshal(input.Body, &sent); err != nil {
+			t.Fatal(err)
+		}
+		return `{"content":[{"type":"tool_use","name":"record_advice","input":{"points":[]}}]}`
+	}))
+
+	if _, _, _, err := invokePoints(context.Background(), modelChain(), messageRequest{System: "system", Text: "text"}); err != nil {
+		t.Fatalf("invokePoints: %v", err)
+	}
+	if len(sent.Tools) != 1 || sent.Tools[0].Name != pointsTool.Name || !json.Valid(sent.Tools[0].InputSchema) {
+		t.Errorf("tools = %+v, want only %s", sent.Tools, pointsTool.Name)
+	}
+	if sent.ToolChoice == nil || sent.ToolChoice.Type != "tool" || sent.ToolChoice.Name != pointsTool.Name {
+		t.Errorf("tool choice = %+v, want %s forced", sent.ToolChoice, pointsTool.Name)
+	}
+}
+
+// invokerFunc answers each model call with the body f returns for it
+type invokerFunc func(*bedrockruntime.InvokeModelInput) string
+
+func (f invokerFunc) InvokeModel(ctx context.Context, params *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error) {
+	return &bedrockruntime.InvokeModelOutput{Body: []byte(f(params))}, nil
+}
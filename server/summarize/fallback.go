@@ -0,0 +1,63 @@
+package summarize
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"server/logging"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// modelChain is the summary model followed by its fallbacks, in the order
+// they're tried
+func modelChain() []string {
+	return append([]string{settings.ModelID}, settings.FallbackModelIDs...)
+}
+
+// isModelUnavailable reports whether a bedrock error is about the model
+// itself rather than the request, so a different model could still answer:
+// it isn't enabled for the account, doesn't exist in the region, or its quota
+// ran out (which bedrock reports as throttling once retries are used up)
+func isModelUnavailable(err error) bool {
+	var denied *types.AccessDeniedException
+	var notFound *types.ResourceNotFoundException
+	var quota *types.ServiceQuotaExceededException
+	var throttling *types.ThrottlingException
+	var notReady *types.ModelNotReadyException
+	if errors.As(err, &denied) || errors.As(err, &notFound) || errors.As(err, &quota) || errors.As(err, &throttling) || errors.As(err, &notReady) {
+		return true
+	}
+
+	// an unknown model id, or one that can't be invoked on demand
+	var validation *types.ValidationException
+	return errors.As(err, &validation) && strings.Contains(strings.ToLower(validation.ErrorMessage()), "model")
+}
+
+// invokeWithFallback calls invokeWithRetry with each model in turn, moving on
+// to the next only while the model is unavailable. It returns the response
+// along with the model that produced it.
+func invokeWithFallback(ctx context.Context, client modelInvoker, input *bedrockruntime.InvokeModelInput, modelIDs []string) (*bedrockruntime.InvokeModelOutput, string, error) {
+	var err error
+	for i, modelID := range modelIDs {
+		attempt := *input
+		attempt.ModelId = aws.String(modelID)
+
+		var resp *bedrockruntime.InvokeModelOutput
+		resp, err = invokeWithRetry(ctx, client, &attempt)
+		if err == nil {
+			return resp, modelID, nil
+		}
+		if !isModelUnavailable(err) || i == len(modelIDs)-1 || ctx.Err() != nil {
+			break
+		}
+
+		logging.FromContext(ctx).Warn("bedrock model unavailable, falling back",
+			"model", modelID, "fallback", modelIDs[i+1], "error", err)
+	}
+
+	return nil, "", err
+}
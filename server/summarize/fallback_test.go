@@ -0,0 +1,135 @@
+package summarize
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"server/models"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// modelsInvoker fails calls to the models in errs and answers the rest with
+// body, recording which models were asked
+type modelsInvoker struct {
+	errs   map[string]error
+	body   string
+	models []string
+}
+
+func (m *modelsInvoker) InvokeModel(ctx context.Context, params *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error) {
+	modelID := aws.ToString(params.ModelId)
+	m.models = append(m.models, modelID)
+	if err := m.errs[modelID]; err != nil {
+		return nil, err
+	}
+	return &bedrockruntime.InvokeModelOutput{Body: []byte(m.body)}, nil
+}
+
+func TestInvokeWithFallback(t *testing.T) {
+	chain := []string{"sonnet", "haiku", "other"}
+
+	tests := []struct {
+		name       string
+		errs       map[string]error
+		wantModel  string
+		wantErr    bool
+		wantModels []string
+	}{
+		{name: "primary answers", wantModel: "sonnet", wantModels: []string{"sonnet"}},
+		{
+			name:       "not enabled for the account",
+			errs:       map[string]error{"sonnet": &types.AccessDeniedException{}},
+			wantModel:  "haiku",
+			wantModels: []string{"sonnet", "haiku"},
+		},
+		{
+			name:       "not in the region",
+			errs:       map[string]error{"sonnet": &types.ResourceNotFoundException{}},
+			wantModel:  "haiku",
+			wantModels: []string{"sonnet", "haiku"},
+		},
+		{
+			name:       "quota used up",
+			errs:       map[string]error{"sonnet": &types.ServiceQuotaExceededException{}, "haiku": &types.ThrottlingException{}},
+			wantModel:  "other",
+			wantModels: []string{"sonnet", "haiku", "other"},
+		},
+		{
+			name:       "unknown model id",
+			errs:       map[string]error{"sonnet": &types.ValidationException{Message: aws.String("The provided model identifier is invalid.")}},
+			wantModel:  "haiku",
+			wantModels: []string{"sonnet", "haiku"},
+		},
+		{
+			name:       "a bad request isn't the model's fault",
+			errs:       map[string]error{"sonnet": &types.ValidationException{Message: aws.String("max_tokens: must be positive")}},
+			wantErr:    true,
+			wantModels: []string{"sonnet"},
+		},
+		{
+			name:       "every model unavailable",
+			errs:       map[string]error{"sonnet": &types.AccessDeniedException{}, "haiku": &types.AccessDeniedException{}, "other": &types.AccessDeniedException{}},
+			wantErr:    true,
+			wantModels: chain,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withRetrySettings(t, 1, 0)
+			client := &modelsInvoker{errs: tt.errs, body: "{}"}
+
+			resp, modelID, err := invokeWithFallback(context.Background(), client, &bedrockruntime.InvokeModelInput{}, chain)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (modelID != tt.wantModel || string(resp.Body) != "{}") {
+				t.Errorf("answered by %q with %q, want %q", modelID, resp.Body, tt.wantModel)
+			}
+			if !slices.Equal(client.models, tt.wantModels) {
+				t.Errorf("asked %q, want %q", client.models, tt.wantModels)
+			}
+		})
+	}
+}
+
+func TestInvokeWithFallbackStopsWhenCancelled(t *testing.T) {
+	withRetrySettings(t, 1, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client := &modelsInvoker{errs: map[string]error{"sonnet": &types.AccessDeniedException{}}}
+
+	if _, _, err := invokeWithFallback(ctx, client, &bedrockruntime.InvokeModelInput{}, []string{"sonnet", "haiku"}); err == nil {
+		t.Fatal("want an error")
+	}
+	if len(client.models) > 1 {
+		t.Errorf("asked %q after the context was cancelled", client.models)
+	}
+}
+
+func TestSummarizeSnippetsReportsFallbackModel(t *testing.T) {
+	client := &modelsInvoker{
+		errs: map[string]error{"model": &types.AccessDeniedException{}},
+		body: `{"content":[{"type":"tool_use","name":"record_advice","input":{"points":[{"text":"Dodge his W","sources":["www.reddit.com/r/summonerschool/comments/abc123"]}]}}]}`,
+	}
+	withInvoker(t, client)
+	settings.FallbackModelIDs = []string{"fallback-model"}
+
+	items := []models.SearchItem{
+		{Title: "Lux vs Zed", Link: "https://www.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed/", Snippet: "Hold your E until he uses W."},
+	}
+	result, err := SummarizeSnippets(context.Background(), items, "Lux", "Zed", "mid", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Model != "fallback-model" || len(result.Points) != 1 {
+		t.Errorf("got %+v, want the fallback model's advice", result)
+	}
+	if !slices.Equal(client.models, []string{"model", "fallback-model"}) {
+		t.Errorf("asked %q, want the primary then the fallback", client.models)
+	}
+}
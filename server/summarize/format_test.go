@@ -0,0 +1,117 @@
+package summarize
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// testOpts are the default options, the way most threads get formatted
+var testOpts = SummarizeOptions{
+	TopComments:     10,
+	TopReplies:      3,
+	MaxReplyDepth:   2,
+	MaxPoints:       5,
+	MinScore:        1,
+	IncludeFlair:    true,
+	MaxPostChars:    2000,
+	KeepWriteups:    true,
+	WriteupMinChars: 1500,
+	WriteupMinScore: 50,
+}
+
+// testThread builds a thread with n top-level comments, each with a few
+// levels of replies. Scores and ages are spread so the ranking has
+// something to do, and everything is fixed so the output is too.
+func testThread(n int) Post {
+	const posted = 1717000000
+
+	post := Post{
+		Timestamp:   posted,
+		Title:       "Lux vs Zed, how do I survive past level 6?",
+		Permalink:   "https://www.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed/",
+		Content:     strings.Repeat("Every game he gets ult, shadows behind me and I'm dead before I can react. ", 30),
+		Score:       412,
+		UpvoteRatio: 0.94,
+		NumComments: n * 7,
+		Flair:       "Mid",
+		Awards:      2,
+	}
+
+	var replies func(prefix string, depth, seed int) []Comment
+	replies = func(prefix string, depth, seed int) []Comment {
+		if depth > 3 {
+			return nil
+		}
+		comments := make([]Comment, 4)
+		for i := range comments {
+			id := fmt.Sprintf("%s_%d", prefix, i)
+			comments[i] = Comment{
+				Timestamp: posted + int64((seed*37+i*11)%600)*60,
+				Content:   fmt.Sprintf("Reply %s: hold E for his W shadow, then Q where he lands.", id),
+				Permalink: post.Permalink + id + "/",
+				Score:     (seed*13+i*29)%120 - 10,
+				Replies:   replies(id, depth+1, seed+i+1),
+			}
+		}
+		return comments
+	}
+
+	post.Comments = make([]Comment, n)
+	for i := range post.Comments {
+		id := fmt.Sprintf("c%d", i)
+		post.Comments[i] = Comment{
+			Timestamp: posted + int64((i*53)%1440)*60,
+			Content:   fmt.Sprintf("Comment %d: buy Seeker's Armguard early and stand behind your wave, he can't all in you through minions.", i),
+			Permalink: post.Permalink + id + "/",
+			Score:     (i*97)%300 - 20,
+			Replies:   replies(id, 1, i),
+		}
+	}
+	return post
+}
+
+// TestFormatPostContentGolden pins exactly what the model is sent for a
+// thread, so reworking the formatting for speed can't quietly change it. Run
+// with -update after an intended change.
+func TestFormatPostContentGolden(t *testing.T) {
+	// dates are written in local time
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+
+	got, err := formatPostContent(testThread(30), testOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "format_post.golden")
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("formatted post differs from %s, rerun with -update if that's intended\ngot:\n%s", golden, got)
+	}
+}
+
+func BenchmarkFormatPostContent(b *testing.B) {
+	post := testThread(200)
+	b.ReportAllocs()
+	for range b.N {
+		if _, err := formatPostContent(post, testOpts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package summarize
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/abadojack/whatlanggo"
+)
+
+// enough text to detect the language reliably without scanning whole threads
+const languageSampleLen = 2000
+
+// detectPostLanguage guesses the language of a thread from its title, body and
+// first few comments. ok is false when the guess isn't reliable enough to act on.
+func detectPostLanguage(post Post) (lang whatlanggo.Lang, ok bool) {
+	var sb strings.Builder
+	sb.WriteString(post.Title)
+	sb.WriteString("\n")
+	sb.WriteString(post.Content)
+	for _, comment := range post.Comments {
+		if sb.Len() >= languageSampleLen {
+			break
+		}
+		sb.WriteString("\n")
+		sb.WriteString(comment.Content)
+	}
+
+	info := whatlanggo.Detect(sb.String())
+	return info.Lang, info.IsReliable()
+}
+
+// wrongLanguage reports whether the language filter is on and the thread is
+// confidently in some language other than TARGET_LANG, returning the detected
+// language's name for logging
+func wrongLanguage(post Post) (string, bool) {
+	if !settings.LanguageFilter {
+		return "", false
+	}
+
+	lang, ok := detectPostLanguage(post)
+	if !ok || lang == settings.TargetLang {
+		return "", false
+	}
+	return lang.String(), true
+}
+
+// OutputLanguages are the languages advice can be written in, by ISO 639-1
+// code. Threads are still filtered on TARGET_LANG, the model translates.
+var OutputLanguages = map[string]string{
+	"en": "English",
+	"es": "Spanish",
+	"pt": "Portuguese",
+	"fr": "French",
+	"de": "German",
+	"it": "Italian",
+	"pl": "Polish",
+	"tr": "Turkish",
+	"ru": "Russian",
+	"vi": "Vietnamese",
+	"ko": "Korean",
+	"ja": "Japanese",
+	"zh": "Chinese",
+}
+
+type languageKey struct{}
+
+// WithLanguage makes summaries under ctx be written in lang, one of the
+// OutputLanguages codes
+func WithLanguage(ctx context.Context, lang string) context.Context {
+	return context.WithValue(ctx, languageKey{}, lang)
+}
+
+// languageRules are the extra prompt rules for writing the summary in the
+// language requested under ctx, empty for english
+func languageRules(ctx context.Context) string {
+	lang, _ := ctx.Value(languageKey{}).(string)
+	name, ok := OutputLanguages[lang]
+	if !ok || lang == "en" {
+		return ""
+	}
+
+	return fmt.Sprintf(`- Respond in %s, but keep champion names, links, "%s" and "`+InvalidInputMarker+`" exactly as written here
+		`, name, sourcesMarker)
+}
@@ -0,0 +1,82 @@
+package summarize
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"server/models"
+)
+
+// mockMode reports whether MOCK_MODE=true, in which case summaries are
+// generated locally instead of calling bedrock
+func mockMode() bool {
+	return settings.MockMode
+}
+
+// reported as the model behind mock summaries
+const mockModelID = "mock"
+
+// mockSummarize returns one deterministic point citing the thread's post and
+// top comment, in the same format the real prompt produces
+func mockSummarize(data []byte, championA string, championB string, role string) (Result, error) {
+	var post Post
+	if err := json.Unmarshal(data, &post); err != nil {
+		return Result{}, fmt.Errorf("couldn't convert json to post: %s", err)
+	}
+
+	sources := []string{"www.reddit.com" + post.Permalink}
+	if len(post.Comments) > 0 {
+		sources = append(sources, "www.reddit.com"+post.Comments[0].Permalink)
+	}
+
+	points := []models.AdvicePoint{{
+		Text:    fmt.Sprintf("%s should trade around %s's cooldowns %s and respect their level 6 power spike.", championA, championB, mockLane(role)),
+		Sources: sources,
+	}}
+
+	return Result{Summary: FormatPoints(points), Points: points, Score: post.Score, Model: mockModelID}, nil
+}
+
+func mockSummarizeBatch(posts [][]byte, championA string, championB string, role string) (Result, error) {
+	var points []models.AdvicePoint
+	for _, data := range posts {
+		result, err := mockSummarize(data, championA, championB, role)
+		if err != nil {
+			continue
+		}
+		points = append(points, result.Points...)
+	}
+
+	if len(points) == 0 {
+		return Result{}, fmt.Errorf("none of the %d posts could be formatted", len(posts))
+	}
+
+	return Result{Summary: FormatPoints(points), Points: points, Model: mockModelID}, nil
+}
+
+// mockSummarizeSnippets returns one point citing every result with a snippet
+func mockSummarizeSnippets(items []models.SearchItem, championA string, championB string, role string) (Result, error) {
+	var sources []string
+	for _, item := range items {
+		if item.Snippet != "" {
+			sources = append(sources, snippetLink(item.Link))
+		}
+	}
+	if len(sources) == 0 {
+		return Result{}, ErrIrrelevantSource
+	}
+
+	points := []models.AdvicePoint{{
+		Text:    fmt.Sprintf("%s should play safely against %s %s until their first item.", championA, championB, mockLane(role)),
+		Sources: sources,
+	}}
+
+	return Result{Summary: FormatPoints(points), Points: points, Model: mockModelID}, nil
+}
+
+func mockLane(role string) string {
+	if role == models.RoleAny {
+		return "in lane"
+	}
+	return fmt.Sprintf("in the %s lane", role)
+}
@@ -0,0 +1,64 @@
+package summarize
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"server/metrics"
+)
+
+// RewritePerspective rewrites advice written for opponent playing against
+// champion so it's from champion's side instead, in one call to the quality
+// control model. It's far cheaper than generating the reverse matchup from
+// scratch, since both sides of a lane come up in the same threads anyway.
+// lang is the OutputLanguages code the advice is served in, empty for english.
+func RewritePerspective(ctx context.Context, advice string, champion string, opponent string, lang string) (Result, error) {
+	if mockMode() {
+		rewritten := strings.NewReplacer(champion, opponent, opponent, champion).Replace(advice)
+		return Result{Summary: rewritten, Points: ParsePoints(rewritten)}, nil
+	}
+
+	defer metrics.ObserveStage("rewrite", time.Now())
+
+	// the advice should already be in lang, but the model is told which so a
+	// rewrite never drifts back into english
+	languageRule := "Keep the summary in the language it is written in, do not translate it"
+	if name, ok := OutputLanguages[lang]; ok && lang != "en" {
+		languageRule = fmt.Sprintf(`Write the rewritten summary in %s, but keep champion names, links, "%s" and "%s" exactly as written here`, name, sourcesMarker, InvalidInputMarker)
+	}
+
+	systemPrompt := fmt.Sprintf(`
+        You are an expert League of Legends analyst. The following summary is advice for %s playing against %s. Rewrite it as advice for %s (champion) playing against %s (opponent):
+
+        1. Turn each point around so it tells %s what to do, e.g. a window where %s should trade becomes one %s should avoid or punish
+        2. Flip any win rates to %s's side
+        3. Use only the provided summary as the knowledge source; do not introduce any other information
+        4. Keep every point's sources exactly as they are
+        5. `+languageRule+`
+        6. If a point can't be turned around, omit it
+        7. If no point can be turned around, record a single point saying "`+InvalidInputMarker+`"
+        8. Omit all meta commentary, ie only give the rewritten summary without offering any comments about it
+        9. Make sure each point is its own entry
+
+        `+pointsRule+`
+    `, opponent, champion, champion, opponent, champion, opponent, champion, champion)
+
+	recorded, usage, _, err := invokePoints(ctx, []string{settings.QCModelID}, messageRequest{
+		System:      systemPrompt,
+		Text:        advice,
+		MaxTokens:   2200,
+		Temperature: settings.Temperature,
+	})
+	if err != nil {
+		return Result{Usage: usage}, err
+	}
+
+	points, err := cleanPoints(recorded, len(recorded))
+	if err != nil {
+		return Result{Usage: usage}, err
+	}
+
+	return Result{Summary: FormatPoints(points), Points: points, Usage: usage}, nil
+}
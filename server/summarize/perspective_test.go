@@ -0,0 +1,76 @@
+package summarize
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRewritePerspective(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "rewritten",
+			body: `{"content":[{"type":"tool_use","name":"record_advice","input":{"points":[{"text":"Punish Zed when his W is down","sources":["a"]}]}}]}`,
+			want: "• Punish Zed when his W is down [Sources: [a]]",
+		},
+		{
+			name:    "no content",
+			body:    `{"content":[]}`,
+			wantErr: errNoCompletion,
+		},
+		{
+			name:    "nothing to turn around",
+			body:    `{"content":[{"type":"tool_use","name":"record_advice","input":{"points":[{"text":"` + InvalidInputMarker + `","sources":[]}]}}]}`,
+			wantErr: ErrIrrelevantSource,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{body: tt.body}
+			withInvoker(t, fake)
+
+			got, err := RewritePerspective(context.Background(), "• Trade when Lux's E is down [Sources: [a]]", "Zed", "Lux", "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got.Summary != tt.want {
+				t.Errorf("summary = %q, want %q", got.Summary, tt.want)
+			}
+			if fake.calls != 1 {
+				t.Errorf("made %d model calls, want 1", fake.calls)
+			}
+		})
+	}
+}
+
+func TestRewritePerspectiveLanguage(t *testing.T) {
+	const body = `{"content":[{"type":"tool_use","name":"record_advice","input":{"points":[{"text":"Castiga a Zed cuando su W no está","sources":["a"]}]}}]}`
+
+	tests := []struct {
+		lang string
+		want string
+	}{
+		{"", "Keep the summary in the language it is written in"},
+		{"en", "Keep the summary in the language it is written in"},
+		{"es", "Write the rewritten summary in Spanish"},
+	}
+
+	for _, tt := range tests {
+		fake := &fakeInvoker{body: body}
+		withInvoker(t, fake)
+
+		if _, err := RewritePerspective(context.Background(), "• Cambia golpes cuando la E de Lux no está [Sources: [a]]", "Zed", "Lux", tt.lang); err != nil {
+			t.Fatalf("lang %q: %v", tt.lang, err)
+		}
+		if !strings.Contains(string(fake.request), tt.want) {
+			t.Errorf("lang %q: the prompt doesn't say %q", tt.lang, tt.want)
+		}
+	}
+}
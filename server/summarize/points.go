@@ -0,0 +1,126 @@
+package summarize
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"server/models"
+)
+
+const sourcesMarker = "[Sources:"
+
+// pointsTool is how the final model call of each prompt hands back its
+// advice, the points and their sources as JSON rather than bullet text
+var pointsTool = messageTool{
+	Name:        "record_advice",
+	Description: "Record the matchup advice, one entry per point along with the links it's sourced from",
+	InputSchema: json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"points": {
+				"type": "array",
+				"items": {
+					"type": "object",
+					"properties": {
+						"text": {"type": "string", "description": "the advice, without its sources"},
+						"sources": {"type": "array", "items": {"type": "string"}, "description": "links of the threads the point comes from"}
+					},
+					"required": ["text", "sources"]
+				}
+			}
+		},
+		"required": ["points"]
+	}`),
+}
+
+// recordedPoints is pointsTool's input
+type recordedPoints struct {
+	Points []models.AdvicePoint `json:"points"`
+}
+
+// pointsRule tells the model to answer with pointsTool, in place of the
+// bullet format the text prompts ask for
+var pointsRule = fmt.Sprintf(`Record your answer with the %s tool, one entry per point with its text and the links it cites as its sources. Don't put bullets or the sources in a point's text.`, pointsTool.Name)
+
+// cleanPoints sanitizes the recorded points' text, drops empty ones and keeps
+// the first n in case the model ignores the limit in the prompt. Points that
+// say InvalidInputMarker, or none at all, mean the content was irrelevant.
+func cleanPoints(points []models.AdvicePoint, n int) ([]models.AdvicePoint, error) {
+	cleaned := []models.AdvicePoint{}
+	for _, point := range points {
+		if isInvalidInput(point.Text) {
+			return nil, ErrIrrelevantSource
+		}
+
+		// one line per point, the text advice is split on them
+		text := strings.TrimLeft(strings.Join(strings.Fields(sanitize(point.Text)), " "), "•-* ")
+		if text == "" {
+			continue
+		}
+		if len(cleaned) == n {
+			break
+		}
+
+		sources := []string{}
+		for _, link := range point.Sources {
+			if link = strings.TrimSpace(link); link != "" {
+				sources = append(sources, link)
+			}
+		}
+		cleaned = append(cleaned, models.AdvicePoint{Text: text, Sources: sources})
+	}
+
+	if len(cleaned) == 0 {
+		return nil, ErrIrrelevantSource
+	}
+	return cleaned, nil
+}
+
+// FormatPoints renders points in the "• {content} [Sources: [link1, link2]]"
+// format the text advice is served in, one point per line
+func FormatPoints(points []models.AdvicePoint) string {
+	lines := make([]string, 0, len(points))
+	for _, point := range points {
+		line := "• " + point.Text
+		if len(point.Sources) > 0 {
+			line += fmt.Sprintf(" %s [%s]]", sourcesMarker, strings.Join(point.Sources, ", "))
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ParsePoints splits advice in the "{content} [Sources: [link1, link2]]"
+// format into individual points. It's only needed for advice cached before
+// the points were stored alongside it. Lines without a sources block are kept
+// as points with no sources.
+func ParsePoints(advice string) []models.AdvicePoint {
+	points := []models.AdvicePoint{}
+
+	for _, line := range strings.Split(advice, "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.TrimSpace(strings.TrimLeft(line, "•-*"))
+		if line == "" {
+			continue
+		}
+
+		point := models.AdvicePoint{Text: line, Sources: []string{}}
+
+		if i := strings.LastIndex(line, sourcesMarker); i != -1 {
+			point.Text = strings.TrimSpace(line[:i])
+
+			links := strings.Trim(line[i+len(sourcesMarker):], " []")
+			for _, link := range strings.Split(links, ",") {
+				link = strings.Trim(link, " []")
+				if link != "" {
+					point.Sources = append(point.Sources, link)
+				}
+			}
+		}
+
+		points = append(points, point)
+	}
+
+	return points
+}
@@ -0,0 +1,81 @@
+package summarize
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"server/models"
+)
+
+func TestCleanPoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		points  []models.AdvicePoint
+		n       int
+		want    []models.AdvicePoint
+		wantErr error
+	}{
+		{
+			name: "sanitized",
+			points: []models.AdvicePoint{
+				{Text: "• Dodge his <b>W</b>\nthen trade", Sources: []string{" a ", ""}},
+				{Text: "   ", Sources: []string{"b"}},
+			},
+			n:    5,
+			want: []models.AdvicePoint{{Text: "Dodge his W then trade", Sources: []string{"a"}}},
+		},
+		{
+			name: "trimmed",
+			points: []models.AdvicePoint{
+				{Text: "one", Sources: []string{"a"}},
+				{Text: "two", Sources: []string{"b"}},
+				{Text: "three", Sources: []string{"c"}},
+			},
+			n: 2,
+			want: []models.AdvicePoint{
+				{Text: "one", Sources: []string{"a"}},
+				{Text: "two", Sources: []string{"b"}},
+			},
+		},
+		{
+			name:    "no points",
+			n:       5,
+			wantErr: ErrIrrelevantSource,
+		},
+		{
+			name:    "marked irrelevant",
+			points:  []models.AdvicePoint{{Text: "invalid-input", Sources: []string{}}},
+			n:       5,
+			wantErr: ErrIrrelevantSource,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cleanPoints(tt.points, tt.n)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cleanPoints = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatPointsRoundTrips(t *testing.T) {
+	points := []models.AdvicePoint{
+		{Text: "Hold your E until Zed uses W", Sources: []string{"www.reddit.com/r/LuxMains/comments/abc123", "www.reddit.com/r/summonerschool/comments/def456"}},
+		{Text: "Build Seeker's Armguard early", Sources: []string{}},
+	}
+
+	advice := FormatPoints(points)
+	want := "• Hold your E until Zed uses W [Sources: [www.reddit.com/r/LuxMains/comments/abc123, www.reddit.com/r/summonerschool/comments/def456]]\n• Build Seeker's Armguard early"
+	if advice != want {
+		t.Errorf("FormatPoints = %q, want %q", advice, want)
+	}
+	if got := ParsePoints(advice); !reflect.DeepEqual(got, points) {
+		t.Errorf("ParsePoints(FormatPoints(points)) = %+v, want %+v", got, points)
+	}
+}
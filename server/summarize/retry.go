@@ -0,0 +1,76 @@
+package summarize
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"server/logging"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// modelInvoker is the part of the bedrock client we use, so it can be faked
+type modelInvoker interface {
+	InvokeModel(ctx context.Context, params *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error)
+}
+
+// the most the first retry waits, doubled for each one after up to
+// retryMaxDelay. A var so tests don't have to wait on it.
+var retryBaseDelay = 500 * time.Millisecond
+
+const retryMaxDelay = 10 * time.Second
+
+// isRetryable reports whether a bedrock error is throttling or a transient
+// server side failure worth trying again
+func isRetryable(err error) bool {
+	var throttling *types.ThrottlingException
+	var unavailable *types.ServiceUnavailableException
+	var internal *types.InternalServerException
+	var notReady *types.ModelNotReadyException
+	if errors.As(err, &throttling) || errors.As(err, &unavailable) || errors.As(err, &internal) || errors.As(err, &notReady) {
+		return true
+	}
+
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		status := respErr.HTTPStatusCode()
+		return status == 429 || status >= 500
+	}
+
+	return false
+}
+
+// invokeWithRetry calls InvokeModel, retrying throttled and 5xx responses up
+// to BEDROCK_MAX_ATTEMPTS times with exponential backoff and full jitter. It
+// gives up early rather than sleep past the context deadline.
+func invokeWithRetry(ctx context.Context, client modelInvoker, input *bedrockruntime.InvokeModelInput) (*bedrockruntime.InvokeModelOutput, error) {
+	maxAttempts := max(settings.MaxAttempts, 1)
+
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		var resp *bedrockruntime.InvokeModelOutput
+		resp, err = client.InvokeModel(ctx, input)
+		if err == nil || !isRetryable(err) || attempt == maxAttempts-1 {
+			return resp, err
+		}
+
+		delay := time.Duration(rand.Int63n(int64(min(retryBaseDelay<<attempt, retryMaxDelay))))
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return nil, err
+		}
+
+		logging.FromContext(ctx).Warn("bedrock call failed, retrying",
+			"attempt", attempt+1, "max_attempts", maxAttempts, "delay", delay, "error", err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return nil, err
+}
@@ -0,0 +1,102 @@
+package summarize
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"server/config"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// scriptedInvoker fails with each of errs in turn, then answers with body
+type scriptedInvoker struct {
+	errs  []error
+	body  string
+	calls int
+}
+
+func (s *scriptedInvoker) InvokeModel(ctx context.Context, params *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error) {
+	s.calls++
+	if s.calls <= len(s.errs) {
+		return nil, s.errs[s.calls-1]
+	}
+	return &bedrockruntime.InvokeModelOutput{Body: []byte(s.body)}, nil
+}
+
+// withRetrySettings retries up to maxAttempts times, waiting at most base
+// before the first retry
+func withRetrySettings(t *testing.T, maxAttempts int, base time.Duration) {
+	t.Helper()
+	oldSettings, oldDelay := settings, retryBaseDelay
+	settings = config.Bedrock{MaxAttempts: maxAttempts}
+	retryBaseDelay = base
+	t.Cleanup(func() { settings, retryBaseDelay = oldSettings, oldDelay })
+}
+
+// responseError is a bare HTTP failure, as the sdk returns when bedrock
+// answers without a modelled exception
+func responseError(code int) error {
+	return &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: code}},
+		Err:      errors.New(http.StatusText(code)),
+	}
+}
+
+func TestInvokeWithRetry(t *testing.T) {
+	throttled := &types.ThrottlingException{}
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantErr   bool
+		wantCalls int
+	}{
+		{name: "succeeds first time", wantCalls: 1},
+		{name: "throttled twice then succeeds", errs: []error{throttled, throttled}, wantCalls: 3},
+		{name: "transient server errors", errs: []error{&types.ServiceUnavailableException{}, &types.InternalServerException{}, &types.ModelNotReadyException{}}, wantCalls: 4},
+		{name: "5xx and 429 statuses", errs: []error{responseError(http.StatusBadGateway), responseError(http.StatusTooManyRequests)}, wantCalls: 3},
+		{name: "gives up after max attempts", errs: []error{throttled, throttled, throttled, throttled, throttled}, wantErr: true, wantCalls: 4},
+		{name: "validation isn't retried", errs: []error{&types.ValidationException{}}, wantErr: true, wantCalls: 1},
+		{name: "4xx isn't retried", errs: []error{responseError(http.StatusForbidden)}, wantErr: true, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withRetrySettings(t, 4, time.Millisecond)
+			client := &scriptedInvoker{errs: tt.errs, body: "{}"}
+
+			resp, err := invokeWithRetry(context.Background(), client, &bedrockruntime.InvokeModelInput{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && string(resp.Body) != "{}" {
+				t.Errorf("body = %q", resp.Body)
+			}
+			if client.calls != tt.wantCalls {
+				t.Errorf("called %d times, want %d", client.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestInvokeWithRetryRespectsDeadline(t *testing.T) {
+	// any wait at all is past the deadline
+	withRetrySettings(t, 4, time.Hour)
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	client := &scriptedInvoker{errs: []error{&types.ThrottlingException{}}}
+	start := time.Now()
+	if _, err := invokeWithRetry(ctx, client, &bedrockruntime.InvokeModelInput{}); err == nil {
+		t.Fatal("succeeded past the deadline")
+	}
+	if client.calls != 1 || time.Since(start) > time.Second {
+		t.Errorf("called %d times over %s, want it to give up instead of waiting", client.calls, time.Since(start))
+	}
+}
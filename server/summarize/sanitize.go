@@ -0,0 +1,37 @@
+package summarize
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// opening, closing or self closing tags like <BOLD>, </source> or <br/>
+	tagPattern = regexp.MustCompile(`</?[A-Za-z][A-Za-z0-9_-]*(\s[^<>]*)?/?>`)
+	// unicode escapes written out as text, like \u2022. Only the backslash
+	// form, a slash followed by u and hex is a plausible reddit post id.
+	escapePattern = regexp.MustCompile(`\\u[0-9A-Fa-f]{4}`)
+)
+
+// sanitize strips the XML tags, written out unicode escapes and control
+// characters the prompts ask the model not to produce, in case it does anyway.
+// Links and the "[Sources: [...]]" blocks are left alone.
+func sanitize(summary string) string {
+	summary = tagPattern.ReplaceAllString(summary, "")
+	summary = escapePattern.ReplaceAllString(summary, "")
+
+	summary = strings.Map(func(r rune) rune {
+		if r != '\n' && r != '\t' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, summary)
+
+	// removing a tag can leave a doubled or trailing space behind
+	lines := strings.Split(summary, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,30 @@
+package summarize
+
+import "testing"
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"tag", "<BOLD>Respect</BOLD> Zed's level 6", "Respect Zed's level 6"},
+		{"written out escape", `\u2022 Hold E for his W shadow`, "Hold E for his W shadow"},
+		{"tags with attributes", `<point id="1">Buy armguard</point> <br/>early`, "Buy armguard early"},
+		{"prompt tags echoed back", "<champion>Lux</champion> should poke <opponent>Zed</opponent>", "Lux should poke Zed"},
+		{"control characters", "Shove\x00 the\x1b wave\x07", "Shove the wave"},
+		{"keeps newlines", "first point\nsecond point", "first point\nsecond point"},
+		{"keeps sources", "Zhonya's beats Death Mark [Sources: [https://www.reddit.com/r/LuxMains/comments/abc123/zed/]]", "Zhonya's beats Death Mark [Sources: [https://www.reddit.com/r/LuxMains/comments/abc123/zed/]]"},
+		{"keeps comparisons", "Lux < Zed at level 6 but > him at level 11", "Lux < Zed at level 6 but > him at level 11"},
+		{"keeps real bullets", "• already clean", "• already clean"},
+		{"keeps a slash u path", "see /u1234/ and reddit.com/u/someone", "see /u1234/ and reddit.com/u/someone"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitize(tt.in); got != tt.want {
+				t.Errorf("sanitize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package summarize
+
+import (
+	"context"
+
+	"server/config"
+)
+
+var settings config.Bedrock
+
+// Configure sets the bedrock region and models, it must be called before
+// anything is summarized
+func Configure(c config.Bedrock) {
+	settings = c
+}
+
+type explorationKey struct{}
+
+// WithExploration makes summaries under ctx use the exploratory temperature,
+// for matchups with too little content for the deterministic defaults to say
+// much. Quality control keeps the defaults either way.
+func WithExploration(ctx context.Context) context.Context {
+	return context.WithValue(ctx, explorationKey{}, true)
+}
+
+// summaryTemperature is the temperature for the summary call under ctx
+func summaryTemperature(ctx context.Context) float64 {
+	if exploring, _ := ctx.Value(explorationKey{}).(bool); exploring {
+		return settings.ExploratoryTemperature
+	}
+	return settings.Temperature
+}
@@ -0,0 +1,92 @@
+package summarize
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"server/metrics"
+	"server/models"
+)
+
+// SummarizeSnippets writes quick advice from just the search results' titles
+// and snippets, in one bedrock call with no scraping or quality control. It's
+// much faster than summarizing the threads but only has a sentence or two per
+// thread to go on. Returns ErrIrrelevantSource if the snippets say nothing
+// about the matchup.
+func SummarizeSnippets(ctx context.Context, items []models.SearchItem, championA string, championB string, role string, patch string) (Result, error) {
+	if mockMode() {
+		return mockSummarizeSnippets(items, championA, championB, role)
+	}
+
+	defer metrics.ObserveStage("summarize", time.Now())
+
+	var sb strings.Builder
+	for _, item := range items {
+		if item.Snippet == "" {
+			continue
+		}
+		fmt.Fprintf(&sb, "[%s] [%s] [%s]\n", snippetLink(item.Link), item.Title, item.Snippet)
+	}
+	if sb.Len() == 0 {
+		return Result{}, ErrIrrelevantSource
+	}
+
+	extraRules := languageRules(ctx)
+	if patch != "" {
+		extraRules = fmt.Sprintf(`- The game is currently on patch %s, prefer recent advice over anything that may predate balance changes
+		`, patch) + extraRules
+	}
+
+	maxPoints := loadOptions().MaxPoints
+
+	systemPrompt := fmt.Sprintf(`
+        You are an expert League of Legends analyst. Given the following search results about a %s vs %s matchup %s, please:
+        1. Use only what the titles and snippets say, they are short excerpts of reddit threads
+        2. Filter out results that are irrelevant to the matchup
+        3. Generate a summary with 1-%d points
+        4. Cite the link of every result each point comes from
+        5. keep a formal mood and third person
+
+
+		The data will be given as follows:
+        <input-data-format>
+        [link] [thread title] [snippet]
+        <input-data-format/>
+
+
+        Important:
+        - Provide no more than %d summary points, fewer if the snippets don't support them
+        - If the matchup is reversed in the content, adjust your advice accordingly
+		- If no result says anything about the matchup between %s and %s record a single point saying "`+InvalidInputMarker+`"
+		- Omit meta commentary about the search results themselves
+		- <very-important> The only league of legends characters that should be mentioned are <champion>%s</champion> and <opponent>%s</opponent> </very-important>
+		- <very-important> There should be no XML tags or special unicode characters (that have to be specified with /u) in the output </very-important>
+		%s
+        `+pointsRule+`
+    `, championA, championB, inRole(role), maxPoints, maxPoints, championA, championB, championA, championB, extraRules)
+
+	recorded, usage, modelID, err := invokePoints(ctx, modelChain(), messageRequest{
+		System:      systemPrompt,
+		Text:        sb.String(),
+		MaxTokens:   1000,
+		Temperature: summaryTemperature(ctx),
+	})
+	if err != nil {
+		return Result{Usage: usage}, err
+	}
+
+	points, err := cleanPoints(recorded, maxPoints)
+	if err != nil {
+		return Result{Usage: usage}, err
+	}
+
+	return Result{Summary: FormatPoints(points), Points: points, Usage: usage, Model: modelID}, nil
+}
+
+// snippetLink drops the scheme so snippet advice cites links the same way as
+// the full summaries do
+func snippetLink(link string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(link, "https://"), "http://")
+}
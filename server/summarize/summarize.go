@@ -1,17 +1,21 @@
 package summarize
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"sort"
+	"math"
+	"server/logging"
+	"server/metrics"
+	"server/models"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 )
 
 type Comment struct {
@@ -23,90 +27,294 @@ type Comment struct {
 }
 
 type Post struct {
-	Timestamp int64
-	Content   string
-	Permalink string
-	Title     string
-	Score     int
-	Comments  []Comment
+	Timestamp   int64
+	Content     string
+	Permalink   string
+	Title       string
+	Score       int
+	UpvoteRatio float64
+	NumComments int
+	Flair       string
+	Awards      int
+	Comments    []Comment
+}
+
+// InvalidInputMarker is what the prompts tell the model to answer with when a
+// thread has nothing useful to say about the matchup
+const InvalidInputMarker = "INVALID_INPUT"
+
+// ErrIrrelevantSource is returned when the model decides a thread has
+// nothing to say about the matchup
+var ErrIrrelevantSource = errors.New("source is irrelevant to the matchup")
+
+// isInvalidInput reports whether a summary contains InvalidInputMarker. The
+// model doesn't always copy it exactly, so case and a hyphen for the underscore
+// are ignored.
+func isInvalidInput(summary string) bool {
+	normalized := strings.ReplaceAll(strings.ToUpper(summary), "-", "_")
+	return strings.Contains(normalized, InvalidInputMarker)
 }
 
-func formatPostContent(post Post) (string, error) {
+// SummarizeOptions controls how much of a thread is sent to the model
+type SummarizeOptions struct {
+	TopComments   int // top-level comments kept
+	TopReplies    int // replies kept under each comment
+	MaxReplyDepth int // levels of replies below the top-level comments
+	MaxPoints     int // points kept in each summary
+	// comments and replies scored at or below this are dropped, reddit
+	// starts them at 1 so the default needs someone besides the author to
+	// have upvoted them
+	MinScore int
+	// send post flair and awards, which help spot meme threads
+	IncludeFlair bool
+	// post bodies longer than this are cut at a word, 0 sends them whole
+	MaxPostChars int
+	// write-ups (see isWriteup) are sent whole even past MaxPostChars
+	KeepWriteups bool
+	// a post body at least this long on a post scored at least this is a
+	// write-up, flagged so the model doesn't bury it under the comments
+	WriteupMinChars int
+	WriteupMinScore int
+	// the advised champion, so threads in their mains sub are marked trusted
+	Champion string
+}
+
+// loadOptions builds the options from the configured TOP_COMMENTS,
+// TOP_REPLIES, MAX_REPLY_DEPTH, MAX_SUMMARY_POINTS, MIN_COMMENT_SCORE,
+// INCLUDE_POST_FLAIR, MAX_POST_CHARS, KEEP_LONG_WRITEUPS, WRITEUP_MIN_CHARS
+// and WRITEUP_MIN_SCORE
+func loadOptions() SummarizeOptions {
+	return SummarizeOptions{
+		TopComments:     settings.TopComments,
+		TopReplies:      settings.TopReplies,
+		MaxReplyDepth:   settings.MaxReplyDepth,
+		MaxPoints:       max(settings.MaxSummaryPoints, 1),
+		MinScore:        settings.MinCommentScore,
+		IncludeFlair:    settings.IncludeFlair,
+		MaxPostChars:    settings.MaxPostChars,
+		KeepWriteups:    settings.KeepWriteups,
+		WriteupMinChars: settings.WriteupMinChars,
+		WriteupMinScore: settings.WriteupMinScore,
+	}
+}
+
+// FormatPost renders a scraped thread the way it's sent to the model, so bad
+// advice can be traced back to what the model actually saw
+func FormatPost(data []byte) (string, error) {
+	var post Post
+	if err := json.Unmarshal(data, &post); err != nil {
+		return "", fmt.Errorf("couldn't convert json to post: %s", err)
+	}
+	return formatPostContent(post, loadOptions())
+}
+
+func formatPostContent(post Post, opts SummarizeOptions) (string, error) {
 	var sb strings.Builder
+	sb.Grow(estimateFormattedSize(post, opts))
 
-	entry, err := formatEntry(post.Timestamp, post.Title, post.Permalink, post.Score, post.Content, 0)
-	if err != nil {
+	stats := fmt.Sprintf(" [%.0f%% upvoted] [%d comments]", post.UpvoteRatio*100, post.NumComments)
+	if opts.IncludeFlair {
+		if post.Flair != "" {
+			stats += fmt.Sprintf(" [flair: %s]", post.Flair)
+		}
+		if post.Awards > 0 {
+			stats += fmt.Sprintf(" [%d awards]", post.Awards)
+		}
+	}
+	content := post.Content
+	writeup := isWriteup(post, opts)
+	if writeup {
+		stats += " " + writeupTag
+	}
+	if isTrusted(post, opts.Champion) {
+		stats += " " + trustedTag
+	}
+	if opts.MaxPostChars > 0 && len(content) > opts.MaxPostChars && !(writeup && opts.KeepWriteups) {
+		content = trimBody(content, opts.MaxPostChars)
+	}
+	if err := writeEntry(&sb, post.Timestamp, post.Title, post.Permalink, post.Score, stats, content, 0); err != nil {
 		return "", fmt.Errorf("error formatting post: %w", err)
 	}
-	sb.WriteString(entry)
 
-	topComments := getTopComments(post.Comments, 5)
+	if err := formatComments(&sb, post.Comments, 1, opts); err != nil {
+		return "", err
+	}
 
-	for _, comment := range topComments {
-		entry, err := formatEntry(comment.Timestamp, "", comment.Permalink, comment.Score, comment.Content, 1)
-		if err != nil {
-			return "", fmt.Errorf("error formatting comment: %w", err)
-		}
-		sb.WriteString(entry)
+	return sb.String(), nil
+}
+
+// bytes each entry adds besides its content: indent, date, permalink, score
+const entryOverhead = 96
+
+// estimateFormattedSize is roughly how long the formatted post will be, the
+// post plus as many entries of average comment length as opts lets through,
+// so the builder rarely has to grow
+func estimateFormattedSize(post Post, opts SummarizeOptions) int {
+	size := len(post.Title) + len(post.Content) + entryOverhead
+	if len(post.Comments) == 0 {
+		return size
+	}
 
-		topReplies := getTopComments(comment.Replies, 2)
+	total := 0
+	for _, comment := range post.Comments {
+		total += len(comment.Content)
+	}
+
+	entries, level := 0, min(opts.TopComments, len(post.Comments))
+	for depth := 1; depth <= opts.MaxReplyDepth+1 && level > 0; depth++ {
+		entries += level
+		// deep reply limits would overflow, and no thread is that big anyway
+		level = min(level*opts.TopReplies, 1<<10)
+	}
+	return size + entries*(total/len(post.Comments)+entryOverhead)
+}
 
-		for _, reply := range topReplies {
-			entry, err := formatEntry(reply.Timestamp, "", reply.Permalink, reply.Score, reply.Content, 2)
-			if err != nil {
-				return "", fmt.Errorf("error formatting reply: %w", err)
+// formatComments writes the top comments at a nesting level, then recurses
+// into their replies until opts.MaxReplyDepth is reached
+func formatComments(sb *strings.Builder, comments []Comment, depth int, opts SummarizeOptions) error {
+	n := opts.TopReplies
+	if depth == 1 {
+		n = opts.TopComments
+	}
+
+	for _, comment := range getTopComments(comments, n, opts.MinScore) {
+		if err := writeEntry(sb, comment.Timestamp, "", comment.Permalink, comment.Score, "", comment.Content, depth); err != nil {
+			return fmt.Errorf("error formatting comment: %w", err)
+		}
+
+		if depth <= opts.MaxReplyDepth {
+			if err := formatComments(sb, comment.Replies, depth+1, opts); err != nil {
+				return err
 			}
-			sb.WriteString(entry)
 		}
 	}
 
-	return sb.String(), nil
+	return nil
 }
 
-func formatEntry(timestamp int64, title, permalink string, score int, content string, indentLevel int) (string, error) {
-	indent := strings.Repeat("\t", indentLevel)
-	dateStr := time.Unix(timestamp, 0).Format("2006-01-02 15:04:05")
+// writeEntry writes one line of the thread as
+// "[date] [title] [permalink] [score]stats {content}", indented by its
+// nesting level. It's on the hot path for big threads so it appends straight
+// to sb rather than going through fmt. stats is extra bracketed metadata
+// written after the score, only posts have any.
+func writeEntry(sb *strings.Builder, timestamp int64, title, permalink string, score int, stats string, content string, indentLevel int) error {
+	if permalink == "" {
+		return fmt.Errorf("empty permalink")
+	}
+
+	for range indentLevel {
+		sb.WriteByte('\t')
+	}
+
+	var buf [32]byte
+	sb.WriteByte('[')
+	sb.Write(time.Unix(timestamp, 0).AppendFormat(buf[:0], "2006-01-02 15:04:05"))
+	sb.WriteString("] ")
 
-	var titleStr string
 	if title != "" {
-		titleStr = fmt.Sprintf("[%s] ", title)
+		sb.WriteByte('[')
+		sb.WriteString(title)
+		sb.WriteString("] ")
 	}
 
-	if permalink == "" {
-		return "", fmt.Errorf("empty permalink")
+	sb.WriteByte('[')
+	sb.WriteString(permalink)
+	sb.WriteString("] [")
+	sb.Write(strconv.AppendInt(buf[:0], int64(score), 10))
+	sb.WriteByte(']')
+	sb.WriteString(stats)
+	sb.WriteString(" {")
+	sb.WriteString(content)
+	sb.WriteString("}\n")
+
+	return nil
+}
+
+// added to every score before decay so a fresh comment with a few downvotes
+// can still beat one from several patches ago
+const recencyBonus = 5
+
+// decayedScore halves a comment's weight for every halfLife of age, the
+// matchup meta shifts every patch so old advice is worth less
+func decayedScore(comment Comment, now time.Time, halfLife time.Duration) float64 {
+	age := now.Sub(time.Unix(comment.Timestamp, 0))
+	if age < 0 {
+		age = 0
 	}
 
-	return fmt.Sprintf("%s[%s] %s[%s] [%d] {%s}\n", indent, dateStr, titleStr, permalink, score, content), nil
+	decay := math.Pow(0.5, age.Hours()/halfLife.Hours())
+	return (float64(comment.Score) + recencyBonus) * decay
 }
 
-func getTopComments(comments []Comment, n int) []Comment {
-	sort.Slice(comments, func(i, j int) bool {
-		return comments[i].Score > comments[j].Score
-	})
+// halfLife is COMMENT_HALF_LIFE_DAYS, by default roughly six patches
+func halfLife() time.Duration {
+	return settings.CommentHalfLife
+}
+
+// rankedComment is a comment's position in its slice and decayed score
+type rankedComment struct {
+	index int
+	score float64
+}
+
+// getTopComments returns the n best comments by decayed score, leaving out
+// any scored at or below minScore, so niche threads that were downvoted as a whole
+// may have fewer than n. The caller's slice (often a post's Comments or a
+// comment's Replies) keeps its original order. Each score is worked out once
+// and only positions are sorted, which is skipped when reddit's own order
+// already matches.
+func getTopComments(comments []Comment, n int, minScore int) []Comment {
+	if n == 0 || len(comments) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	hl := halfLife()
+	if hl <= 0 {
+		hl = 90 * 24 * time.Hour
+	}
+
+	ranked := make([]rankedComment, 0, len(comments))
+	for i, comment := range comments {
+		if comment.Score > minScore {
+			ranked = append(ranked, rankedComment{index: i, score: decayedScore(comment, now, hl)})
+		}
+	}
 
-	n = min(len(comments), n)
-	return comments[:n]
+	byScore := func(a, b rankedComment) int {
+		return cmp.Compare(b.score, a.score)
+	}
+	if !slices.IsSortedFunc(ranked, byScore) {
+		slices.SortStableFunc(ranked, byScore)
+	}
+
+	top := make([]Comment, min(len(ranked), n))
+	for i := range top {
+		top[i] = comments[ranked[i].index]
+	}
+	return top
 }
 
-func performQualityControl(summary string, championA string, championB string) (string, error) {
+func performQualityControl(ctx context.Context, summary string, championA string, championB string) ([]models.AdvicePoint, Usage, error) {
 	qualityControlPrompt := fmt.Sprintf(`
         You are an expert League of Legends analyst. The following summary needs to be checked for relevance and phrasing:
 
         1. Remove any points that are irrelevant to a matchup between %s (champion) and %s (opponent).
         2. If a point discusses the inverse matchup (%s vs %s), adjust the phrasing to reflect the correct perspective.
 		3. Do not omit the sources
-        4. Ignore all points that say "INVALID-INPUT".
+        4. Ignore all points that say "`+InvalidInputMarker+`".
         5. Use only the provided summary as the knowledge source; do not introduce any other information.
         6. Remove any points that do not discuss the direct relationship between %s (champion) and %s (opponent).
         7. Do not discuss anything about Riot Games' decisions.
         9. Omit any points that require discussing balance or Riot Games; focus only on the matchup.
-        9. If you cannot revise a summary, write "INVALID-INPUT".
+        9. If you cannot revise a summary, write "`+InvalidInputMarker+`".
 		10. Omit all meta commentary, ie only give the revised summary without offering any comments about it
 		11. If the summary need not any revisions, output it as is 
-        12. If the subreddit name is of the form "r/%smains", omit the point entirely
-		13. Make sure there is a new line after each point
+        12. Keep points sourced from "r/%smains", its players know the champion's matchups best
+		13. Make sure each point is its own entry
 		14. Make sure there are no bullet points
 		15. <BOLD> MAKE SURE ONLY THE MATCHUP BETWEEN  %s (champion) and %s (opponent) IS DISCUSSED </BOLD>
+		16. Keep the summary in the language it is written in, do not translate it
 		17. <BOLD> Do not omit the sources </BOLD>
 		18. <BOLD> Do not omit the sources </BOLD>
 		19. <BOLD> Do not omit the sources </BOLD>
@@ -116,89 +324,183 @@ func performQualityControl(summary string, championA string, championB string) (
         Summary:
         %s
 
-        Respond with ONLY the revised summary, formatted in bullet points as specified before.
+        `+pointsRule+`
     `, championA, championB, championB, championA, championA, championB, championA, championA, championB, championA, championB, summary)
 
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("us-east-1"))
-	if err != nil {
-		return "", fmt.Errorf("unable to load SDK config, %v", err)
-	}
-
-	reqbody, err := json.Marshal(map[string]interface{}{
-		"anthropic_version": "bedrock-2023-05-31",
-		"max_tokens":        2200,
-		"system":            qualityControlPrompt,
-		"messages": []map[string]interface{}{
-			{
-				"role": "user",
-				"content": []map[string]string{
-					{
-						"type": "text",
-						"text": summary,
-					},
-				},
-			},
-		},
-		"temperature": 0,
-		"top_p":       0.5,
+	points, usage, _, err := invokePoints(ctx, []string{settings.QCModelID}, messageRequest{
+		System:      qualityControlPrompt,
+		Text:        summary,
+		MaxTokens:   2200,
+		Temperature: settings.Temperature,
 	})
 	if err != nil {
-		return "", fmt.Errorf("error creating quality control request body: %v", err)
+		return nil, usage, err
 	}
 
-	bedrockClient := bedrockruntime.NewFromConfig(cfg)
-	resp, err := bedrockClient.InvokeModel(context.TODO(), &bedrockruntime.InvokeModelInput{
-		ModelId:     aws.String("anthropic.claude-3-5-sonnet-20240620-v1:0"),
-		ContentType: aws.String("application/json"),
-		Accept:      aws.String("application/json"),
-		Body:        reqbody,
-	})
+	return points, usage, nil
+}
 
-	if err != nil {
-		return "", fmt.Errorf("couldn't perform quality control properly: %s", err)
+// CheckConfig verifies the AWS config and credentials bedrock needs can be
+// loaded, without making a model call
+func CheckConfig(ctx context.Context) error {
+	if mockMode() {
+		return nil
 	}
 
-	var result map[string]interface{}
-	err = json.Unmarshal(resp.Body, &result)
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(settings.Region))
 	if err != nil {
-		return "", fmt.Errorf("couldn't unmarshal the quality control result: %s", err)
+		return fmt.Errorf("unable to load SDK config, %v", err)
 	}
 
-	qualityControlledCompletion, ok := result["content"].([]interface{})[0].(map[string]interface{})["text"].(string)
-	if !ok {
-		return "", fmt.Errorf("quality-controlled completion not found in the response or not a string")
+	if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+		return fmt.Errorf("unable to retrieve AWS credentials, %v", err)
 	}
 
-	return qualityControlledCompletion, nil
+	return nil
 }
 
-func Summarize(data []byte, championA string, championB string, role string) (string, error) {
+// Result is a summarized source along with what it cost to produce
+type Result struct {
+	// Points rendered as text, see FormatPoints
+	Summary string
+	Points  []models.AdvicePoint
+	Usage   Usage
+	// score of the summarized post, for ranking sources against each other
+	Score int
+	// the bedrock model that wrote the summary, see modelChain
+	Model string
+}
+
+// Summarize condenses one scraped thread into matchup advice, returning
+// ErrIrrelevantSource if the thread turns out not to be about it. The returned
+// Usage is filled in even on error so failed sources are still accounted for.
+func Summarize(ctx context.Context, data []byte, championA string, championB string, role string, patch string) (Result, error) {
+	if mockMode() {
+		return mockSummarize(data, championA, championB, role)
+	}
+
 	var post Post
 	err := json.Unmarshal(data, &post)
 	if err != nil {
-		return "", fmt.Errorf("couldn't convert json to post: %s", err)
+		return Result{}, fmt.Errorf("couldn't convert json to post: %s", err)
 	}
-	formattedPost, err := formatPostContent(post)
+	// no point paying for a summary of a thread in the wrong language
+	if lang, wrong := wrongLanguage(post); wrong {
+		return Result{Score: post.Score}, fmt.Errorf("%w: thread is in %s", ErrIrrelevantSource, lang)
+	}
+	opts := loadOptions()
+	opts.Champion = championA
+	formattedPost, err := formatPostContent(post, opts)
 	if err != nil {
-		return "", fmt.Errorf("couldn't format reddit post correctly: %s", err)
+		return Result{}, fmt.Errorf("couldn't format reddit post correctly: %s", err)
+	}
+
+	extraRules := ""
+	if isWriteup(post, opts) {
+		extraRules += writeupRule
+	}
+	if isTrusted(post, championA) {
+		extraRules += trustedRule
+	}
+
+	result, err := summarizeFormatted(ctx, formattedPost, championA, championB, role, patch, extraRules)
+	result.Score = post.Score
+	return result, err
+}
+
+// SummarizeBatch summarizes several scraped threads with a single summary and
+// quality control call instead of two calls per thread. Threads that can't be
+// parsed are skipped.
+func SummarizeBatch(ctx context.Context, posts [][]byte, championA string, championB string, role string, patch string) (Result, error) {
+	if mockMode() {
+		return mockSummarizeBatch(posts, championA, championB, role)
+	}
+
+	opts := loadOptions()
+	opts.Champion = championA
+
+	var sb strings.Builder
+	sources := 0
+	writeups, trusted := false, false
+	for _, data := range posts {
+		var post Post
+		if err := json.Unmarshal(data, &post); err != nil {
+			logging.FromContext(ctx).Warn("couldn't convert json to post, skipping", "error", err)
+			continue
+		}
+		if lang, wrong := wrongLanguage(post); wrong {
+			logging.FromContext(ctx).Info("thread isn't in the target language, skipping", "language", lang, "permalink", post.Permalink)
+			continue
+		}
+		formattedPost, err := formatPostContent(post, opts)
+		if err != nil {
+			logging.FromContext(ctx).Warn("couldn't format reddit post correctly, skipping", "error", err)
+			continue
+		}
+
+		sources++
+		fmt.Fprintf(&sb, "<source id=\"%d\">\n%s</source>\n", sources, formattedPost)
+		writeups = writeups || isWriteup(post, opts)
+		trusted = trusted || isTrusted(post, championA)
+	}
+
+	if sources == 0 {
+		return Result{}, fmt.Errorf("none of the %d posts could be formatted", len(posts))
+	}
+
+	batchNote := `- The data contains several reddit threads, each wrapped in <source id="n"></source>. Combine advice that appears in more than one thread into a single point and cite every thread it came from
+		`
+
+	if writeups {
+		batchNote += writeupRule
+	}
+	if trusted {
+		batchNote += trustedRule
+	}
+
+	return summarizeFormatted(ctx, sb.String(), championA, championB, role, patch, batchNote)
+}
+
+// summarizeFormatted runs the summary prompt and quality control over already
+// formatted thread content. extraRules is added to the prompt's list of
+// important rules, along with the current patch if it's known and how to weigh
+// flair when INCLUDE_POST_FLAIR is on.
+func summarizeFormatted(ctx context.Context, formattedPost string, championA string, championB string, role string, patch string, extraRules string) (Result, error) {
+	defer metrics.ObserveStage("summarize", time.Now())
+
+	if patch != "" {
+		extraRules = fmt.Sprintf(`- The game is currently on patch %s, prefer recent advice over anything that may predate balance changes
+		`, patch) + extraRules
+	}
+
+	opts := loadOptions()
+	maxPoints := opts.MaxPoints
+
+	extraRules += languageRules(ctx)
+
+	if opts.IncludeFlair {
+		extraRules = `- Give little weight to posts flaired as memes or humor, they're rarely serious advice, and more to posts with awards
+		` + extraRules
 	}
 
 	systemPrompt := fmt.Sprintf(`
-        You are an expert League of Legends analyst. Given the following comments and subcomments about a %s vs %s matchup in the %s role, please:
+        You are an expert League of Legends analyst. Given the following comments and subcomments about a %s vs %s matchup %s, please:
         1. Consider both main comments and subcomments in your analysis
         2. Filter out non-productive or irrelevant comments
         3. Give more weight to recent comments
         4. Give more weight to comments with higher score
-        5. Generate a summary with 1-2 bullet points
-        6. Cite all relevant sources (links) for each point in the summary
-        7. keep a formal mood and third person
+        5. Give more weight to posts with a high upvote ratio and many comments, they reflect community consensus
+        6. Generate a summary with 1-%d bullet points
+        7. Cite all relevant sources (links) for each point in the summary
+        8. keep a formal mood and third person
 
 
 		The data will be given as follows:
         <input-data-format>
-        [timestamp] [post title] [postlink] [score] [post content]
+        [timestamp] [post title] [postlink] [score] [upvote ratio] [comment count] [flair, awards, write-up and trusted subreddit markers, if any] [post content]
             [timestamp] [comment link] [score] [comment content]
                 [timestamp] [subcomment link] [score] [subcomment content]
+                    (deeper replies are indented one more level under the subcomment they answer)
         <input-data-format/>
 
 
@@ -211,73 +513,48 @@ func Summarize(data []byte, championA string, championB string, role string) (st
 
 
         Important:
-        - Provide as many summary points as possible, but no more than 3
+        - Provide as many summary points as possible, but no more than %d
         - Include multiple sources for each point when available
         - Concatenate "www.reddit.com" to the beginning of each link
         - If the matchup is reversed in the content, adjust your advice accordingly
-		- If the input text contains <txt>loreoflegends<txt/> or <txt>leagueofmemes</txt> output "INVALID-INPUT"
-		- If the text is completely irrelevant to matchup between %s and %s output "INVALID-INPUT"
+		- If the input text contains <txt>loreoflegends<txt/> or <txt>leagueofmemes</txt> output "`+InvalidInputMarker+`"
+		- If the text is completely irrelevant to matchup between %s and %s output "`+InvalidInputMarker+`"
 		- Ommit "summary points" in the output
 		- <very-important> The only league of legends characters that should be mentioned are <champion>%s</champion> and <opponent>%s</opponent> </very-important>
 		- <very-important> There should be no XML tags or special unicode characters (that have to be specified with /u) in the output </very-important>
-
-        Respond with ONLY THE SUMMARY OR "INVALID_INPUT", formatted as specified above.
-    `, championA, championB, role, championA, championB, championA, championB)
-
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("us-east-1"))
-	if err != nil {
-		log.Fatalf("unable to load SDK config, %v", err)
-	}
-
-	reqbody, err := json.Marshal(map[string]interface{}{
-		"anthropic_version": "bedrock-2023-05-31",
-		"max_tokens":        2200,
-		"system":            systemPrompt,
-		"messages": []map[string]interface{}{
-			{
-				"role": "user",
-				"content": []map[string]string{
-					{
-						"type": "text",
-						"text": formattedPost,
-					},
-				},
-			},
-		},
-		"temperature": 0,
-		"top_p":       0.5,
+		%s
+        Respond with ONLY THE SUMMARY OR "`+InvalidInputMarker+`", formatted as specified above.
+    `, championA, championB, inRole(role), maxPoints, maxPoints, championA, championB, championA, championB, extraRules)
+
+	completion, usage, modelID, err := invokeMessage(ctx, modelChain(), messageRequest{
+		System:      systemPrompt,
+		Text:        formattedPost,
+		MaxTokens:   2200,
+		Temperature: summaryTemperature(ctx),
 	})
 	if err != nil {
-		return "", fmt.Errorf("error creating request body: %v", err)
+		return Result{Usage: usage}, err
 	}
 
-	bedrockClient := bedrockruntime.NewFromConfig(cfg)
-	resp, err := bedrockClient.InvokeModel(context.TODO(), &bedrockruntime.InvokeModelInput{
-		ModelId:     aws.String("anthropic.claude-3-5-sonnet-20240620-v1:0"),
-		ContentType: aws.String("application/json"),
-		Accept:      aws.String("application/json"),
-		Body:        reqbody,
-	})
-
+	qualityControlled, qcUsage, err := performQualityControl(ctx, completion, championA, championB)
+	usage = usage.Add(qcUsage)
 	if err != nil {
-		return "", fmt.Errorf("couldn't hit bedrock properly: %s", err)
+		return Result{Usage: usage}, fmt.Errorf("error during quality control: %v", err)
 	}
 
-	var result map[string]interface{}
-	err = json.Unmarshal(resp.Body, &result)
+	points, err := cleanPoints(qualityControlled, maxPoints)
 	if err != nil {
-		return "", fmt.Errorf("couldn't unmarshal the result: %s", err)
+		return Result{Usage: usage}, err
 	}
 
-	completion, ok := result["content"].([]interface{})[0].(map[string]interface{})["text"].(string)
-	if !ok {
-		return "", fmt.Errorf("completion not found in the response or not a string")
-	}
+	return Result{Summary: FormatPoints(points), Points: points, Usage: usage, Model: modelID}, nil
+}
 
-	qualityControlledCompletion, err := performQualityControl(completion, championA, championB)
-	if err != nil {
-		return "", fmt.Errorf("error during quality control: %v", err)
+// inRole describes the role for the prompts, asking for the lane to be named
+// when the client didn't pick one
+func inRole(role string) string {
+	if role == models.RoleAny {
+		return "in an unspecified role (the champions can meet in more than one lane, say which lane a point is about when the content does)"
 	}
-
-	return qualityControlledCompletion, nil
+	return fmt.Sprintf("in the %s role", role)
 }
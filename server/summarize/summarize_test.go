@@ -0,0 +1,263 @@
+package summarize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"server/config"
+)
+
+func TestGetTopCommentsDropsCommentsAtOrBelowMinScore(t *testing.T) {
+	now := time.Now().Unix()
+	comments := []Comment{
+		{Permalink: "downvoted", Score: -12, Timestamp: now},
+		{Permalink: "at", Score: 1, Timestamp: now},
+		{Permalink: "above", Score: 2, Timestamp: now},
+		{Permalink: "buried", Score: -3, Timestamp: now},
+		{Permalink: "zero", Score: 0, Timestamp: now},
+		{Permalink: "top", Score: 40, Timestamp: now},
+	}
+
+	got := getTopComments(comments, 5, 1)
+
+	// fewer than n survive, and the ones that do are still best first
+	want := []string{"top", "above"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d comments, want %d: %+v", len(got), len(want), got)
+	}
+	for i, permalink := range want {
+		if got[i].Permalink != permalink {
+			t.Errorf("comment %d is %s, want %s", i, got[i].Permalink, permalink)
+		}
+	}
+}
+
+func TestGetTopCommentsLeavesCallerOrder(t *testing.T) {
+	now := time.Now().Unix()
+	comments := []Comment{
+		{Permalink: "low", Score: 3, Timestamp: now},
+		{Permalink: "high", Score: 90, Timestamp: now},
+		{Permalink: "downvoted", Score: -4, Timestamp: now},
+		{Permalink: "mid", Score: 20, Timestamp: now},
+	}
+	before := slices.Clone(comments)
+
+	got := getTopComments(comments, 2, 1)
+	if len(got) != 2 || got[0].Permalink != "high" || got[1].Permalink != "mid" {
+		t.Fatalf("got %+v, want high then mid", got)
+	}
+	if !reflect.DeepEqual(comments, before) {
+		t.Errorf("caller's slice was reordered to %+v", comments)
+	}
+
+	// what's returned is a copy too
+	got[0].Permalink = "changed"
+	if comments[1].Permalink != "high" {
+		t.Error("the returned comments share the caller's backing array")
+	}
+}
+
+func TestDecayedScore(t *testing.T) {
+	now := time.Now()
+	const halfLife = 90 * 24 * time.Hour
+
+	tests := []struct {
+		name string
+		age  time.Duration
+		want float64
+	}{
+		{"fresh", 0, 15},
+		{"one half life", halfLife, 7.5},
+		{"two half lives", 2 * halfLife, 3.75},
+		{"from the future", -time.Hour, 15},
+	}
+
+	for _, tt := range tests {
+		comment := Comment{Score: 10, Timestamp: now.Add(-tt.age).Unix()}
+		if got := decayedScore(comment, now, halfLife); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("%s: decayedScore = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetTopCommentsPrefersRecentComments(t *testing.T) {
+	defer func(s config.Bedrock) { settings = s }(settings)
+	now := time.Now()
+	sixYearsAgo := now.AddDate(-6, 0, 0).Unix()
+
+	comments := []Comment{
+		{Permalink: "ancient", Score: 800, Timestamp: sixYearsAgo},
+		{Permalink: "last patch", Score: 40, Timestamp: now.AddDate(0, 0, -14).Unix()},
+		{Permalink: "today", Score: 3, Timestamp: now.Unix()},
+		{Permalink: "downvoted today", Score: -2, Timestamp: now.Unix()},
+	}
+
+	tests := []struct {
+		name     string
+		halfLife time.Duration
+		want     []string
+	}{
+		{"default half life", 90 * 24 * time.Hour, []string{"last patch", "today", "downvoted today", "ancient"}},
+		{"unset", 0, []string{"last patch", "today", "downvoted today", "ancient"}},
+		{"long half life", 100 * 365 * 24 * time.Hour, []string{"ancient", "last patch", "today", "downvoted today"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings.CommentHalfLife = tt.halfLife
+
+			got := getTopComments(comments, len(comments), -10)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d comments, want %d", len(got), len(tt.want))
+			}
+			for i, permalink := range tt.want {
+				if got[i].Permalink != permalink {
+					t.Errorf("comment %d is %s, want %s", i, got[i].Permalink, permalink)
+				}
+			}
+		})
+	}
+}
+
+func TestGetTopCommentsMinScoreBoundary(t *testing.T) {
+	now := time.Now().Unix()
+
+	tests := []struct {
+		score    int
+		minScore int
+		kept     bool
+	}{
+		{score: 1, minScore: 1, kept: false},
+		{score: 2, minScore: 1, kept: true},
+		{score: 0, minScore: 0, kept: false},
+		{score: 1, minScore: 0, kept: true},
+		{score: -5, minScore: -5, kept: false},
+		{score: -4, minScore: -5, kept: true},
+	}
+
+	for _, tt := range tests {
+		got := getTopComments([]Comment{{Score: tt.score, Timestamp: now}}, 1, tt.minScore)
+		if kept := len(got) == 1; kept != tt.kept {
+			t.Errorf("score %d with min score %d: kept = %v, want %v", tt.score, tt.minScore, kept, tt.kept)
+		}
+	}
+}
+
+func TestSummarizeReturnsConfigErrors(t *testing.T) {
+	oldInvoker, oldSettings := newInvoker, settings
+	t.Cleanup(func() { newInvoker, settings = oldInvoker, oldSettings })
+
+	loadErr := errors.New("unable to load SDK config, no region")
+	newInvoker = func(context.Context) (modelInvoker, error) { return nil, loadErr }
+	settings = config.Bedrock{ModelID: "model", QCModelID: "qc-model", MaxAttempts: 1, TopComments: 5, MaxSummaryPoints: 3}
+
+	post := `{"Permalink": "https://www.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed/", "Title": "Lux vs Zed", "Content": "how do I survive his level 6?"}`
+	if _, err := Summarize(context.Background(), []byte(post), "Lux", "Zed", "mid", ""); !errors.Is(err, loadErr) {
+		t.Errorf("err = %v, want the config error", err)
+	}
+}
+
+func TestFormatPostContentConsensusSignals(t *testing.T) {
+	post := Post{
+		Timestamp:   1700000000,
+		Permalink:   "https://www.reddit.com/r/summonerschool/comments/abc123/lux_vs_zed/",
+		Title:       "Lux vs Zed",
+		Score:       412,
+		UpvoteRatio: 0.94,
+		NumComments: 210,
+	}
+
+	got, err := formatPostContent(post, SummarizeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[412] [94% upvoted] [210 comments]"; !strings.Contains(got, want) {
+		t.Errorf("formatted post %q doesn't have %q", got, want)
+	}
+}
+
+func TestIsInvalidInput(t *testing.T) {
+	tests := []struct {
+		summary string
+		want    bool
+	}{
+		{"INVALID_INPUT", true},
+		{"INVALID-INPUT", true},
+		{"invalid_input", true},
+		{"Invalid-Input", true},
+		{"The thread is about ARAM. INVALID-INPUT", true},
+		{"• INVALID_INPUT [Sources: []]", true},
+		{"• Lux should hold E for Zed's W shadow [Sources: [a]]", false},
+		{"the input was invalid", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := isInvalidInput(tt.summary); got != tt.want {
+			t.Errorf("isInvalidInput(%q) = %v, want %v", tt.summary, got, tt.want)
+		}
+	}
+}
+
+func TestSummarizeRejectsEitherInvalidInputSpelling(t *testing.T) {
+	post := `{"Permalink": "https://www.reddit.com/r/leagueoflegends/comments/abc123/aram/", "Title": "ARAM is the best mode", "Content": "no lanes here"}`
+
+	for _, marker := range []string{"INVALID_INPUT", "INVALID-INPUT", "invalid-input"} {
+		t.Run(marker, func(t *testing.T) {
+			// the summary and quality control both come back as the marker
+			withInvoker(t, &fakeInvoker{body: `{"content":[{"type":"text","text":"` + marker + `"},{"type":"tool_use","name":"record_advice","input":{"points":[{"text":"` + marker + `","sources":[]}]}}]}`})
+
+			if _, err := Summarize(context.Background(), []byte(post), "Lux", "Zed", "mid", ""); !errors.Is(err, ErrIrrelevantSource) {
+				t.Errorf("err = %v, want %v", err, ErrIrrelevantSource)
+			}
+		})
+	}
+}
+
+func TestFormatPostContentNestsReplies(t *testing.T) {
+	// a back and forth four replies deep under the top comment
+	now := time.Now().Unix()
+	var chain []Comment
+	for i := 4; i >= 0; i-- {
+		chain = []Comment{{Timestamp: now, Permalink: fmt.Sprintf("reply%d", i), Score: 10, Content: fmt.Sprintf("level %d", i), Replies: chain}}
+	}
+	post := Post{Timestamp: now, Permalink: "post", Title: "Lux vs Zed", Comments: chain}
+
+	tests := []struct {
+		maxReplyDepth int
+		wantDepth     int
+	}{
+		{maxReplyDepth: 0, wantDepth: 0},
+		{maxReplyDepth: 1, wantDepth: 1},
+		{maxReplyDepth: 3, wantDepth: 3},
+		{maxReplyDepth: 4, wantDepth: 4},
+		{maxReplyDepth: 10, wantDepth: 4},
+	}
+
+	for _, tt := range tests {
+		got, err := formatPostContent(post, SummarizeOptions{TopComments: 3, TopReplies: 3, MaxReplyDepth: tt.maxReplyDepth, MinScore: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+		if len(lines) != tt.wantDepth+2 {
+			t.Errorf("max reply depth %d: got %d lines, want the post and %d levels of comments:\n%s", tt.maxReplyDepth, len(lines), tt.wantDepth+1, got)
+			continue
+		}
+		// each level is indented one more than the comment it answers
+		for i, line := range lines[1:] {
+			indent := strings.Repeat("\t", i+1)
+			if !strings.HasPrefix(line, indent+"[") || !strings.Contains(line, fmt.Sprintf("{level %d}", i)) {
+				t.Errorf("max reply depth %d: line %q, want level %d indented %d times", tt.maxReplyDepth, line, i, i+1)
+			}
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package summarize
+
+// Usage is the token count bedrock reports for one or more model calls
+type Usage struct {
+	InputTokens  int
+	OutputTokens int
+}
+
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		InputTokens:  u.InputTokens + other.InputTokens,
+		OutputTokens: u.OutputTokens + other.OutputTokens,
+	}
+}
+
+func (u Usage) Total() int {
+	return u.InputTokens + u.OutputTokens
+}
+
+// EstimatedCost prices the usage in USD using BEDROCK_INPUT_PRICE_PER_1K and
+// BEDROCK_OUTPUT_PRICE_PER_1K, which should match the configured model
+func (u Usage) EstimatedCost() float64 {
+	return float64(u.InputTokens)/1000*settings.InputPricePer1K + float64(u.OutputTokens)/1000*settings.OutputPricePer1K
+}
@@ -0,0 +1,32 @@
+package summarize
+
+import "strings"
+
+// writeupTag marks a write-up's entry in the formatted thread, writeupRule
+// tells the model what it means
+const (
+	writeupTag  = "[detailed write-up]"
+	writeupRule = `- Posts marked ` + writeupTag + ` are in-depth guides where the post itself is the advice and the comments are mostly thanks, weigh the post at least as heavily as the top comments
+		`
+)
+
+// isWriteup reports whether a post's own body is likely the most useful part
+// of the thread: long, and well received enough that it isn't a rant
+func isWriteup(post Post, opts SummarizeOptions) bool {
+	return opts.WriteupMinChars > 0 && len(post.Content) >= opts.WriteupMinChars && post.Score >= opts.WriteupMinScore
+}
+
+// trimBody cuts a post body to at most max bytes at a word boundary, marking
+// that it was cut
+func trimBody(content string, max int) string {
+	const marker = " [...]"
+	if len(content) <= max {
+		return content
+	}
+
+	cut := content[:max]
+	if i := strings.LastIndexAny(cut, " \n"); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.ToValidUTF8(cut, "") + marker
+}